@@ -0,0 +1,47 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/spf13/viper"
+)
+
+// authorityDir 返回签发机构文件所在的目录, name 为空表示根 CA
+func authorityDir(name string) string {
+	return authority.Dir(filepath.Dir(viper.ConfigFileUsed()), name)
+}
+
+// localCA 命令行进程内共用的 CA, 签发机构的私钥只读取一次
+var localCA *ca.CA
+
+// getCA 返回配置目录中的 CA
+func getCA() (*ca.CA, error) {
+	if localCA == nil {
+		c, err := ca.NewCA(ca.Options{ConfigDir: filepath.Dir(viper.ConfigFileUsed())})
+		if err != nil {
+			return nil, err
+		}
+		localCA = c
+	}
+	return localCA, nil
+}
+
+// loadAuthority 读取签发机构的证书, 私钥以及证书链, name 为空表示根 CA
+func loadAuthority(name string) (*authority.Authority, error) {
+	c, err := getCA()
+	if err != nil {
+		return nil, err
+	}
+	return c.Authority(name)
+}
+
+func authorityName(name string) string {
+	return authority.DisplayName(name)
+}
@@ -7,28 +7,33 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/rand"
-	"encoding/base64"
+	"context"
 	"encoding/pem"
 	"fmt"
-	"math/big"
 	"os"
+	"os/user"
 	"path/filepath"
-	"time"
+	"strings"
 
 	"github.com/google/uuid"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/store"
 	"github.com/pkg/errors"
 
-	"github.com/emmansun/gmsm/pkcs7"
 	"github.com/tjfoc/gmsm/x509"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
-	Csr    string
-	Output string
+	Csr        string
+	Output     string
+	Profile    string
+	EncCsr     string
+	EncProfile string
+	Bundle     bool
+	Requester  string
 )
 
 // certCmd represents the cert command
@@ -42,159 +47,179 @@ var certCmd = &cobra.Command{
 }
 
 func generateCert() error {
-	configDir := filepath.Dir(viper.ConfigFileUsed())
-
-	// 读取CSR文件
-	csrPEM, err := os.ReadFile(Csr)
+	csr, err := readCsr(Csr)
 	if err != nil {
 		return err
 	}
 
-	// 解码CSR文件
-	csrBlock, _ := pem.Decode(csrPEM)
-	if csrBlock == nil || csrBlock.Type != "CERTIFICATE REQUEST" {
-		return errors.New("type is not CERTIFICATE REQUEST")
+	if EncCsr != "" {
+		return generateDualCert(csr)
 	}
-	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
-	if err != nil {
-		return err
-	}
-
-	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: csr.RawSubjectPublicKeyInfo})
 
-	pub, err := x509.ReadPublicKeyFromPem(pubPEM)
+	// 签发机构默认为根 CA
+	cert, err := issueCert(Issuer, csr, Profile)
 	if err != nil {
 		return err
 	}
 
-	// 申请序列号
-	// 随机生成一个
-	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<63-1))
-	if err != nil {
-		return err
-	}
+	return writeCert(certFileName(csr), cert)
+}
 
-	// 读取机构 ca 文件
-	caPEM, err := os.ReadFile(filepath.Join(configDir, "ca.cert"))
-	if err != nil {
-		return err
+// generateDualCert 签发 TLCP 所需的签名证书和加密证书
+func generateDualCert(signCsr *x509.CertificateRequest) error {
+	if Output == "pkcs12" {
+		return errors.New("pkcs12 output does not support sign and enc certs, use export p12 for each cert")
 	}
-
-	// 解码 ca 文件
-	caBlock, _ := pem.Decode(caPEM)
+	encCsr, err := readCsr(EncCsr)
 	if err != nil {
 		return err
 	}
 
-	ca, err := x509.ParseCertificate(caBlock.Bytes)
-	if err != nil {
-		return err
+	// 签名证书和加密证书属于同一个实体, 但必须使用不同的密钥对
+	if !bytes.Equal(signCsr.RawSubject, encCsr.RawSubject) {
+		return errors.New("subject of sign csr and enc csr is not the same")
 	}
-
-	// 读取机构 ca 私钥
-	caKey, err := os.ReadFile(filepath.Join(configDir, "ca.key"))
-	if err != nil {
-		return err
+	if bytes.Equal(signCsr.RawSubjectPublicKeyInfo, encCsr.RawSubjectPublicKeyInfo) {
+		return errors.New("sign csr and enc csr must use different key pairs")
 	}
 
-	privateKey, err := x509.ReadPrivateKeyFromPem(caKey, nil)
+	signProfile := Profile
+	if signProfile == "" {
+		signProfile = "tlcp-sign"
+	}
+	signCert, err := issueCert(Issuer, signCsr, signProfile)
 	if err != nil {
 		return err
 	}
 
-	// 创建证书模板
-	// 获取签发证书的时间
-	var year, month, day int
-	i := viper.GetIntSlice("expiration")
-	if len(i) != 3 {
-		year = 100
-	} else {
-		year = i[0]
-		month = i[1]
-		day = i[2]
-	}
-	template := &x509.Certificate{
-		SerialNumber:          serialNumber,
-		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(year, month, day),
-		SubjectKeyId:          []byte{1, 2, 3, 4, 6},
-		KeyUsage:              x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection},
-		PublicKeyAlgorithm:    csr.PublicKeyAlgorithm,
-		SignatureAlgorithm:    csr.SignatureAlgorithm,
-		DNSNames:              csr.DNSNames,
-		CRLDistributionPoints: viper.GetStringSlice("CRLDistributionPoints"),
-		OCSPServer:            viper.GetStringSlice("OCSPServer"),
-	}
-
-	// 使用SM2密钥对签名证书
-	derBytes, err := x509.CreateCertificate(template, ca, pub, privateKey)
+	encCert, err := issueCert(Issuer, encCsr, EncProfile)
 	if err != nil {
 		return err
 	}
 
-	// 将证书转换为PEM格式
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-
-	if Output == "pem" {
-		generatedCert := filepath.Join(Path, fmt.Sprintf("%s-%s-%s.cert", csr.Subject.CommonName, csr.Subject.OrganizationalUnit[0], uuid.New().String()[:6]))
-		pem := savaCertToPem(certPEM, caPEM)
-		_ = os.WriteFile(generatedCert, pem, 0o755)
-		return nil
-	}
-
-	if Output == "pkcs7" {
-		generatedCert := filepath.Join(Path, fmt.Sprintf("%s-%s-%s.p7b", csr.Subject.CommonName, csr.Subject.OrganizationalUnit[0], uuid.New().String()[:6]))
-		p7b, err := saveCertToPkcs7(certPEM, caPEM)
+	name := certFileName(signCsr)
+	if Bundle {
+		// 单个文件, 顺序为 签名证书 -> 加密证书 -> 中间 CA -> 根 CA
+		b, err := encCert.Encode(ca.FormatPEM)
 		if err != nil {
 			return err
 		}
+		return os.WriteFile(filepath.Join(Path, name+".tlcp.cert"), append(signCert.PEM(), b...), 0o644)
+	}
 
-		_ = os.WriteFile(generatedCert, p7b, 0o755)
-		return nil
+	if err = writeCert(name+".sign", signCert); err != nil {
+		return err
 	}
-	return errors.Errorf("not suuport output %s", Output)
+	return writeCert(name+".enc", encCert)
 }
 
-func saveCertToPkcs7(cert []byte, ca []byte) ([]byte, error) {
-	b := savaCertToPem(cert, ca)
+// readCsr 读取并解码 csr 文件
+func readCsr(path string) (*x509.CertificateRequest, error) {
+	// 读取CSR文件
+	csrPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-	buffer := &bytes.Buffer{}
-	for {
-		block, rest := pem.Decode(b)
-		if block == nil {
-			break
-		}
-		buffer.Write(block.Bytes)
-		b = rest
+	// 解码CSR文件
+	csrBlock, _ := pem.Decode(csrPEM)
+	if csrBlock == nil || csrBlock.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("type is not CERTIFICATE REQUEST")
 	}
+	return x509.ParseCertificateRequest(csrBlock.Bytes)
+}
 
-	p7b, err := pkcs7.DegenerateCertificate(buffer.Bytes())
+// issueCert 使用签发机构和证书模板, 根据 csr 签发证书, issuerName 为空表示根 CA
+func issueCert(issuerName string, csr *x509.CertificateRequest, profileName string) (*ca.Certificate, error) {
+	c, err := getCA()
 	if err != nil {
 		return nil, err
 	}
+	return c.IssueFromCSR(context.Background(), csr, profileName, ca.IssueOptions{Issuer: issuerName, Requester: requester()})
+}
 
-	s := base64.StdEncoding.EncodeToString(p7b)
+// recordCert 将签发的证书记录到证书清单中
+func recordCert(s *store.Store, issuerName string, profileName string, der []byte) error {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
 
-	return []byte(s), nil
+	return s.AddCertificate(store.NewRecord(cert, issuerName, profileName, requester()))
 }
 
-func savaCertToPem(cert []byte, ca []byte) []byte {
-	buffer := &bytes.Buffer{}
+// requester 记录到证书清单中的申请人, 默认为当前用户
+func requester() string {
+	if Requester != "" {
+		return Requester
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
 
-	buffer.Write(ca)
-	buffer.Write([]byte("\n"))
-	buffer.Write(cert)
+// certFileName 生成证书文件名, 不包含后缀
+func certFileName(csr *x509.CertificateRequest) string {
+	ou := ""
+	if len(csr.Subject.OrganizationalUnit) > 0 {
+		ou = csr.Subject.OrganizationalUnit[0]
+	}
+	return fmt.Sprintf("%s-%s-%s", csr.Subject.CommonName, ou, uuid.New().String()[:6])
+}
 
-	return buffer.Bytes()
+// writeCert 根据输出格式将证书以及证书链写入文件
+func writeCert(name string, cert *ca.Certificate) error {
+	switch Output {
+	case ca.FormatPEM:
+		b, err := cert.Encode(ca.FormatPEM)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(Path, name+".cert"), b, 0o644)
+	case ca.FormatPKCS7:
+		b, err := cert.Encode(ca.FormatPKCS7)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(Path, name+".p7b"), b, 0o644)
+	case "pkcs12":
+		keyFile := KeyFile
+		if keyFile == "" {
+			// 默认使用 csr 同目录下的私钥
+			keyFile = strings.TrimSuffix(Csr, filepath.Ext(Csr)) + ".key"
+		}
+		key, err := keyfile.ReadFile(keyFile, keyfile.LeafPassphrase())
+		if err != nil {
+			return err
+		}
+		chain, err := pemCerts(cert.Chain)
+		if err != nil {
+			return err
+		}
+		p12, err := encodePKCS12(key, append([][]byte{cert.Cert.Raw}, chain...), "")
+		if err != nil {
+			return err
+		}
+		return keyfile.WriteFile(filepath.Join(Path, name+".p12"), p12)
+	}
+	return errors.Errorf("not suuport output %s", Output)
 }
 
 func init() {
 	rootCmd.AddCommand(certCmd)
 
 	certCmd.Flags().StringVarP(&Csr, "csr", "", "", "set csr file path")
-	certCmd.Flags().StringVarP(&Output, "output", "o", "pem", "set cert block type")
+	certCmd.Flags().StringVarP(&Output, "output", "o", "pem", "set output format, support pem, pkcs7 and pkcs12")
+	certCmd.Flags().StringVarP(&KeyFile, "key", "", "", "set private key file for pkcs12 output, default key file next to csr")
+	certCmd.Flags().StringVarP(&Profile, "profile", "", "", "set profile, such as server, client, codesigning, ca, ocsp, tlcp-sign, tlcp-enc")
+	certCmd.Flags().StringVarP(&Issuer, "issuer", "", "", "set issuer intermediate ca name, empty means root ca")
+
+	certCmd.Flags().StringVarP(&EncCsr, "enc-csr", "", "", "set tlcp encryption csr file path, issue sign and enc certs together")
+	certCmd.Flags().StringVarP(&EncProfile, "enc-profile", "", "tlcp-enc", "set profile of tlcp encryption cert")
+	certCmd.Flags().BoolVarP(&Bundle, "bundle", "", false, "save tlcp sign cert, enc cert and chain to a single pem file")
+
+	certCmd.Flags().StringVarP(&Requester, "requester", "", "", "set requester recorded in the certificate inventory, default current user")
 
 	_ = certCmd.MarkFlagRequired("csr")
 }
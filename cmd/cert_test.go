@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTestConfig 在临时目录中创建配置文件以及根 CA, 输出目录为 Path, 结束时恢复全局状态
+func newTestConfig(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configFile, []byte("[ca]\nCN = \"test root\"\npathLen = -1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := func() {
+		viper.Reset()
+		localCA = nil
+		Path, Csr, EncCsr, KeyFile, CertFile = "", "", "", "", ""
+		Issuer, Profile, Requester = "", "", ""
+		Output, EncProfile, Bundle = ca.FormatPEM, "tlcp-enc", false
+	}
+	reset()
+	t.Cleanup(reset)
+
+	viper.SetConfigFile(configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	Path, Requester = t.TempDir(), "tester"
+	if err := generateAuthorityRootCA(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newTestCsr 在 Path 下生成私钥和 csr, 返回 csr 文件路径
+func newTestCsr(t *testing.T, name string, subject pkix.Name) string {
+	t.Helper()
+	if err := generateKeyAndCsr(Path, name, ca.CSROptions{Subject: subject, DNSNames: []string{"node1.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(Path, name+".csr")
+}
+
+// readTestCerts 读取 PEM 文件中的所有证书
+func readTestCerts(t *testing.T, path string) []*x509.Certificate {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for {
+		block, rest := pem.Decode(b)
+		if block == nil {
+			return certs
+		}
+		b = rest
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// findTestFile 返回 Path 下唯一以 suffix 结尾的文件
+func findTestFile(t *testing.T, suffix string) string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(Path, "*"+suffix))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files with suffix %s = %v, %v, want one", suffix, files, err)
+	}
+	return files[0]
+}
+
+func TestGenerateDualCert(t *testing.T) {
+	node1 := pkix.Name{CommonName: "node1", Organization: []string{"org1"}}
+
+	tests := []struct {
+		name    string
+		output  string
+		bundle  bool
+		subject pkix.Name
+		// sameKey 加密证书的 csr 使用签名证书的密钥
+		sameKey bool
+		wantErr string
+	}{
+		{name: "separate files", subject: node1},
+		{name: "bundle", bundle: true, subject: node1},
+		{name: "subject", subject: pkix.Name{CommonName: "node2", Organization: []string{"org1"}}, wantErr: "subject of sign csr and enc csr"},
+		{name: "same key", subject: node1, sameKey: true, wantErr: "different key pairs"},
+		{name: "pkcs12", output: "pkcs12", subject: node1, wantErr: "pkcs12 output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestConfig(t)
+			Csr = newTestCsr(t, "node1.sign", node1)
+			EncCsr = newTestCsr(t, "node1.enc", tt.subject)
+			if tt.sameKey {
+				key, err := keyfile.ReadFile(filepath.Join(Path, "node1.sign.key"), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = writeCsr(Path, "node1.enc", ca.CSROptions{Subject: tt.subject}, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.output != "" {
+				Output = tt.output
+			}
+			Bundle = tt.bundle
+
+			err := generateCert()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("generateCert() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var sign, enc *x509.Certificate
+			if tt.bundle {
+				// 签名证书 -> 加密证书 -> 根 CA
+				certs := readTestCerts(t, findTestFile(t, ".tlcp.cert"))
+				if len(certs) != 3 {
+					t.Fatalf("bundle certs = %d, want 3", len(certs))
+				}
+				sign, enc = certs[0], certs[1]
+			} else {
+				sign = readTestCerts(t, findTestFile(t, ".sign.cert"))[0]
+				enc = readTestCerts(t, findTestFile(t, ".enc.cert"))[0]
+			}
+			if sign.KeyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment {
+				t.Errorf("sign key usage = %v", sign.KeyUsage)
+			}
+			if enc.KeyUsage != x509.KeyUsageKeyEncipherment|x509.KeyUsageDataEncipherment|x509.KeyUsageKeyAgreement {
+				t.Errorf("enc key usage = %v", enc.KeyUsage)
+			}
+			if bytes.Equal(sign.RawSubjectPublicKeyInfo, enc.RawSubjectPublicKeyInfo) {
+				t.Error("sign cert and enc cert use the same key")
+			}
+		})
+	}
+}
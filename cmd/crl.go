@@ -0,0 +1,58 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/spf13/cobra"
+)
+
+/*
+	根据 CA 状态中的吊销记录重新生成签发机构的 CRL, CRL Number 单调递增.
+
+	下次更新时间以及输出格式可通过命令行参数或配置文件指定, 生成逻辑位于 pkg/ca:
+
+	[crl]
+	nextUpdate = "168h"
+	format = "der"
+
+	der 格式保存为 crl.crl, pem 格式保存为 crl.pem, 位于签发机构的目录下, 生成时删除另一种格式的旧文件.
+*/
+
+var (
+	NextUpdate time.Duration
+	CRLFormat  string
+)
+
+// crlCmd represents the crl command
+var crlCmd = &cobra.Command{
+	Use:   "crl",
+	Short: "generate crl",
+	Long:  `generate crl of the root ca or an intermediate ca from revoked certificates`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return generateCRL(Issuer)
+	},
+}
+
+// generateCRL 重新生成签发机构的 CRL
+func generateCRL(issuerName string) error {
+	c, err := getCA()
+	if err != nil {
+		return err
+	}
+	return c.GenerateCRL(context.Background(), issuerName, ca.CRLOptions{NextUpdate: NextUpdate, Format: CRLFormat})
+}
+
+func init() {
+	rootCmd.AddCommand(crlCmd)
+
+	crlCmd.Flags().StringVarP(&Issuer, "issuer", "", "", "set issuer intermediate ca name, empty means root ca")
+	crlCmd.Flags().DurationVarP(&NextUpdate, "next-update", "", 0, "set duration until next update, default 168h")
+	crlCmd.Flags().StringVarP(&CRLFormat, "format", "", "", "set crl format, support der and pem, default der")
+}
@@ -6,16 +6,20 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
-	"crypto/rand"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 
-	ssm2 "github.com/emmansun/gmsm/sm2"
-	"github.com/emmansun/gmsm/smx509"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/subject"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/tjfoc/gmsm/sm2"
 	"github.com/tjfoc/gmsm/x509"
 )
@@ -30,6 +34,25 @@ import (
 	2. 公钥, 从生成的私钥中取出公钥, 保存在文件中
 	3. 根据私钥生成 csr 证书签名文件, 可选择签名算法, 默认只支持 sm2-sha256
 
+	主题可以通过 --subject 指定完整的 DN (如 /C=CN/O=Org/OU=peer/CN=node1), 也可以通过 --CN, --O, --OU, --C, --ST, --L,
+	--street, --postal-code, --serial-number 以及 --attr OID=value 分别指定, 单独指定的参数优先于 --subject.
+	C, ST, L, street, postalCode 以及 attrs 可以在配置文件中设置默认值, 未指定 --subject 时生效:
+
+	[csr]
+	C = ["CN"]
+	ST = ["浙江省"]
+	L = ["杭州市"]
+	street = []
+	postalCode = []
+	attrs = ["2.5.4.13=blockchain node"]
+
+	SAN 可以通过 --addr (DNS, 可以解析为 IP 的自动作为 IP), --ip, --email, --uri 指定.
+
+	指定 --key 时使用已有的私钥 (PKCS#8, EC PRIVATE KEY 或加密的 PKCS#8) 生成 csr, 只生成公钥和 csr 文件, 用于使用同一私钥续签证书.
+
+	指定 --dual 时, 按照 GB/T 38636 TLCP 的要求分别生成签名和加密两套文件 <CN>.sign.* 以及 <CN>.enc.*,
+	再通过 cert --csr <CN>.sign.csr --enc-csr <CN>.enc.csr 签发签名证书和加密证书.
+
 */
 
 var (
@@ -38,7 +61,16 @@ var (
 	OU   []string
 	Addr []string
 
-	EC bool
+	IPs    []string
+	Emails []string
+	URIs   []string
+
+	Subject      string
+	SerialNumber string
+	KeyFile      string
+
+	EC   bool
+	Dual bool
 )
 
 // csrCmd represents the csr command
@@ -47,111 +79,206 @@ var csrCmd = &cobra.Command{
 	Short: "generate csr",
 	Long:  `generate csr`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if CN == "" {
-			cobra.CheckErr("cn is empty")
-		}
-		return generateCsr()
+		return generateCsr(cmd)
 	},
 }
 
-func generateCsr() error {
-	var (
-		generatedKey = filepath.Join(Path, fmt.Sprintf("%s.key", CN))
-		generatedPub = filepath.Join(Path, fmt.Sprintf("%s.pub", CN))
-		generatedCsr = filepath.Join(Path, fmt.Sprintf("%s.csr", CN))
-	)
-
-	privateKey, err := sm2.GenerateKey(rand.Reader)
+func generateCsr(cmd *cobra.Command) error {
+	name, err := csrSubject(cmd)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	// 将私钥保存到文件
-	privateKeyBytes, err := x509.MarshalSm2PrivateKey(privateKey, nil)
-	if err != nil {
+	if name.CommonName == "" {
+		return errors.New("cn is empty, set it by --CN or --subject")
+	}
+
+	// 创建证书签名请求模板
+	opts := ca.CSROptions{Subject: name}
+	if err = setSANs(&opts); err != nil {
 		return err
 	}
-	privateKeyPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	})
-	pubKey, _ := privateKey.Public().(*sm2.PublicKey)
-	publicKeyPem, err := x509.WritePublicKeyToPem(pubKey)
-	if err != nil {
+
+	// 文件名使用 CN
+	if KeyFile != "" {
+		// 使用已有的私钥, 不会生成新的私钥文件
+		if Dual || EC {
+			return errors.New("key can not be used with dual or ec")
+		}
+		privateKey, err := keyfile.ReadFile(KeyFile, keyfile.LeafPassphrase())
+		if err != nil {
+			return err
+		}
+		_, err = writeCsr(Path, name.CommonName, opts, privateKey)
 		return err
 	}
-	err = os.WriteFile(generatedPub, publicKeyPem, 0o755)
+	if Dual {
+		// TLCP 双证书: 签名密钥对和加密密钥对分别生成 csr
+		if err = generateKeyAndCsr(Path, name.CommonName+".sign", opts); err != nil {
+			return err
+		}
+		return generateKeyAndCsr(Path, name.CommonName+".enc", opts)
+	}
+	return generateKeyAndCsr(Path, name.CommonName, opts)
+}
+
+// csrSubject 根据 --subject 以及各个主题参数生成 csr 的主题, 指定了 --subject 时只使用显式指定的参数覆盖对应字段
+func csrSubject(cmd *cobra.Command) (pkix.Name, error) {
+	var (
+		name pkix.Name
+		err  error
+	)
+	if Subject != "" {
+		if name, err = subject.ParseDN(Subject); err != nil {
+			return name, err
+		}
+	}
+	set := func(flag string) bool {
+		return Subject == "" || cmd.Flags().Changed(flag)
+	}
+
+	if set("CN") {
+		name.CommonName = CN
+	}
+	if set("O") {
+		name.Organization = O
+	}
+	if set("OU") {
+		name.OrganizationalUnit = OU
+	}
+	if set("C") {
+		name.Country = viper.GetStringSlice("csr.C")
+	}
+	if set("ST") {
+		name.Province = viper.GetStringSlice("csr.ST")
+	}
+	if set("L") {
+		name.Locality = viper.GetStringSlice("csr.L")
+	}
+	if set("street") {
+		name.StreetAddress = viper.GetStringSlice("csr.street")
+	}
+	if set("postal-code") {
+		name.PostalCode = viper.GetStringSlice("csr.postalCode")
+	}
+	if set("serial-number") {
+		name.SerialNumber = SerialNumber
+	}
+	if set("attr") {
+		// viper 读取 StringArray 参数时会按逗号拆分, 参数值中可能包含逗号, 指定了参数时直接读取参数
+		attrs := viper.GetStringSlice("csr.attrs")
+		if cmd.Flags().Changed("attr") {
+			if attrs, err = cmd.Flags().GetStringArray("attr"); err != nil {
+				return name, err
+			}
+		}
+		for _, v := range attrs {
+			if err = subject.ParseAttr(&name, v); err != nil {
+				return name, err
+			}
+		}
+	}
+	return name, nil
+}
+
+// setSANs 根据 --addr, --ip, --email, --uri 设置 csr 的 SAN
+func setSANs(opts *ca.CSROptions) error {
+	opts.EmailAddresses = Emails
+	for _, v := range Addr {
+		if ip := net.ParseIP(v); ip != nil {
+			opts.IPAddresses = append(opts.IPAddresses, ip)
+		} else {
+			opts.DNSNames = append(opts.DNSNames, v)
+		}
+	}
+	for _, v := range IPs {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return errors.Errorf("invalid ip %s", v)
+		}
+		opts.IPAddresses = append(opts.IPAddresses, ip)
+	}
+	for _, v := range URIs {
+		u, err := san.ParseURI(v)
+		if err != nil {
+			return err
+		}
+		opts.URIs = append(opts.URIs, u)
+	}
+	return nil
+}
+
+// generateKeyAndCsr 在 dir 目录下生成私钥, 公钥以及 csr, 文件名为 name 加上对应的后缀
+func generateKeyAndCsr(dir string, name string, opts ca.CSROptions) error {
+	generatedKey := filepath.Join(dir, fmt.Sprintf("%s.key", name))
+
+	key, err := ca.NewKey(ca.KeyOptions{Algorithm: ca.KeySM2})
 	if err != nil {
 		return err
 	}
+	privateKey := key.(*sm2.PrivateKey)
 
+	// 将私钥保存到文件, 配置了口令时加密保存
 	if EC {
-		ecPrivateKeyPem, err := transPkcs8PrivateKeyToEcPrivateKey(privateKeyBytes)
+		// EC PRIVATE KEY 不支持加密
+		if viper.GetBool("key.encrypt") || keyfile.LeafPassphrase().Available() {
+			return errors.New("ec private key can not be encrypted")
+		}
+		ecPrivateKeyPem, err := keyfile.MarshalSEC1(privateKey)
 		if err != nil {
 			return err
 		}
-		err = os.WriteFile(generatedKey, ecPrivateKeyPem, 0o755)
+		err = keyfile.WriteFile(generatedKey, ecPrivateKeyPem)
 		if err != nil {
 			return err
 		}
 	} else {
-		err = os.WriteFile(generatedKey, privateKeyPem, 0o755)
+		privateKeyPem, err := keyfile.Encode(privateKey, keyfile.LeafPassphrase())
+		if err != nil {
+			return err
+		}
+		err = keyfile.WriteFile(generatedKey, privateKeyPem)
 		if err != nil {
 			return err
 		}
 	}
 
-	// 创建证书签名请求模板
-	template := x509.CertificateRequest{
-		Subject: pkix.Name{
-			CommonName:         CN,
-			Organization:       O,
-			OrganizationalUnit: OU,
-			Province:           []string{"浙江省"},
-			Locality:           []string{"杭州市"},
-			Country:            []string{"CN"},
-		},
-		SignatureAlgorithm: x509.SM2WithSM3,
-		PublicKeyAlgorithm: x509.PublicKeyAlgorithm(x509.SM2WithSM3),
-		DNSNames:           Addr,
-	}
-
-	// 生成证书签名请求
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
-	if err != nil {
-		return err
-	}
+	_, err = writeCsr(dir, name, opts, privateKey)
+	return err
+}
 
-	// 将证书签名请求保存到文件
-	csrPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: csrBytes,
-	})
+// writeCsr 使用私钥签名 csr, 在 dir 目录下保存公钥以及 csr, 文件名为 name 加上对应的后缀
+func writeCsr(dir string, name string, opts ca.CSROptions, privateKey *sm2.PrivateKey) (*x509.CertificateRequest, error) {
+	var (
+		generatedPub = filepath.Join(dir, fmt.Sprintf("%s.pub", name))
+		generatedCsr = filepath.Join(dir, fmt.Sprintf("%s.csr", name))
+	)
 
-	err = os.WriteFile(generatedCsr, csrPem, 0o755)
+	publicKeyPem, err := x509.WritePublicKeyToPem(&privateKey.PublicKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
-}
-
-func transPkcs8PrivateKeyToEcPrivateKey(der []byte) ([]byte, error) {
-	// 将 pkcs8 格式的私钥转化成 ecPrivateKey
-	key, err := smx509.ParsePKCS8PrivateKey(der)
+	err = os.WriteFile(generatedPub, publicKeyPem, 0o644)
 	if err != nil {
 		return nil, err
 	}
-	b, err := smx509.MarshalSM2PrivateKey(key.(*ssm2.PrivateKey))
+
+	// 生成证书签名请求
+	csr, err := ca.NewCSR(privateKey, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	// 将证书签名请求保存到文件
-	pem := pem.EncodeToMemory(&pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: b,
+	csrPem := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: csr.Raw,
 	})
 
-	return pem, nil
+	err = os.WriteFile(generatedCsr, csrPem, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return csr, nil
 }
 
 func init() {
@@ -160,11 +287,33 @@ func init() {
 	csrCmd.Flags().StringVarP(&CN, "CN", "", "", "set CommonName")
 	csrCmd.Flags().StringSliceVarP(&O, "O", "", nil, "set Organization")
 	csrCmd.Flags().StringSliceVarP(&OU, "OU", "", nil, "set OrganizationUnit")
-	csrCmd.Flags().StringSliceVarP(&Addr, "addr", "", nil, "set dns addr")
+	csrCmd.Flags().StringSlice("C", []string{"CN"}, "set Country")
+	csrCmd.Flags().StringSlice("ST", nil, "set Province")
+	csrCmd.Flags().StringSlice("L", nil, "set Locality")
+	csrCmd.Flags().StringSlice("street", nil, "set StreetAddress")
+	csrCmd.Flags().StringSlice("postal-code", nil, "set PostalCode")
+	csrCmd.Flags().StringVarP(&SerialNumber, "serial-number", "", "", "set subject SerialNumber")
+	csrCmd.Flags().StringArray("attr", nil, "add subject attribute as OID=value, such as 2.5.4.13=description")
+	csrCmd.Flags().StringVarP(&Subject, "subject", "", "", "set full subject, such as /C=CN/O=Org/OU=peer/CN=node1")
+	csrCmd.Flags().StringSliceVarP(&Addr, "addr", "", nil, "set dns addr, ip addresses are detected automatically")
+	csrCmd.Flags().StringSliceVarP(&IPs, "ip", "", nil, "set ip addr")
+	csrCmd.Flags().StringSliceVarP(&Emails, "email", "", nil, "set email addr")
+	csrCmd.Flags().StringSliceVarP(&URIs, "uri", "", nil, "set uri, such as spiffe://example.com/node1")
 
 	csrCmd.Flags().StringVarP(&Path, "path", "p", "", "save path")
+	csrCmd.Flags().StringVarP(&KeyFile, "key", "", "", "use existing private key instead of generating a new one, support pkcs8, ec private key and encrypted pkcs8")
 
 	csrCmd.Flags().BoolVarP(&EC, "ec", "", false, "trans pkcs8 private key to ec private key")
+	csrCmd.Flags().BoolVarP(&Dual, "dual", "", false, "generate tlcp sign and enc key pairs and csrs")
 
-	_ = csrCmd.MarkFlagRequired("CN")
+	for key, flag := range map[string]string{
+		"csr.C":          "C",
+		"csr.ST":         "ST",
+		"csr.L":          "L",
+		"csr.street":     "street",
+		"csr.postalCode": "postal-code",
+		"csr.attrs":      "attr",
+	} {
+		_ = viper.BindPFlag(key, csrCmd.Flags().Lookup(flag))
+	}
 }
@@ -0,0 +1,164 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/pkcs12"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	导出 PKCS#12 (.p12/.pfx), 供只支持 keystore 的 Java 以及 Windows 使用.
+
+	jcert-gm export p12 --cert node1.cert --key node1.key -o node1.p12
+	jcert-gm export p12 --cert node1.cert --key node1.key --p12-cipher 3des
+
+	证书文件中只有终端证书并且没有指定 --chain 时, 使用配置目录中签发了该证书的签发机构的证书链.
+	加密算法由 --p12-cipher 决定, 默认 sm4, 不支持国密的 Java 以及 Windows 使用 3des 或 aes.
+*/
+
+var (
+	ChainFile    string
+	FriendlyName string
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export cert and key to other formats",
+	Long:  `export cert and key to other formats`,
+}
+
+// exportP12Cmd represents the export p12 command
+var exportP12Cmd = &cobra.Command{
+	Use:   "p12",
+	Short: "export key, cert and chain to pkcs12",
+	Long:  `export sm2 private key, cert and chain to a password protected pkcs12 (.p12/.pfx) keystore`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return exportP12()
+	},
+}
+
+func exportP12() error {
+	certs, err := readCerts(CertFile)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.Errorf("no cert found in %s", CertFile)
+	}
+	key, err := keyfile.ReadFile(KeyFile, keyfile.LeafPassphrase())
+	if err != nil {
+		return err
+	}
+
+	var ders [][]byte
+	for _, v := range certs {
+		ders = append(ders, v.Raw)
+	}
+	switch {
+	case ChainFile != "":
+		chain, err := readCerts(ChainFile)
+		if err != nil {
+			return err
+		}
+		for _, v := range chain {
+			ders = append(ders, v.Raw)
+		}
+	case len(certs) == 1:
+		// 只有终端证书时补充签发机构的证书链
+		name, err := findIssuer(filepath.Dir(viper.ConfigFileUsed()), certs[0])
+		if err != nil {
+			return errors.Wrap(err, "find chain, set --chain")
+		}
+		issuer, err := loadAuthority(name)
+		if err != nil {
+			return err
+		}
+		chain, err := pemCerts(issuer.Chain)
+		if err != nil {
+			return err
+		}
+		ders = append(ders, chain...)
+	}
+
+	p12, err := encodePKCS12(key, ders, FriendlyName)
+	if err != nil {
+		return err
+	}
+
+	out := OutFile
+	if out == "" {
+		out = strings.TrimSuffix(CertFile, filepath.Ext(CertFile)) + ".p12"
+	}
+	if err = keyfile.WriteFile(out, p12); err != nil {
+		return err
+	}
+	fmt.Println(out)
+	return nil
+}
+
+// encodePKCS12 将私钥, 证书以及证书链编码为 PKCS#12, 与私钥匹配的证书放在第一个, name 为空时使用证书的 CN
+func encodePKCS12(key *sm2.PrivateKey, certs [][]byte, name string) ([]byte, error) {
+	leaf, err := leafIndex(&transBundle{certs: certs, key: key})
+	if err != nil {
+		return nil, err
+	}
+	ordered := append([][]byte{certs[leaf]}, certs[:leaf]...)
+	ordered = append(ordered, certs[leaf+1:]...)
+
+	if name == "" {
+		cert, err := x509.ParseCertificate(ordered[0])
+		if err != nil {
+			return nil, err
+		}
+		name = cert.Subject.CommonName
+	}
+	keyDER, err := x509.MarshalSm2PrivateKey(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	pwd, err := keyfile.PKCS12Passphrase().GetNew()
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12.Encode(keyDER, ordered, pwd, pkcs12.Options{
+		Cipher:       viper.GetString("pkcs12.cipher"),
+		FriendlyName: name,
+	})
+}
+
+// pemCerts 返回 PEM 证书链中每个证书的 der
+func pemCerts(b []byte) ([][]byte, error) {
+	bundle, err := readBundle(b, FormatPEM)
+	if err != nil {
+		return nil, err
+	}
+	return bundle.certs, nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportP12Cmd)
+
+	exportP12Cmd.Flags().StringVarP(&CertFile, "cert", "", "", "set cert file path, pem or der, may contain chain")
+	exportP12Cmd.Flags().StringVarP(&KeyFile, "key", "", "", "set private key file path of the cert")
+	exportP12Cmd.Flags().StringVarP(&ChainFile, "chain", "", "", "set chain file path, default chain of the issuer in config dir")
+	exportP12Cmd.Flags().StringVarP(&FriendlyName, "name", "", "", "set friendly name, default common name of the cert")
+	exportP12Cmd.Flags().StringVarP(&OutFile, "out", "o", "", "set output file, default cert file with .p12 suffix")
+
+	_ = exportP12Cmd.MarkFlagRequired("cert")
+	_ = exportP12Cmd.MarkFlagRequired("key")
+}
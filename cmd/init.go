@@ -6,7 +6,6 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
-	"crypto/rand"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
@@ -14,12 +13,35 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/signer"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/tjfoc/gmsm/sm2"
 	"github.com/tjfoc/gmsm/x509"
 )
 
+/*
+	根 CA 的主题, 有效期, 路径长度限制以及序列号均可通过命令行参数或配置文件中的 [ca] 配置项指定, 命令行参数优先.
+
+	[ca]
+	CN = "BLOCFACE HYPERCHAIN SM2 OCA1"
+	O = ["Blocface Hyperchain Self Authority"]
+	C = ["CN"]
+	expiration = [100, 0, 0]
+	pathLen = -1
+	serial = "0x1a2b3c"
+
+	已经存在 ca.key 或 ca.cert 时拒绝覆盖, 除非指定 --force. 被替换的根 CA 在证书清单中标记为 superseded,
+	配置的序列号已经使用过 (包括被替换的根 CA 的序列号) 时拒绝初始化, 需要更换序列号.
+	配置了 [signer] 的 pkcs11 或 remote 后端时, 使用后端中已有的私钥, 不生成 ca.key, 参考 pkg/signer.
+*/
+
+var Force bool
+
 // initCmd represents the init command
 var initCmd = &cobra.Command{
 	Use:   "init",
@@ -33,65 +55,155 @@ var initCmd = &cobra.Command{
 func generateAuthorityRootCA() error {
 	configDir := filepath.Dir(viper.ConfigFileUsed())
 
-	// 创建 CA私钥
-	caPrivKey, err := sm2.GenerateKey(rand.Reader)
+	c, err := signer.ConfigOf("")
 	if err != nil {
 		return err
 	}
+	if !Force {
+		f := afero.NewOsFs()
+		files := []string{filepath.Join(configDir, "ca.cert")}
+		if !c.External() {
+			files = append(files, c.KeyPath(filepath.Join(configDir, "ca.key")))
+		}
+		for _, name := range files {
+			if b, _ := afero.Exists(f, name); b {
+				return errors.Errorf("%s already exists, use --force to overwrite it", name)
+			}
+		}
+	}
 
-	// 将私钥保存到文件
-	caPrivateKeyBytes, err := x509.MarshalSm2PrivateKey(caPrivKey, nil)
+	s, err := store.Open(configDir)
 	if err != nil {
 		return err
 	}
-	caPrivateKeyPem := pem.EncodeToMemory(&pem.Block{
-		Type:  "PRIVATE KEY",
-		Bytes: caPrivateKeyBytes,
-	})
-	err = os.WriteFile(filepath.Join(configDir, "ca.key"), caPrivateKeyPem, 0o755)
+
+	// 强制覆盖时保留被替换的根 CA 的记录, 其序列号不能再次使用
+	if Force {
+		if old, err := authority.LoadCert(configDir, ""); err == nil {
+			if err = s.Supersede(old.SerialNumber); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 序列号记录在证书清单中, 保证不会被重复使用
+	var serialNumber *big.Int
+	if v := viper.GetString("ca.serial"); v != "" {
+		if serialNumber, err = parseSerialNumber(v); err != nil {
+			return err
+		}
+		if err = s.UseSerial(serialNumber); err != nil {
+			if errors.Is(err, store.ErrSerialUsed) {
+				return errors.Wrap(err, "set a new ca.serial or remove it to generate one")
+			}
+			return err
+		}
+	} else if serialNumber, err = s.NewSerial(); err != nil {
+		return err
+	}
+
+	// 创建 CA私钥
+	caPrivKey, caPub, err := authority.NewKey(c, filepath.Join(configDir, authority.KeyFile))
 	if err != nil {
 		return err
 	}
 
+	// 获取根证书的有效期, 默认 100 年
+	year, month, day := 100, 0, 0
+	if i := viper.GetIntSlice("ca.expiration"); len(i) == 3 {
+		year, month, day = i[0], i[1], i[2]
+	}
+
 	// 创建 CA 证书模板
 	caTemplate := x509.Certificate{
-		SerialNumber: big.NewInt(1658),
+		SerialNumber: serialNumber,
 		Subject: pkix.Name{
-			CommonName:   "BLOCFACE HYPERCHAIN SM2 OCA1",
-			Organization: []string{"Blocface Hyperchain Self Authority"},
-			Country:      []string{"CN"},
+			CommonName:         viper.GetString("ca.CN"),
+			Organization:       viper.GetStringSlice("ca.O"),
+			OrganizationalUnit: viper.GetStringSlice("ca.OU"),
+			Country:            viper.GetStringSlice("ca.C"),
+			Province:           viper.GetStringSlice("ca.ST"),
+			Locality:           viper.GetStringSlice("ca.L"),
 		},
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(100, 0, 0), // 有效期为 100 年
+		NotAfter:              time.Now().AddDate(year, month, day),
+		SubjectKeyId:          ca.SubjectKeyID(caPub),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		SignatureAlgorithm:    x509.SM2WithSM3,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
 
+	// pathLen 小于 0 表示不限制路径长度
+	if pathLen := viper.GetInt("ca.pathLen"); pathLen >= 0 {
+		caTemplate.MaxPathLen = pathLen
+		caTemplate.MaxPathLenZero = pathLen == 0
+	} else {
+		caTemplate.MaxPathLen = -1
+	}
+
 	// 创建自签的 CA 证书
-	caDerBytes, err := x509.CreateCertificate(&caTemplate, &caTemplate, &caPrivKey.PublicKey, caPrivKey)
+	caDerBytes, err := x509.CreateCertificate(&caTemplate, &caTemplate, caPub, caPrivKey)
 	if err != nil {
 		return err
 	}
+	if err = authority.SaveKey(c, filepath.Join(configDir, authority.KeyFile), caPrivKey); err != nil {
+		return err
+	}
 	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDerBytes})
 
-	err = os.WriteFile(filepath.Join(configDir, "ca.cert"), b, 0o755)
+	err = os.WriteFile(filepath.Join(configDir, "ca.cert"), b, 0o644)
 	if err != nil {
 		return err
 	}
 
-	// create crl
-	crlBytes, err := caTemplate.CreateCRL(rand.Reader, caPrivKey, nil, time.Now(), time.Now().Add(time.Duration(time.Now().Year())*100))
-	if err != nil {
+	if err = recordCert(s, "", "root", caDerBytes); err != nil {
 		return err
 	}
-	err = os.WriteFile(filepath.Join(configDir, "crl.crl"), crlBytes, 0o755)
-	if err != nil {
+
+	// 重新初始化后, 之前的吊销记录不再有效
+	if err = s.Reset(""); err != nil {
 		return err
 	}
-	return nil
+
+	// create crl
+	return generateCRL("")
+}
+
+// parseSerialNumber 解析用户指定的序列号, 支持十进制和 0x 开头的十六进制
+func parseSerialNumber(s string) (*big.Int, error) {
+	serialNumber, ok := new(big.Int).SetString(s, 0)
+	if !ok || serialNumber.Sign() <= 0 {
+		return nil, errors.Errorf("invalid serial number %s", s)
+	}
+	return serialNumber, nil
 }
 
 func init() {
 	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().String("CN", "BLOCFACE HYPERCHAIN SM2 OCA1", "set CommonName")
+	initCmd.Flags().StringSlice("O", []string{"Blocface Hyperchain Self Authority"}, "set Organization")
+	initCmd.Flags().StringSlice("OU", nil, "set OrganizationUnit")
+	initCmd.Flags().StringSlice("C", []string{"CN"}, "set Country")
+	initCmd.Flags().StringSlice("ST", nil, "set Province")
+	initCmd.Flags().StringSlice("L", nil, "set Locality")
+	initCmd.Flags().IntSlice("expiration", []int{100, 0, 0}, "set validity as year,month,day")
+	initCmd.Flags().Int("path-len", -1, "set path length constraint, negative means unlimited")
+	initCmd.Flags().String("serial", "", "set serial number, random if empty")
+	initCmd.Flags().BoolVarP(&Force, "force", "f", false, "overwrite existing ca.key and ca.cert")
+
+	for key, flag := range map[string]string{
+		"ca.CN":         "CN",
+		"ca.O":          "O",
+		"ca.OU":         "OU",
+		"ca.C":          "C",
+		"ca.ST":         "ST",
+		"ca.L":          "L",
+		"ca.expiration": "expiration",
+		"ca.pathLen":    "path-len",
+		"ca.serial":     "serial",
+	} {
+		_ = viper.BindPFlag(key, initCmd.Flags().Lookup(flag))
+	}
 }
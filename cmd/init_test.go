@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestInitForceSerial(t *testing.T) {
+	configDir := newTestConfig(t)
+	defer func() { Force = false }()
+	old := readTestCerts(t, filepath.Join(configDir, "ca.cert"))[0]
+
+	// 沿用被替换的根 CA 的序列号
+	Force = true
+	viper.Set("ca.serial", "0x"+store.SerialHex(old.SerialNumber))
+	if err := generateAuthorityRootCA(); !errors.Is(err, store.ErrSerialUsed) {
+		t.Fatalf("generateAuthorityRootCA() error = %v, want ErrSerialUsed", err)
+	}
+
+	viper.Set("ca.serial", "")
+	if err := generateAuthorityRootCA(); err != nil {
+		t.Fatal(err)
+	}
+	root := readTestCerts(t, filepath.Join(configDir, "ca.cert"))[0]
+	if root.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("new root ca uses the serial of the superseded one")
+	}
+
+	s, err := store.Open(configDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		cert *x509.Certificate
+		want string
+	}{{cert: old, want: store.StatusSuperseded}, {cert: root, want: store.StatusValid}} {
+		r, err := s.Get(v.cert.SerialNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != v.want {
+			t.Errorf("status of %s = %s, want %s", r.Serial, r.Status, v.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/x509/pkix"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+	由根 CA (或者另一个中间 CA) 签发中间 CA, 使得根 CA 的私钥可以离线保存.
+	之后通过 cert --issuer <name> 使用中间 CA 签发证书.
+
+	jcert-gm intermediate --name ops --CN "Ops SM2 CA" --O Org --path-len 0 --permitted-dns .example.com
+
+	中间 CA 的私钥后端由配置文件中的 [signer.intermediates.<name>] 选择, 默认保存到 intermediates/<name>/ca.key.
+	与签发证书相同, 需要满足模板 (--profile, 默认为 ca) 以及签发策略, 参考 pkg/ca.
+
+	name constraints 按 RFC 5280 标记为 critical. tjfoc/gmsm 无法解析标记为 critical 且包含 excluded 或者 ip 的 name constraints,
+	因此只支持 permitted dns. 签发证书时会检查证书链中所有 CA 的 name constraints, 参考 pkg/ca.
+*/
+
+var (
+	Name         string
+	Issuer       string
+	C            []string
+	ST           []string
+	L            []string
+	Expiration   []int
+	PathLen      int
+	KeyUsage     []string
+	PermittedDNS []string
+)
+
+// intermediateCmd represents the intermediate command
+var intermediateCmd = &cobra.Command{
+	Use:   "intermediate",
+	Short: "issue an intermediate ca",
+	Long:  `issue an intermediate ca signed by the root ca or another intermediate ca`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if Name == "" {
+			return errors.New("name is empty")
+		}
+		return generateIntermediateCA(ca.IntermediateOptions{
+			Name:   Name,
+			Issuer: Issuer,
+			Subject: pkix.Name{
+				CommonName:         CN,
+				Organization:       O,
+				OrganizationalUnit: OU,
+				Country:            C,
+				Province:           ST,
+				Locality:           L,
+			},
+			Profile:      Profile,
+			Expiration:   Expiration,
+			PathLen:      PathLen,
+			KeyUsage:     KeyUsage,
+			PermittedDNS: PermittedDNS,
+			Force:        Force,
+		})
+	},
+}
+
+// generateIntermediateCA 签发中间 CA, 由 intermediate 以及 scope 命令调用
+func generateIntermediateCA(opts ca.IntermediateOptions) error {
+	c, err := getCA()
+	if err != nil {
+		return err
+	}
+	opts.Requester = requester()
+	opts.CRL = ca.CRLOptions{NextUpdate: NextUpdate, Format: CRLFormat}
+	_, err = c.IssueIntermediate(context.Background(), opts)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(intermediateCmd)
+
+	intermediateCmd.Flags().StringVarP(&Name, "name", "n", "", "set intermediate ca name")
+	intermediateCmd.Flags().StringVarP(&Profile, "profile", "", "", "set ca profile checked together with the policy, default "+ca.IntermediateProfile)
+	intermediateCmd.Flags().StringVarP(&Issuer, "issuer", "", "", "set issuer intermediate ca name, empty means root ca")
+	intermediateCmd.Flags().StringVarP(&CN, "CN", "", "", "set CommonName")
+	intermediateCmd.Flags().StringSliceVarP(&O, "O", "", nil, "set Organization")
+	intermediateCmd.Flags().StringSliceVarP(&OU, "OU", "", nil, "set OrganizationUnit")
+	intermediateCmd.Flags().StringSliceVarP(&C, "C", "", nil, "set Country")
+	intermediateCmd.Flags().StringSliceVarP(&ST, "ST", "", nil, "set Province")
+	intermediateCmd.Flags().StringSliceVarP(&L, "L", "", nil, "set Locality")
+	intermediateCmd.Flags().IntSliceVarP(&Expiration, "expiration", "", []int{10, 0, 0}, "set validity as year,month,day")
+	intermediateCmd.Flags().IntVarP(&PathLen, "path-len", "", 0, "set path length constraint, negative means unlimited")
+	intermediateCmd.Flags().StringSliceVarP(&KeyUsage, "key-usage", "", []string{"certSign", "crlSign"}, "set key usage, such as digitalSignature, certSign, crlSign")
+	intermediateCmd.Flags().StringSliceVarP(&PermittedDNS, "permitted-dns", "", nil, "set permitted dns name constraints")
+	intermediateCmd.Flags().StringVarP(&Requester, "requester", "", "", "set requester recorded in the certificate inventory, default current user")
+	intermediateCmd.Flags().BoolVarP(&Force, "force", "f", false, "overwrite existing intermediate ca")
+
+	_ = intermediateCmd.MarkFlagRequired("name")
+	_ = intermediateCmd.MarkFlagRequired("CN")
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+	证书清单, 查询签发过的所有证书.
+
+	jcert-gm list --status valid
+	jcert-gm search node3
+	jcert-gm show 1a2b3c
+*/
+
+var Status string
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list issued certs",
+	Long:  `list issued certs in the certificate inventory`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listCerts(cmd, "")
+	},
+}
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search <text>",
+	Short: "search issued certs",
+	Long:  `search issued certs by serial, subject, san, requester or fingerprint`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listCerts(cmd, args[0])
+	},
+}
+
+// showCmd represents the show command
+var showCmd = &cobra.Command{
+	Use:   "show <serial>",
+	Short: "show issued cert",
+	Long:  `show issued cert by hex serial number`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  showCert,
+}
+
+func listCerts(cmd *cobra.Command, text string) error {
+	s, err := store.Open(filepath.Dir(viper.ConfigFileUsed()))
+	if err != nil {
+		return err
+	}
+
+	q := store.Query{Text: text, Profile: Profile, Status: Status}
+	if cmd.Flags().Changed("issuer") {
+		q.Issuer = &Issuer
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tSUBJECT\tSANS\tPROFILE\tISSUER\tNOT AFTER\tSTATUS")
+	for _, v := range s.Search(q) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", v.Serial, v.Subject, strings.Join(v.SANs, ","), v.Profile,
+			authorityName(v.Issuer), v.NotAfter.Format("2006-01-02"), v.State(now))
+	}
+	return w.Flush()
+}
+
+func showCert(_ *cobra.Command, args []string) error {
+	s, err := store.Open(filepath.Dir(viper.ConfigFileUsed()))
+	if err != nil {
+		return err
+	}
+
+	serial, err := store.ParseSerial(strings.TrimPrefix(strings.ToLower(args[0]), "0x"))
+	if err != nil {
+		return err
+	}
+	r, err := s.Get(serial)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Serial: %s\n", r.Serial)
+	fmt.Printf("Subject: %s\n", r.Subject)
+	fmt.Printf("SANs: %s\n", strings.Join(r.SANs, ", "))
+	fmt.Printf("Profile: %s\n", r.Profile)
+	fmt.Printf("Issuer: %s\n", authorityName(r.Issuer))
+	fmt.Printf("Not Before: %s\n", r.NotBefore.Local().Format(time.RFC3339))
+	fmt.Printf("Not After: %s\n", r.NotAfter.Local().Format(time.RFC3339))
+	fmt.Printf("Fingerprint (SM3): %s\n", r.Fingerprint)
+	fmt.Printf("Requester: %s\n", r.Requester)
+	fmt.Printf("Status: %s\n", r.State(time.Now()))
+	if rv := s.Revocation(r.Issuer, serial); rv != nil {
+		fmt.Printf("Revoked At: %s\n", rv.RevokedAt.Local().Format(time.RFC3339))
+		fmt.Printf("Revocation Reason: %d\n", rv.Reason)
+	}
+	fmt.Println(color.CyanString("\n%s", r.Certificate))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(showCmd)
+
+	for _, c := range []*cobra.Command{listCmd, searchCmd} {
+		c.Flags().StringVarP(&Status, "status", "", "", "filter by status, support valid, revoked, expired, superseded")
+		c.Flags().StringVarP(&Issuer, "issuer", "", "", "filter by issuer intermediate ca name, empty means root ca")
+		c.Flags().StringVarP(&Profile, "profile", "", "", "filter by profile")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+/*
+	SM2 私钥格式转换, 输入格式自动识别, 参考 pkg/keyfile.
+
+	jcert-gm key convert node1.key --to sec1
+	jcert-gm key convert node1.key --to encrypted -o node1.enc.key
+	jcert-gm key convert node1.jwk --to pkcs8 -o node1.key
+	jcert-gm key convert node1.key --pub hex
+
+	指定 --pub 时输出公钥, 否则输出私钥. 输入为加密私钥时使用 --passphrase-file 或环境变量 JCERT_GM_PASSPHRASE 解密,
+	输出为加密私钥时使用单独的口令来源 --new-passphrase-file 或环境变量 JCERT_GM_NEW_PASSPHRASE, 都没有时终端提示:
+
+	jcert-gm key convert node1.enc.key --to encrypted --passphrase-file old.pass --new-passphrase-file new.pass -o node1.new.key
+
+	与 trans 相同, 未加密的私钥需要指定 -o 保存到文件, 或者指定 --print-key 输出到标准输出:
+
+	jcert-gm key convert node1.key --to hex --print-key
+*/
+
+var (
+	KeyFormat         string
+	PubFormat         string
+	NewPassphraseFile string
+)
+
+// keyCmd represents the key command
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "manage private keys",
+	Long:  `manage sm2 private keys`,
+}
+
+// keyConvertCmd represents the key convert command
+var keyConvertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "convert private key format",
+	Long: `convert sm2 private key between ` + strings.Join(keyfile.PrivateFormats, ", ") + `, input format is detected automatically.
+
+with --pub, print public key in ` + strings.Join(keyfile.PublicFormats, ", ") + ` instead`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		key, format, err := keyfile.Decode(b, keyfile.LeafPassphrase())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "read %s private key from %s\n", format, args[0])
+
+		if PubFormat != "" {
+			out, err := keyfile.EncodePublic(&key.PublicKey, PubFormat)
+			if err != nil {
+				return err
+			}
+			if OutFile == "" {
+				_, err = os.Stdout.Write(out)
+				return err
+			}
+			return os.WriteFile(OutFile, out, 0o644)
+		}
+
+		// 避免未加密的私钥意外输出到终端或者日志中
+		if OutFile == "" && !PrintKey && KeyFormat != keyfile.FormatEncrypted {
+			return errors.New("refuse to write unencrypted private key to stdout, set -o, --to encrypted or --print-key")
+		}
+
+		out, err := keyfile.EncodeAs(key, KeyFormat, keyfile.NewPassphrase(NewPassphraseFile))
+		if err != nil {
+			return err
+		}
+		if OutFile == "" {
+			_, err = os.Stdout.Write(out)
+			return err
+		}
+		return keyfile.WriteFile(OutFile, out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyConvertCmd)
+
+	keyConvertCmd.Flags().StringVarP(&KeyFormat, "to", "", keyfile.FormatPKCS8, "set private key format, support "+strings.Join(keyfile.PrivateFormats, ", "))
+	keyConvertCmd.Flags().StringVarP(&PubFormat, "pub", "", "", "print public key instead, support "+strings.Join(keyfile.PublicFormats, ", "))
+	keyConvertCmd.Flags().StringVarP(&OutFile, "out", "o", "", "set output file, default stdout")
+	keyConvertCmd.Flags().BoolVarP(&PrintKey, "print-key", "", false, "allow writing unencrypted private key to stdout")
+	keyConvertCmd.Flags().StringVarP(&NewPassphraseFile, "new-passphrase-file", "", "", "set passphrase file of encrypted output key, or use env "+keyfile.NewPassphraseEnv)
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+func TestKeyConvertToStdout(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	defer func() { KeyFormat, PubFormat, OutFile, PrintKey = keyfile.FormatPKCS8, "", "", false }()
+	t.Setenv(keyfile.PassphraseEnv, "")
+	t.Setenv(keyfile.NewPassphraseEnv, "new passphrase")
+
+	dir := t.TempDir()
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := keyfile.EncodeAs(key, keyfile.FormatPKCS8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "node1.key")
+	if err = keyfile.WriteFile(input, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		to       string
+		pub      string
+		out      string
+		printKey bool
+		wantErr  bool
+	}{
+		{name: "refuse pkcs8", to: keyfile.FormatPKCS8, wantErr: true},
+		{name: "refuse hex", to: keyfile.FormatHex, wantErr: true},
+		{name: "refuse jwk", to: keyfile.FormatJWK, wantErr: true},
+		{name: "print key", to: keyfile.FormatSEC1, printKey: true},
+		{name: "encrypted", to: keyfile.FormatEncrypted},
+		{name: "public key", to: keyfile.FormatPKCS8, pub: keyfile.FormatHex},
+		{name: "out file", to: keyfile.FormatHex, out: filepath.Join(dir, "node1.hex")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			KeyFormat, PubFormat, OutFile, PrintKey = tt.to, tt.pub, tt.out, tt.printKey
+
+			// 避免私钥输出到测试日志中
+			stdout := os.Stdout
+			os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			err := keyConvertCmd.RunE(keyConvertCmd, []string{input})
+			os.Stdout.Close()
+			os.Stdout = stdout
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("key convert error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--print-key") {
+				t.Errorf("key convert error = %v, want hint of --print-key", err)
+			}
+		})
+	}
+}
@@ -6,30 +6,40 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
-	"errors"
 	"fmt"
 	"os"
-	"strings"
 
-	gsm2 "github.com/emmansun/gmsm/sm2"
+	ssm2 "github.com/emmansun/gmsm/sm2"
 	"github.com/emmansun/gmsm/smx509"
+	"github.com/jaronnie/jcert-gm/pkg/certinfo"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"github.com/tjfoc/gmsm/sm2"
 	"github.com/tjfoc/gmsm/x509"
 )
 
+var JSONOutput bool
+
 // matchCmd represents the match command
 var matchCmd = &cobra.Command{
 	Use:   "match",
-	Short: "check cert and privatekey is match",
-	Long:  `check cert and privatekey is match`,
+	Short: "check two of privatekey, public key, csr and cert are match",
+	Long:  `check two of privatekey, public key, csr and cert are match, exit with 1 when not match`,
 	Args:  cobra.ExactArgs(2),
 	RunE:  match,
 }
 
 /*
+	验证私钥, 公钥, csr 以及证书中的任意两个是否匹配, 文件类型自动识别, 支持 PEM 以及 DER 格式.
+
 	验证 csr 与 证书是否匹配:
 	通常情况下，判断 CSR 和证书是否匹配的方法会比较简单，只需要判断它们对应的公钥是否相同即可。这是因为 CSR 是用来请求颁发证书的签名请求，而证书本身就是由一个已经被信任的 CA 机构签署并包含了公钥信息的文件。
 	例如，在 Go 语言的标准库中，可以使用 x509.CreateCertificate 函数生成证书时，其中一个参数就是要与证书关联的 CSR，这个 CSR 的公钥会被嵌入到生成的证书中。因此，在验证证书时只需要比较 CSR 的公钥和证书的公钥是否一致即可。
@@ -37,137 +47,214 @@ var matchCmd = &cobra.Command{
 
 	验证证书与私钥是否匹配:
 	对消息进行签名，并使用公钥验证签名以及私钥和证书是否匹配
-*/
 
-func match(_ *cobra.Command, args []string) error {
-	certF := args[0]
-	tf := args[1]
+	不匹配时退出码为 1, 便于脚本使用. --json 输出每个文件的类型, 公钥以及公钥的指纹.
+*/
 
-	// 解码文件
-	certFile, err := os.ReadFile(certF)
-	if err != nil {
-		return err
-	}
+// ErrMismatch 公钥不匹配
+var ErrMismatch = errors.New("not match")
+
+// matchItem 参与比对的文件
+type matchItem struct {
+	File      string `json:"file"`
+	Type      string `json:"type"`
+	Algorithm string `json:"algorithm"`
+	Curve     string `json:"curve,omitempty"`
+	// PublicKey 椭圆曲线公钥为非压缩格式的点, 十六进制
+	PublicKey string `json:"publicKey,omitempty"`
+	// Fingerprints 公钥 SubjectPublicKeyInfo 的摘要
+	Fingerprints *certinfo.Fingerprints `json:"fingerprints"`
+
+	spki   []byte
+	signer crypto.Signer
+}
 
-	var cert *x509.Certificate
+type matchResult struct {
+	Match bool         `json:"match"`
+	Files []*matchItem `json:"files"`
+}
 
-	for {
-		certBlock, rest := pem.Decode(certFile)
-		if certBlock == nil {
-			break
+func match(cmd *cobra.Command, args []string) error {
+	result := &matchResult{Match: true}
+	for _, v := range args {
+		item, err := readMatchItem(v)
+		if err != nil {
+			return err
 		}
-		certFile = rest
+		result.Files = append(result.Files, item)
+	}
 
-		if certBlock.Type == "CERTIFICATE" {
-			xcert, err := x509.ParseCertificate(certBlock.Bytes)
+	// 先校验公钥是否相等, 再使用私钥签名并用另一个文件的公钥验证签名
+	a, b := result.Files[0], result.Files[1]
+	if !bytes.Equal(a.spki, b.spki) {
+		result.Match = false
+	}
+	for _, v := range [][2]*matchItem{{a, b}, {b, a}} {
+		if result.Match && v[0].signer != nil {
+			ok, err := checkKeyPair(v[0].signer, v[1].spki)
 			if err != nil {
 				return err
 			}
-			if !xcert.IsCA {
-				cert = xcert
-			}
+			result.Match = ok
 		}
 	}
 
-	if cert == nil {
-		return errors.New(" type is not CERTIFICATE")
+	if JSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println(result.Match)
 	}
 
-	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: cert.RawSubjectPublicKeyInfo})
+	if !result.Match {
+		cmd.SilenceUsage = true
+		return errors.Wrapf(ErrMismatch, "%s and %s", a.File, b.File)
+	}
+	return nil
+}
 
-	pub, err := x509.ReadPublicKeyFromPem(pubPEM)
+// readMatchItem 读取文件并识别类型, 证书文件中有证书链时使用终端证书
+func readMatchItem(path string) (*matchItem, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// 解码文件
-	keyPem, err := os.ReadFile(tf)
+	item, err := parseMatchItem(b)
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "read %s", path)
 	}
+	item.File = path
+	return item, nil
+}
 
-	var keyBlock *pem.Block
+func parseMatchItem(b []byte) (*matchItem, error) {
+	if block, _ := pem.Decode(b); block == nil {
+		return parseMatchDER(b)
+	}
 
+	var cert *x509.Certificate
 	for {
-		xkeyBlock, rest := pem.Decode(keyPem)
-		if xkeyBlock == nil {
+		block, rest := pem.Decode(b)
+		if block == nil {
 			break
 		}
-		keyPem = rest
+		b = rest
 
-		if strings.Contains(xkeyBlock.Type, "PRIVATE KEY") {
-			keyBlock = xkeyBlock
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if cert == nil || cert.IsCA && !c.IsCA {
+				cert = c
+			}
+		case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return newMatchItem(certinfo.TypeCertificateRequest, csr.RawSubjectPublicKeyInfo, nil)
+		case "PUBLIC KEY":
+			return newMatchItem(certinfo.TypePublicKey, block.Bytes, nil)
+		case "PRIVATE KEY", "EC PRIVATE KEY", "SM2 PRIVATE KEY":
+			return newPrivateKeyItem(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			key, err := keyfile.Parse(pem.EncodeToMemory(block), keyfile.LeafPassphrase())
+			if err != nil {
+				return nil, err
+			}
+			der, err := x509.MarshalSm2PrivateKey(key, nil)
+			if err != nil {
+				return nil, err
+			}
+			return newPrivateKeyItem(der)
 		}
-
 	}
+	if cert == nil {
+		return nil, errors.New("no private key, public key, csr or cert found")
+	}
+	return newMatchItem(certinfo.TypeCertificate, cert.RawSubjectPublicKeyInfo, nil)
+}
 
-	if keyBlock == nil {
-		return errors.New("type is not PRIVATE KEY")
+func parseMatchDER(der []byte) (*matchItem, error) {
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return newMatchItem(certinfo.TypeCertificate, cert.RawSubjectPublicKeyInfo, nil)
+	}
+	if csr, err := x509.ParseCertificateRequest(der); err == nil {
+		return newMatchItem(certinfo.TypeCertificateRequest, csr.RawSubjectPublicKeyInfo, nil)
 	}
+	if _, err := smx509.ParsePKIXPublicKey(der); err == nil {
+		return newMatchItem(certinfo.TypePublicKey, der, nil)
+	}
+	return newPrivateKeyItem(der)
+}
 
-	pk, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes, nil)
+// newPrivateKeyItem 解析 PKCS#8 或 SEC1 格式的私钥
+func newPrivateKeyItem(der []byte) (*matchItem, error) {
+	key, err := smx509.ParsePKCS8PrivateKey(der)
 	if err != nil {
-		// 有可能会发生错误, 原因是因为该库支持了 pkcs8 格式的私钥存储格式. 还有 EC PRIVATE KEY 的格式.
-		ecpk, err := smx509.ParseECPrivateKey(keyBlock.Bytes)
-		if err != nil {
-			return err
+		if key, err = smx509.ParseTypedECPrivateKey(der); err != nil {
+			return nil, errors.New("unknown format, support private key, public key, csr and cert")
 		}
-
-		// 检查 public key 是否相同
-		privPubDer, err := smx509.MarshalPKIXPublicKey(&ecpk.PublicKey)
-		if err != nil {
-			return err
-		}
-		block := &pem.Block{
-			Type:  "PUBLIC KEY",
-			Bytes: privPubDer,
-		}
-		privPubPem := pem.EncodeToMemory(block)
-
-		if string(privPubPem) != string(pubPEM) {
-			fmt.Println(false)
-			return nil
-		}
-
-		// 使用 EC PRIVATE KEY 的校验逻辑
-		r, s, err := gsm2.SignWithSM2(rand.Reader, ecpk, nil, []byte("sign"))
-		if err != nil {
-			return err
-		}
-		b := gsm2.VerifyWithSM2(&ecpk.PublicKey, nil, []byte("sign"), r, s)
-		fmt.Println(b)
-		return nil
 	}
-
-	// 使用 pkcs8 的正常校验逻辑
-
-	// 原理是:
-	// 如果签名结果 r 和 s 是由正确的私钥对消息 "sign" 生成的
-	// 那么在使用相应的公钥对消息 "sign" 和签名结果 r 和 s 进行验证时，将会得到一个布尔值为 true 的结果，
-	// 表示签名有效。而如果签名结果不是由正确的私钥生成，那么验证将失败，并返回一个布尔值为 false。
-	// 因此，我们可以使用这个布尔值来判断签名是否有效。
-
-	// 先校验公钥是否相等
-	privPubPem, err := x509.WritePublicKeyToPem(&pk.PublicKey)
-	if err != nil {
-		return err
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("not support private key %T", key)
 	}
-	if string(privPubPem) != string(pubPEM) {
-		fmt.Println(false)
-		return nil
+	spki, err := smx509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
 	}
+	return newMatchItem(certinfo.TypePrivateKey, spki, signer)
+}
 
-	r, s, err := sm2.Sm2Sign(pk, []byte("sign"), nil, rand.Reader)
+func newMatchItem(typ string, spki []byte, signer crypto.Signer) (*matchItem, error) {
+	pub, err := smx509.ParsePKIXPublicKey(spki)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	key := certinfo.NewPublicKey(certinfo.FormatPKIX, pub)
+	return &matchItem{
+		Type:         typ,
+		Algorithm:    key.Algorithm,
+		Curve:        key.Curve,
+		PublicKey:    key.PublicKey,
+		Fingerprints: key.Fingerprints,
+		spki:         spki,
+		signer:       signer,
+	}, nil
+}
 
-	b := sm2.Sm2Verify(pub, []byte("sign"), nil, r, s)
-	fmt.Println(b)
+// checkKeyPair 使用私钥签名, 并用公钥验证签名
+func checkKeyPair(signer crypto.Signer, spki []byte) (bool, error) {
+	pub, err := smx509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return false, err
+	}
+	digest := sha256.Sum256([]byte("sign"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return false, err
+	}
 
-	return nil
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve == ssm2.P256() {
+			return ssm2.VerifyASN1(pub, digest[:], sig), nil
+		}
+		return ecdsa.VerifyASN1(pub, digest[:], sig), nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil, nil
+	}
+	return false, errors.Errorf("not support public key %T", pub)
 }
 
 func init() {
 	rootCmd.AddCommand(matchCmd)
+
+	matchCmd.Flags().BoolVarP(&JSONOutput, "json", "", false, "print type, public key and fingerprint of each file in json")
 }
@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	stdx509 "crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/certinfo"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newMatchFiles 为 sm2 私钥生成各种格式的文件, 返回文件名到路径的映射
+func newMatchFiles(t *testing.T, dir string, name string, key *sm2.PrivateKey, isCA bool) map[string]string {
+	t.Helper()
+	pkcs8, err := keyfile.EncodeAs(key, keyfile.FormatPKCS8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := keyfile.EncodeAs(key, keyfile.FormatSEC1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := keyfile.EncodeAs(key, keyfile.FormatEncrypted, keyfile.LeafPassphrase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.WritePublicKeyToPem(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ca.NewCSR(key, ca.CSROptions{Subject: pkix.Name{CommonName: name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		SignatureAlgorithm:    x509.SM2WithSM3,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	cert, err := x509.CreateCertificate(template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"pkcs8":     pkcs8,
+		"sec1":      sec1,
+		"encrypted": encrypted,
+		"pub":       pub,
+		"csr":       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}),
+		"csr.der":   csr.Raw,
+		"cert":      pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		"cert.der":  cert,
+	}
+	paths := map[string]string{}
+	for k, v := range files {
+		path := filepath.Join(dir, name+"."+k)
+		if err = os.WriteFile(path, v, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths[k] = path
+	}
+	return paths
+}
+
+func TestMatch(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv(keyfile.PassphraseEnv, "key passphrase")
+
+	dir := t.TempDir()
+	key1, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node1 := newMatchFiles(t, dir, "node1", key1, false)
+	root := newMatchFiles(t, dir, "root", key2, true)
+
+	// 证书链中 CA 证书在前, 使用终端证书比对
+	chain, err := os.ReadFile(root["cert"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := os.ReadFile(node1["cert"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainFile := filepath.Join(dir, "chain.cert")
+	if err = os.WriteFile(chainFile, append(chain, leaf...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		a, b  string
+		types [2]string
+		match bool
+	}{
+		{name: "key and cert", a: node1["pkcs8"], b: node1["cert"], types: [2]string{certinfo.TypePrivateKey, certinfo.TypeCertificate}, match: true},
+		{name: "sec1 key and csr", a: node1["sec1"], b: node1["csr"], types: [2]string{certinfo.TypePrivateKey, certinfo.TypeCertificateRequest}, match: true},
+		{name: "encrypted key and public key", a: node1["encrypted"], b: node1["pub"], types: [2]string{certinfo.TypePrivateKey, certinfo.TypePublicKey}, match: true},
+		{name: "csr and cert der", a: node1["csr.der"], b: node1["cert.der"], types: [2]string{certinfo.TypeCertificateRequest, certinfo.TypeCertificate}, match: true},
+		{name: "cert and key", a: node1["cert"], b: node1["sec1"], types: [2]string{certinfo.TypeCertificate, certinfo.TypePrivateKey}, match: true},
+		{name: "chain uses leaf", a: node1["pkcs8"], b: chainFile, types: [2]string{certinfo.TypePrivateKey, certinfo.TypeCertificate}, match: true},
+		{name: "key and other cert", a: node1["pkcs8"], b: root["cert"], types: [2]string{certinfo.TypePrivateKey, certinfo.TypeCertificate}},
+		{name: "csr and other csr", a: node1["csr"], b: root["csr"], types: [2]string{certinfo.TypeCertificateRequest, certinfo.TypeCertificateRequest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, v := range []string{tt.a, tt.b} {
+				item, err := readMatchItem(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if item.Type != tt.types[i] || item.Algorithm != "sm2" {
+					t.Errorf("%s type = %s, algorithm = %s, want %s, sm2", v, item.Type, item.Algorithm, tt.types[i])
+				}
+			}
+
+			err := match(matchCmd, []string{tt.a, tt.b})
+			if tt.match && err != nil {
+				t.Fatalf("match() error = %v, want nil", err)
+			}
+			if !tt.match && !errors.Is(err, ErrMismatch) {
+				t.Fatalf("match() error = %v, want ErrMismatch", err)
+			}
+		})
+	}
+}
+
+func TestParseMatchItemError(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"garbage":   []byte("garbage"),
+		"no items":  pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: []byte{1}}),
+		"bad cert":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}),
+		"bad csr":   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: []byte("garbage")}),
+		"bad key":   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}),
+		"empty der": {},
+	} {
+		if _, err := parseMatchItem(data); err == nil {
+			t.Errorf("parseMatchItem(%s) error = nil, want error", name)
+		}
+	}
+}
+
+func TestCheckKeyPair(t *testing.T) {
+	sm2Key, err := ca.NewKey(ca.KeyOptions{Algorithm: ca.KeySM2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2Item, err := newPrivateKeyItem(mustPKCS8(t, sm2Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		signer crypto.Signer
+		spki   []byte
+		want   bool
+	}{
+		// 与 match 一致, sm2 私钥由 smx509 解析
+		{name: "sm2", signer: sm2Item.signer, spki: sm2Item.spki, want: true},
+		{name: "sm2 other key", signer: sm2Item.signer, spki: mustPKIX(t, otherKey.Public())},
+		{name: "ecdsa", signer: ecKey, spki: mustPKIX(t, ecKey.Public()), want: true},
+		{name: "rsa", signer: rsaKey, spki: mustPKIX(t, rsaKey.Public()), want: true},
+		{name: "ecdsa other key", signer: ecKey, spki: mustPKIX(t, otherKey.Public())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkKeyPair(tt.signer, tt.spki)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("checkKeyPair() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustPKIX(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := stdx509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func mustPKCS8(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalSm2PrivateKey(key.(*sm2.PrivateKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
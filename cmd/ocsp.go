@@ -0,0 +1,79 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/jaronnie/jcert-gm/pkg/ocsp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+	OCSP 服务, 根据证书清单以及吊销记录回答证书状态, 使用 SM2 with SM3 签名响应.
+
+	监听地址, 委托签名证书以及响应的有效期可通过命令行参数或配置文件指定:
+
+	[ocsp]
+	addr = ":8888"
+	cert = "/path/to/ocsp.cert"
+	key = "/path/to/ocsp.key"
+	nextUpdate = "1h"
+
+	未指定委托签名证书时使用签发机构自身的私钥签名, 委托签名证书可以使用 ocsp 模板签发:
+
+	jcert-gm cert --csr ocsp.csr --profile ocsp
+
+	证书中的 OCSP 地址通过配置项 OCSPServer 指定, 如 OCSPServer = ["http://127.0.0.1:8888"].
+	jcert-gm server 也会在 /ocsp 提供同样的服务.
+*/
+
+// ocspCmd represents the ocsp command
+var ocspCmd = &cobra.Command{
+	Use:   "ocsp",
+	Short: "run ocsp responder",
+	Long:  `run ocsp responder backed by the certificate inventory and revocation records`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runOCSPResponder()
+	},
+}
+
+func runOCSPResponder() error {
+	r, err := ocsp.NewResponder(ocsp.Config{
+		ConfigDir:  filepath.Dir(viper.ConfigFileUsed()),
+		CertFile:   viper.GetString("ocsp.cert"),
+		KeyFile:    viper.GetString("ocsp.key"),
+		NextUpdate: viper.GetDuration("ocsp.nextUpdate"),
+	})
+	if err != nil {
+		return err
+	}
+
+	addr := viper.GetString("ocsp.addr")
+	fmt.Printf("ocsp responder listening on %s\n", addr)
+	return http.ListenAndServe(addr, r)
+}
+
+func init() {
+	rootCmd.AddCommand(ocspCmd)
+
+	ocspCmd.Flags().String("addr", ":8888", "set listen address")
+	ocspCmd.Flags().String("cert", "", "set delegated ocsp signing cert file path, default sign by issuer")
+	ocspCmd.Flags().String("key", "", "set delegated ocsp signing key file path")
+	ocspCmd.Flags().Duration("next-update", 0, "set duration until next update of responses, empty means not set")
+
+	for key, flag := range map[string]string{
+		"ocsp.addr":       "addr",
+		"ocsp.cert":       "cert",
+		"ocsp.key":        "key",
+		"ocsp.nextUpdate": "next-update",
+	} {
+		_ = viper.BindPFlag(key, ocspCmd.Flags().Lookup(flag))
+	}
+}
@@ -6,23 +6,39 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
-	"encoding/pem"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/tjfoc/gmsm/x509"
+	"github.com/jaronnie/jcert-gm/pkg/certinfo"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 
 	"github.com/fatih/color"
 
 	"github.com/spf13/cobra"
 )
 
+/*
+	解析文件中的证书, csr, CRL, PKCS#7 证书包, 公钥以及私钥, 自动识别 PEM, DER 以及 base64 编码的 DER.
+	私钥只输出算法, 曲线以及公钥.
+
+	输出格式:
+	1. text: 便于阅读, 默认
+	2. json, yaml: 便于脚本处理, 总是输出数组, 每个元素的 type 为
+	   certificate, certificate request, crl, pkcs7, public key 或 private key
+*/
+
+var Format string
+
 // parseCmd represents the parse command
 var parseCmd = &cobra.Command{
 	Use:   "parse",
-	Short: "parse csr or certs",
-	Long:  `parse cer or certs`,
+	Short: "parse certs, csr, crl, pkcs7 or keys",
+	Long:  `parse certs, csr, crl, pkcs7 or keys in PEM, DER or base64, and print subject, validity, extensions and fingerprints`,
 	Args:  cobra.ExactArgs(1),
 	RunE:  parse,
 }
@@ -34,51 +50,191 @@ func parse(_ *cobra.Command, args []string) error {
 		return err
 	}
 
-	count := 0
+	items, skipped, err := certinfo.Parse(file, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, v := range skipped {
+		fmt.Fprintf(os.Stderr, "skip unsupported PEM block %s\n", v)
+	}
+	if len(items) == 0 {
+		return errors.New("no certificate, csr, crl or key found")
+	}
+
+	return printItems(os.Stdout, Format, items)
+}
 
-	for {
-		// 解码文件
-		block, rest := pem.Decode(file)
-		if block == nil {
-			break
+// printItems 按格式输出解析结果
+func printItems(w io.Writer, format string, items []interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(items); err != nil {
+			return err
 		}
-		file = rest
+		return enc.Close()
+	case "", "text":
+		for i, v := range items {
+			if i > 0 {
+				fmt.Fprintf(w, "\n===================================\n\n")
+			}
+			printText(w, v)
+		}
+		return nil
+	}
+	return errors.Errorf("not support format %s, support text, json and yaml", format)
+}
 
-		if count >= 1 {
-			fmt.Printf("\n===================================\n")
+func printText(w io.Writer, item interface{}) {
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%-26s%s\n", name+":", value)
 		}
+	}
+	list := func(name string, values []string) {
+		field(name, strings.Join(values, ", "))
+	}
+	section := func(name string) {
+		fmt.Fprintln(w, color.CyanString("\n%s", name))
+	}
+	sans := func(s certinfo.SANs) {
+		list("DNS", s.DNS)
+		list("IP", s.IP)
+		list("Email", s.Email)
+		list("URI", s.URI)
+	}
+	fingerprints := func(name string, f certinfo.Fingerprints) {
+		section(name)
+		field("SM3", f.SM3)
+		field("SHA-256", f.SHA256)
+	}
+	localTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(time.RFC3339)
+	}
+
+	switch v := item.(type) {
+	case *certinfo.CertificateRequest:
+		fmt.Fprintln(w, color.BlueString("CERTIFICATE REQUEST"))
+		section("Subject")
+		printName(w, v.Subject)
+		section("Public Key")
+		field("Algorithm", v.PublicKeyAlgorithm)
+		field("Signature Algorithm", v.SignatureAlgorithm)
+		field("Signature Valid", fmt.Sprint(v.SignatureValid))
+		section("Subject Alternative Names")
+		sans(v.SANs)
+		fingerprints("Fingerprints", v.Fingerprints)
+		fingerprints("Public Key Fingerprints", v.PublicKeyFingerprints)
+	case *certinfo.Certificate:
+		fmt.Fprintln(w, color.BlueString("CERTIFICATE"))
+		field("Version", fmt.Sprint(v.Version))
+		field("Serial", v.Serial)
+		section("Issuer")
+		printName(w, v.Issuer)
+		section("Subject")
+		printName(w, v.Subject)
+
+		section("Validity")
+		field("Not Before", localTime(v.Validity.NotBefore))
+		field("Not After", localTime(v.Validity.NotAfter))
+		status := fmt.Sprintf("%s, %d days remaining", v.Validity.Status, v.Validity.DaysRemaining)
+		if v.Validity.Status != "valid" {
+			status = color.RedString(v.Validity.Status)
+		}
+		field("Status", status)
+
+		section("Public Key")
+		field("Algorithm", v.PublicKeyAlgorithm)
+		field("Signature Algorithm", v.SignatureAlgorithm)
 
-		if block.Type == "CERTIFICATE REQUEST" {
-			parse, err := x509.ParseCertificateRequest(block.Bytes)
-			if err != nil {
-				return err
+		section("Extensions")
+		list("Key Usage", v.KeyUsage)
+		list("Extended Key Usage", v.ExtKeyUsage)
+		if bc := v.BasicConstraints; bc != nil {
+			pathLen := "unlimited"
+			if bc.MaxPathLen >= 0 {
+				pathLen = fmt.Sprint(bc.MaxPathLen)
 			}
-			fmt.Println(color.BlueString("CERTIFICATE REQUEST\n"))
-			fmt.Println(color.CyanString("Subject:\n"))
-			fmt.Printf("common name: %s\n", parse.Subject.CommonName)
-			fmt.Printf("Organization: %s\n", strings.Join(parse.Subject.Organization, ","))
-			fmt.Printf("Organization Unit: %s\n", strings.Join(parse.Subject.OrganizationalUnit, ","))
-		} else if block.Type == "CERTIFICATE" {
-			parse, err := x509.ParseCertificate(block.Bytes)
-			if err != nil {
-				return err
+			field("Basic Constraints", fmt.Sprintf("CA: %v, path length: %s", bc.IsCA, pathLen))
+		}
+		field("Subject Key ID", v.SubjectKeyID)
+		field("Authority Key ID", v.AuthorityKeyID)
+		list("CRL Distribution Points", v.CRLDistribution)
+		list("OCSP Servers", v.OCSPServers)
+		list("CA Issuers", v.IssuingCertURLs)
+
+		section("Subject Alternative Names")
+		sans(v.SANs)
+		fingerprints("Fingerprints", v.Fingerprints)
+		fingerprints("Public Key Fingerprints", v.PublicKeyFingerprints)
+	case *certinfo.CRL:
+		fmt.Fprintln(w, color.BlueString("CRL"))
+		field("Number", v.Number)
+		section("Issuer")
+		printName(w, v.Issuer)
+		section("Validity")
+		field("This Update", localTime(v.ThisUpdate))
+		field("Next Update", localTime(v.NextUpdate))
+		field("Signature Algorithm", v.SignatureAlgorithm)
+		field("Authority Key ID", v.AuthorityKeyID)
+		section(fmt.Sprintf("Revoked Certificates (%d)", len(v.Revoked)))
+		for _, rc := range v.Revoked {
+			reason := ""
+			if rc.Reason != "" {
+				reason = ", " + rc.Reason
 			}
-			fmt.Println(color.BlueString("CERTIFICATE\n"))
-			fmt.Println(color.CyanString("Issuer:\n"))
-			fmt.Printf("common name: %s\n", parse.Issuer.CommonName)
-			fmt.Printf("Organization: %s\n", strings.Join(parse.Issuer.Organization, ","))
-			fmt.Printf("Organization Unit: %s\n", strings.Join(parse.Issuer.OrganizationalUnit, ","))
-
-			fmt.Println(color.CyanString("\nSubject:\n"))
-			fmt.Printf("common name: %s\n", parse.Subject.CommonName)
-			fmt.Printf("Organization: %s\n", strings.Join(parse.Subject.Organization, ","))
-			fmt.Printf("Organization Unit: %s\n", strings.Join(parse.Subject.OrganizationalUnit, ","))
+			fmt.Fprintf(w, "%s  %s%s\n", rc.Serial, localTime(rc.RevocationTime), reason)
+		}
+		fingerprints("Fingerprints", v.Fingerprints)
+	case *certinfo.PKCS7:
+		fmt.Fprintln(w, color.BlueString("PKCS7 (%d certificates, %d crls)", len(v.Certificates), len(v.CRLs)))
+		for _, c := range v.Certificates {
+			fmt.Fprintf(w, "\n-----------------------------------\n\n")
+			printText(w, c)
+		}
+		for _, c := range v.CRLs {
+			fmt.Fprintf(w, "\n-----------------------------------\n\n")
+			printText(w, c)
+		}
+	case *certinfo.Key:
+		fmt.Fprintln(w, color.BlueString(strings.ToUpper(v.Type)))
+		field("Format", v.Format)
+		if v.Encrypted {
+			field("Encrypted", "true, algorithm and public key are not available without passphrase")
+			return
+		}
+		field("Algorithm", v.Algorithm)
+		field("Curve", v.Curve)
+		field("Public Key", v.PublicKey)
+		if v.Fingerprints != nil {
+			fingerprints("Public Key Fingerprints", *v.Fingerprints)
 		}
-		count++
 	}
-	return nil
+}
+
+func printName(w io.Writer, n certinfo.Name) {
+	fmt.Fprintf(w, "%-26s%s\n", "DN:", n.DN)
+	if n.CommonName != "" {
+		fmt.Fprintf(w, "%-26s%s\n", "common name:", n.CommonName)
+	}
+	if len(n.Organization) > 0 {
+		fmt.Fprintf(w, "%-26s%s\n", "Organization:", strings.Join(n.Organization, ","))
+	}
+	if len(n.OrganizationalUnit) > 0 {
+		fmt.Fprintf(w, "%-26s%s\n", "Organization Unit:", strings.Join(n.OrganizationalUnit, ","))
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(parseCmd)
+
+	parseCmd.Flags().StringVarP(&Format, "format", "", "text", "set output format, support text, json and yaml")
 }
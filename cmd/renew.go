@@ -0,0 +1,143 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"path/filepath"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	使用原有的私钥续签证书, 根据原证书的主题和 SAN 生成 csr, 再按照 cert 命令的流程签发.
+
+	jcert-gm renew --cert node1.cert --key node1.key
+
+	未指定 --issuer 和 --profile 时, 使用证书清单中记录的签发机构和模板, 没有记录时查找签发了原证书的签发机构.
+	原证书不会被吊销, 需要时执行 revoke.
+*/
+
+// renewCmd represents the renew command
+var renewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "renew cert with the same key",
+	Long:  `build csr with the subject and sans of an existing cert, sign it by the existing key, and issue a new cert`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return renewCert()
+	},
+}
+
+func renewCert() error {
+	cert, err := readLeafCert(CertFile)
+	if err != nil {
+		return err
+	}
+	privateKey, err := readCertKey(KeyFile, cert)
+	if err != nil {
+		return err
+	}
+
+	configDir := filepath.Dir(viper.ConfigFileUsed())
+	s, err := store.Open(configDir)
+	if err != nil {
+		return err
+	}
+
+	// 未指定时使用证书清单中记录的签发机构和模板
+	issuerName, profileName := Issuer, Profile
+	if r, err := s.Get(cert.SerialNumber); err == nil {
+		if Issuer == "" {
+			issuerName = r.Issuer
+		}
+		if Profile == "" {
+			profileName = r.Profile
+		}
+	} else if Issuer == "" {
+		if issuerName, err = findIssuer(configDir, cert); err != nil {
+			return err
+		}
+	}
+
+	// 保留原证书的主题, 包括属性顺序以及 pkix.Name 不支持的属性
+	uris, err := san.URIs(cert.Extensions)
+	if err != nil {
+		return err
+	}
+	csr, err := writeCsr(Path, cert.Subject.CommonName, ca.CSROptions{
+		Subject:        cert.Subject,
+		RawSubject:     cert.RawSubject,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           uris,
+	}, privateKey)
+	if err != nil {
+		return err
+	}
+	issued, err := issueCert(issuerName, csr, profileName)
+	if err != nil {
+		return err
+	}
+	return writeCert(certFileName(csr), issued)
+}
+
+// readCertKey 读取证书的私钥, 私钥与证书的公钥不一致时返回错误
+func readCertKey(keyFile string, cert *x509.Certificate) (*sm2.PrivateKey, error) {
+	privateKey, err := keyfile.ReadFile(keyFile, keyfile.LeafPassphrase())
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalSm2PublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pub, cert.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("key does not match cert")
+	}
+	return privateKey, nil
+}
+
+// findIssuer 查找签发了证书的签发机构
+func findIssuer(configDir string, cert *x509.Certificate) (string, error) {
+	names, err := authority.List(configDir)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		issuer, err := authority.LoadCert(configDir, name)
+		if err != nil {
+			return "", err
+		}
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return name, nil
+		}
+	}
+	return "", errors.New("cert is not issued by this ca, set --issuer")
+}
+
+func init() {
+	rootCmd.AddCommand(renewCmd)
+
+	renewCmd.Flags().StringVarP(&CertFile, "cert", "", "", "set cert file path to renew")
+	renewCmd.Flags().StringVarP(&KeyFile, "key", "", "", "set private key file path of the cert")
+	renewCmd.Flags().StringVarP(&Issuer, "issuer", "", "", "set issuer intermediate ca name, default issuer of the cert")
+	renewCmd.Flags().StringVarP(&Profile, "profile", "", "", "set profile, default profile of the cert")
+	renewCmd.Flags().StringVarP(&Output, "output", "o", "pem", "set output format, support pem, pkcs7 and pkcs12")
+	renewCmd.Flags().StringVarP(&Requester, "requester", "", "", "set requester recorded in the certificate inventory, default current user")
+
+	_ = renewCmd.MarkFlagRequired("cert")
+	_ = renewCmd.MarkFlagRequired("key")
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// issueTestCert 生成私钥和 csr 并签发证书, 返回证书和私钥文件路径
+func issueTestCert(t *testing.T, name string, issuer string, profile string) (string, string) {
+	t.Helper()
+	Csr = newTestCsr(t, name, pkix.Name{CommonName: name, Organization: []string{"org1"}})
+	Issuer, Profile = issuer, profile
+	defer func() { Issuer, Profile = "", "" }()
+	if err := generateCert(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(Path, name+"-*.cert"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("cert files of %s = %v, %v, want one", name, files, err)
+	}
+	return files[0], filepath.Join(Path, name+".key")
+}
+
+func TestRenewCert(t *testing.T) {
+	tests := []struct {
+		name    string
+		issuer  string
+		profile string
+		// renewProfile 续签时指定的模板, 为空时使用证书清单中记录的模板
+		renewProfile string
+		otherKey     bool
+		wantIssuer   string
+		wantExtUsage x509.ExtKeyUsage
+		wantErr      string
+	}{
+		{name: "recorded profile", profile: "server", wantIssuer: "test root", wantExtUsage: x509.ExtKeyUsageServerAuth},
+		{name: "override profile", profile: "server", renewProfile: "client", wantIssuer: "test root", wantExtUsage: x509.ExtKeyUsageClientAuth},
+		{name: "recorded issuer", issuer: "org1", profile: "client", wantIssuer: "org1 SM2 CA", wantExtUsage: x509.ExtKeyUsageClientAuth},
+		{name: "key mismatch", profile: "server", otherKey: true, wantErr: "key does not match cert"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestConfig(t)
+			if err := generateIntermediateCA(ca.IntermediateOptions{
+				Name:     "org1",
+				Subject:  pkix.Name{CommonName: "org1 SM2 CA", Organization: []string{"org1"}},
+				KeyUsage: []string{"certSign", "crlSign"},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			certFile, keyFile := issueTestCert(t, "node1", tt.issuer, tt.profile)
+			old := readTestCerts(t, certFile)[0]
+			if tt.otherKey {
+				newTestCsr(t, "node2", pkix.Name{CommonName: "node2"})
+				keyFile = filepath.Join(Path, "node2.key")
+			}
+
+			CertFile, KeyFile, Profile = certFile, keyFile, tt.renewProfile
+			err := renewCert()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renewCert() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(Path, "*.cert"))
+			if len(files) != 2 {
+				t.Fatalf("cert files = %v, want 2", files)
+			}
+			renewed := readTestCerts(t, files[0])[0]
+			if files[0] == certFile {
+				renewed = readTestCerts(t, files[1])[0]
+			}
+
+			if !bytes.Equal(renewed.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo) || !bytes.Equal(renewed.RawSubject, old.RawSubject) {
+				t.Error("renewed cert does not keep the key and subject")
+			}
+			if renewed.SerialNumber.Cmp(old.SerialNumber) == 0 {
+				t.Error("renewed cert uses the same serial number")
+			}
+			if renewed.Issuer.CommonName != tt.wantIssuer {
+				t.Errorf("issuer = %s, want %s", renewed.Issuer.CommonName, tt.wantIssuer)
+			}
+			if len(renewed.ExtKeyUsage) != 1 || renewed.ExtKeyUsage[0] != tt.wantExtUsage {
+				t.Errorf("ext key usage = %v, want %v", renewed.ExtKeyUsage, tt.wantExtUsage)
+			}
+		})
+	}
+}
+
+func TestFindIssuer(t *testing.T) {
+	configDir := newTestConfig(t)
+	if err := generateIntermediateCA(ca.IntermediateOptions{
+		Name:     "org1",
+		Subject:  pkix.Name{CommonName: "org1 SM2 CA"},
+		KeyUsage: []string{"certSign", "crlSign"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rootCert, _ := issueTestCert(t, "node1", "", "")
+	root := readTestCerts(t, rootCert)[0]
+	org1Cert, _ := issueTestCert(t, "node2", "org1", "")
+	var org1 *x509.Certificate
+	for _, v := range readTestCerts(t, org1Cert) {
+		if v.Subject.CommonName == "node2" {
+			org1 = v
+		}
+	}
+
+	// 其他机构签发的证书
+	other := newTestConfig(t)
+	foreignCert, _ := issueTestCert(t, "node3", "", "")
+	foreign := readTestCerts(t, foreignCert)[0]
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		want    string
+		wantErr bool
+	}{
+		{name: "root", cert: root, want: ""},
+		{name: "intermediate", cert: org1, want: "org1"},
+		{name: "other ca", cert: foreign, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findIssuer(configDir, tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findIssuer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("findIssuer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if name, err := findIssuer(other, foreign); err != nil || name != "" {
+		t.Errorf("findIssuer() = %q, %v, want root of its own ca", name, err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	吊销证书, 可以指定证书序列号或者证书文件, 并记录到 CA 状态中, 之后重新生成签发机构的 CRL.
+
+	jcert-gm revoke --serial 1a2b3c --reason keyCompromise
+	jcert-gm revoke --cert node1.cert --issuer ops
+
+	指定证书文件但不指定 --issuer 时, 根据签名在所有签发机构中查找. 指定的签发机构需要与证书清单中的记录一致.
+	证书清单中没有记录的证书 (例如旧版本签发的证书) 需要指定证书文件, 由签名确认签发机构, 或者只指定序列号时明确指定 --issuer,
+	根 CA 为 --issuer "":
+
+	jcert-gm revoke --serial 1a2b3c --issuer ""
+*/
+
+var (
+	Serial   string
+	CertFile string
+	Reason   string
+)
+
+// revokeCmd represents the revoke command
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "revoke cert",
+	Long:  `revoke cert by serial number or cert file, and regenerate crl`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (Serial == "") == (CertFile == "") {
+			return errors.New("one of serial and cert must be set")
+		}
+		// 没有记录的证书需要确认签发机构
+		return revoke(cmd.Flags().Changed("issuer"))
+	},
+}
+
+// revoke 吊销证书, issuerSet 表示明确指定了 --issuer, 此时允许吊销证书清单中没有记录的序列号
+func revoke(issuerSet bool) error {
+	reason, err := ca.ParseReason(Reason)
+	if err != nil {
+		return err
+	}
+
+	var serial *big.Int
+	if Serial != "" {
+		serial, err = store.ParseSerial(strings.TrimPrefix(strings.ToLower(Serial), "0x"))
+		if err != nil {
+			return err
+		}
+	}
+
+	c, err := getCA()
+	if err != nil {
+		return err
+	}
+	issuerName := Issuer
+	unrecorded := issuerSet
+	if CertFile != "" {
+		cert, err := readLeafCert(CertFile)
+		if err != nil {
+			return err
+		}
+		if issuerName, err = certIssuer(c.ConfigDir(), cert); err != nil {
+			return err
+		}
+		serial = cert.SerialNumber
+		unrecorded = true
+	}
+
+	// 未指定签发机构时, 使用证书清单中记录的签发机构
+	_, err = c.Revoke(context.Background(), serial, ca.RevokeOptions{
+		Issuer:     issuerName,
+		Unrecorded: unrecorded,
+		Reason:     reason,
+		CRL:        ca.CRLOptions{NextUpdate: NextUpdate, Format: CRLFormat},
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return errors.Wrap(err, "not in the inventory, set --cert or --issuer to revoke it")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("revoked serial %s\n", store.SerialHex(serial))
+	return nil
+}
+
+// certIssuer 根据签名查找签发证书的机构, 只需要签发机构的证书, 不读取私钥. 指定了 --issuer 时只检查该机构
+func certIssuer(configDir string, cert *x509.Certificate) (string, error) {
+	names := []string{Issuer}
+	if Issuer == "" {
+		var err error
+		if names, err = authority.List(configDir); err != nil {
+			return "", err
+		}
+	}
+
+	for _, name := range names {
+		if !ca.ValidIssuer(name) {
+			return "", errors.Wrap(ca.ErrInvalidIssuer, name)
+		}
+		issuer, err := authority.LoadCert(configDir, name)
+		if err != nil {
+			return "", err
+		}
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return name, nil
+		}
+	}
+	if Issuer != "" {
+		return "", errors.Errorf("cert is not issued by %s", authorityName(Issuer))
+	}
+	return "", errors.New("cert is not issued by any authority in config dir")
+}
+
+// readLeafCert 读取证书文件中的第一个非 CA 证书, 没有时返回第一个证书
+func readLeafCert(path string) (*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var first *x509.Certificate
+	for {
+		block, rest := pem.Decode(b)
+		if block == nil {
+			break
+		}
+		b = rest
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if !cert.IsCA {
+			return cert, nil
+		}
+		if first == nil {
+			first = cert
+		}
+	}
+
+	if first == nil {
+		return nil, errors.New("type is not CERTIFICATE")
+	}
+	return first, nil
+}
+
+func init() {
+	rootCmd.AddCommand(revokeCmd)
+
+	revokeCmd.Flags().StringVarP(&Serial, "serial", "", "", "set hex serial number of the cert to revoke")
+	revokeCmd.Flags().StringVarP(&CertFile, "cert", "", "", "set cert file path to revoke")
+	revokeCmd.Flags().StringVarP(&Reason, "reason", "", "unspecified", "set revocation reason, name or code in RFC 5280")
+	revokeCmd.Flags().StringVarP(&Issuer, "issuer", "", "", "set issuer intermediate ca name, empty means root ca")
+	revokeCmd.Flags().DurationVarP(&NextUpdate, "next-update", "", 0, "set duration until next update of crl, default 168h")
+	revokeCmd.Flags().StringVarP(&CRLFormat, "format", "", "", "set crl format, support der and pem, default der")
+}
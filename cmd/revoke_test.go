@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+)
+
+func TestRevokeUnrecorded(t *testing.T) {
+	configDir := newTestConfig(t)
+	certFile, _ := issueTestCert(t, "node1", "", "")
+	defer func() { Serial = "" }()
+
+	tests := []struct {
+		name      string
+		serial    string
+		issuerSet bool
+		wantErr   error
+	}{
+		{name: "recorded serial", serial: store.SerialHex(readTestCerts(t, certFile)[0].SerialNumber)},
+		{name: "unrecorded serial", serial: "1a2b3c", wantErr: store.ErrNotFound},
+		{name: "unrecorded serial with issuer", serial: "1a2b3c", issuerSet: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Serial = tt.serial
+			err := revoke(tt.issuerSet)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("revoke() error = %v, want %v", err, tt.wantErr)
+			}
+			s, err := store.Open(configDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serial, _ := store.ParseSerial(tt.serial)
+			if revoked := s.Revocation("", serial) != nil; revoked != (tt.wantErr == nil) {
+				t.Errorf("revoked = %v, want %v", revoked, tt.wantErr == nil)
+			}
+		})
+	}
+}
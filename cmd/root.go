@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 
 	"github.com/fatih/color"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -50,6 +51,24 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.jcert-gm/config.toml)")
 	rootCmd.PersistentFlags().StringVarP(&Path, "path", "p", "", "generated file output path")
 
+	// 私钥加密, 参考 pkg/keyfile
+	rootCmd.PersistentFlags().Bool("encrypt-key", false, "encrypt generated private keys with passphrase")
+	rootCmd.PersistentFlags().String("key-cipher", "sm4", "set private key cipher, support sm4 and aes")
+	rootCmd.PersistentFlags().String("passphrase-file", "", "set private key passphrase file, or use env "+keyfile.PassphraseEnv)
+	rootCmd.PersistentFlags().String("ca-passphrase-file", "", "set ca private key passphrase file, or use env "+keyfile.CAPassphraseEnv)
+	rootCmd.PersistentFlags().String("p12-passphrase-file", "", "set pkcs12 passphrase file, or use env "+keyfile.P12PassphraseEnv)
+	rootCmd.PersistentFlags().String("p12-cipher", "sm4", "set pkcs12 cipher, support sm4, aes and 3des for compatibility")
+	for key, flag := range map[string]string{
+		"key.encrypt":           "encrypt-key",
+		"key.cipher":            "key-cipher",
+		"key.passphraseFile":    "passphrase-file",
+		"key.caPassphraseFile":  "ca-passphrase-file",
+		"pkcs12.passphraseFile": "p12-passphrase-file",
+		"pkcs12.cipher":         "p12-cipher",
+	} {
+		_ = viper.BindPFlag(key, rootCmd.PersistentFlags().Lookup(flag))
+	}
+
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
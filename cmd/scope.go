@@ -6,35 +6,362 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
+	"crypto/x509/pkix"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 /*
-	根据场景生成所有证书文件
+	根据场景生成所有证书文件.
+
+	场景文件描述了组织, 节点, 客户端以及它们的 SAN, 支持 yaml, json, toml 格式:
+
+	output: crypto-config
+	organizations:
+	  - name: org1
+	    domain: org1.example.com  # 节点未指定 sans 时默认为 <name>.<domain>
+	    intermediate: true        # 为组织签发中间 CA, 保存在配置目录的 intermediates/org1 下
+	    nodes:
+	      - name: node1
+	        sans: ["node1.org1.example.com", "192.168.1.10"]
+	      - name: node2
+	        tlcp: true            # 生成 TLCP 签名证书和加密证书
+	    clients:
+	      - name: admin
+	        sans: ["admin@org1.example.com", "spiffe://org1.example.com/admin"]  # IP, email (包含 @), URI (包含 ://) 自动识别
+
+	节点默认使用 server 模板, 客户端默认使用 client 模板, 可通过 profile 指定.
+	根 CA 不存在时会先初始化根 CA.
+
+	生成的目录结构:
+
+	crypto-config/
+	  ca.cert, crl.crl                  根 CA 证书以及 CRL
+	  org1/ca/                          组织的签发机构证书, 证书链以及 CRL
+	  org1/nodes/node1/                 node1.key, node1.pub, node1.csr, node1.cert (包含证书链)
+	  org1/nodes/node2/                 node2.sign.*, node2.enc.*
+	  org1/clients/admin/               admin.key, admin.pub, admin.csr, admin.cert
+
+	重复执行时, 已经存在有效证书 (与私钥匹配, 未过期, 未吊销, 由当前签发机构签发, 模板, 主题以及 SAN 与场景一致) 的实体会被跳过.
 */
 
+var ScopeFile string
+
 // scopeCmd represents the scope command
 var scopeCmd = &cobra.Command{
 	Use:   "scope",
 	Short: "build scope",
-	Long:  `build scope`,
-	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("scope called")
+	Long:  `build every key, csr, cert, chain and crl of a deployment from a topology manifest`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return buildScope()
 	},
 }
 
-func init() {
-	rootCmd.AddCommand(scopeCmd)
+type scopeManifest struct {
+	Output        string              `mapstructure:"output"`
+	Organizations []scopeOrganization `mapstructure:"organizations"`
+}
+
+type scopeOrganization struct {
+	Name         string        `mapstructure:"name"`
+	Domain       string        `mapstructure:"domain"`
+	Intermediate bool          `mapstructure:"intermediate"`
+	Nodes        []scopeEntity `mapstructure:"nodes"`
+	Clients      []scopeEntity `mapstructure:"clients"`
+}
+
+type scopeEntity struct {
+	Name    string   `mapstructure:"name"`
+	OU      []string `mapstructure:"OU"`
+	SANs    []string `mapstructure:"sans"`
+	Profile string   `mapstructure:"profile"`
+	TLCP    bool     `mapstructure:"tlcp"`
+}
+
+func buildScope() error {
+	m, err := readScopeManifest(ScopeFile)
+	if err != nil {
+		return err
+	}
+
+	output := m.Output
+	if output == "" {
+		output = "crypto-config"
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(Path, output)
+	}
+
+	// 根 CA 不存在时先初始化
+	if b, _ := afero.Exists(afero.NewOsFs(), filepath.Join(authorityDir(""), authority.CertFile)); !b {
+		fmt.Println("init root ca")
+		if err = generateAuthorityRootCA(); err != nil {
+			return err
+		}
+	}
+	if err = exportAuthority("", output); err != nil {
+		return err
+	}
+
+	for _, org := range m.Organizations {
+		issuerName := ""
+		if org.Intermediate {
+			issuerName = org.Name
+			if err = ensureOrganizationCA(org); err != nil {
+				return err
+			}
+		}
+		if err = exportAuthority(issuerName, filepath.Join(output, org.Name, "ca")); err != nil {
+			return err
+		}
+
+		issuer, err := loadAuthority(issuerName)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range org.Nodes {
+			if err = buildScopeEntity(issuer, org, v, filepath.Join(output, org.Name, "nodes", v.Name), "server"); err != nil {
+				return err
+			}
+		}
+		for _, v := range org.Clients {
+			if err = buildScopeEntity(issuer, org, v, filepath.Join(output, org.Name, "clients", v.Name), "client"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readScopeManifest(path string) (*scopeManifest, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var m scopeManifest
+	if err := v.Unmarshal(&m); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+
+	// 名称会作为目录名, 提前检查
+	names := make(map[string]bool)
+	for _, org := range m.Organizations {
+		if err := checkScopeName(org.Name); err != nil {
+			return nil, err
+		}
+		if names[org.Name] {
+			return nil, errors.Errorf("duplicate organization %s", org.Name)
+		}
+		names[org.Name] = true
+
+		entities := make(map[string]bool)
+		for _, v := range append(append([]scopeEntity{}, org.Nodes...), org.Clients...) {
+			if err := checkScopeName(v.Name); err != nil {
+				return nil, err
+			}
+			if entities[v.Name] {
+				return nil, errors.Errorf("duplicate node or client %s in organization %s", v.Name, org.Name)
+			}
+			entities[v.Name] = true
+		}
+	}
+	return &m, nil
+}
+
+func checkScopeName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errors.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// ensureOrganizationCA 为组织签发中间 CA, 已经存在时直接使用
+func ensureOrganizationCA(org scopeOrganization) error {
+	if b, _ := afero.Exists(afero.NewOsFs(), filepath.Join(authorityDir(org.Name), authority.CertFile)); b {
+		return nil
+	}
+
+	fmt.Printf("issue intermediate ca %s\n", org.Name)
+	return generateIntermediateCA(ca.IntermediateOptions{
+		Name: org.Name,
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("%s SM2 CA", org.Name),
+			Organization: []string{org.Name},
+		},
+		KeyUsage: []string{"certSign", "crlSign"},
+	})
+}
+
+// exportAuthority 将签发机构的证书, 证书链以及 CRL 复制到 dir 目录下
+func exportAuthority(name string, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	files := []string{authority.CertFile, "crl.crl", "crl.pem"}
+	if name != "" {
+		files = append(files, authority.ChainFile)
+	}
+	for _, v := range files {
+		b, err := os.ReadFile(filepath.Join(authorityDir(name), v))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dir, v), b, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildScopeEntity 为节点或客户端生成私钥, csr 以及证书, 已经存在有效证书时跳过
+func buildScopeEntity(issuer *authority.Authority, org scopeOrganization, e scopeEntity, dir string, defaultProfile string) error {
+	sans := e.SANs
+	if len(sans) == 0 && org.Domain != "" {
+		sans = []string{e.Name + "." + org.Domain}
+	}
+
+	names, err := san.Split(sans)
+	if err != nil {
+		return errors.Wrapf(err, "%s/%s", org.Name, e.Name)
+	}
+	opts := ca.CSROptions{
+		Subject: pkix.Name{
+			CommonName:         e.Name,
+			Organization:       []string{org.Name},
+			OrganizationalUnit: e.OU,
+		},
+		DNSNames:       names.DNSNames,
+		IPAddresses:    names.IPAddresses,
+		EmailAddresses: names.EmailAddresses,
+		URIs:           names.URIs,
+	}
+
+	type pair struct{ name, profile string }
+	pairs := []pair{{e.Name, e.Profile}}
+	if e.TLCP {
+		pairs = []pair{{e.Name + ".sign", e.Profile}, {e.Name + ".enc", "tlcp-enc"}}
+		if pairs[0].profile == "" {
+			pairs[0].profile = "tlcp-sign"
+		}
+	} else if pairs[0].profile == "" {
+		pairs[0].profile = defaultProfile
+	}
+
+	s, err := store.Open(filepath.Dir(viper.ConfigFileUsed()))
+	if err != nil {
+		return err
+	}
 
-	// Here you will define your flags and configuration settings.
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, v := range pairs {
+		if validScopeCert(s, issuer, filepath.Join(dir, v.name), opts, v.profile) {
+			fmt.Printf("skip %s/%s\n", org.Name, v.name)
+			continue
+		}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// scopeCmd.PersistentFlags().String("foo", "", "A help for foo")
+		if err = generateKeyAndCsr(dir, v.name, opts); err != nil {
+			return err
+		}
+		csr, err := readCsr(filepath.Join(dir, v.name+".csr"))
+		if err != nil {
+			return err
+		}
+		cert, err := issueCert(issuer.Name, csr, v.profile)
+		if err != nil {
+			return errors.Wrapf(err, "%s/%s", org.Name, v.name)
+		}
+		b, err := cert.Encode(ca.FormatPEM)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dir, v.name+".cert"), b, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("issue %s/%s\n", org.Name, v.name)
+	}
+	return nil
+}
+
+// validScopeCert 检查 path 对应的私钥和证书是否存在且匹配, 证书未过期, 未吊销, 由当前签发机构使用模板 profileName 签发,
+// 主题 (CN, O, OU) 以及 SAN 与场景一致
+func validScopeCert(s *store.Store, issuer *authority.Authority, path string, opts ca.CSROptions, profileName string) bool {
+	cert, err := readLeafCert(path + ".cert")
+	if err != nil {
+		return false
+	}
+	if _, err = readCertKey(path+".key", cert); err != nil {
+		return false
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return false
+	}
+	if cert.CheckSignatureFrom(issuer.Cert) != nil {
+		return false
+	}
+	if s.Revocation(issuer.Name, cert.SerialNumber) != nil {
+		return false
+	}
+	// 场景中修改了模板时重新签发, 没有记录时无法确认模板
+	if r, err := s.Get(cert.SerialNumber); err != nil || r.Profile != profileName {
+		return false
+	}
+
+	if cert.Subject.CommonName != opts.Subject.CommonName ||
+		!equalStrings(cert.Subject.Organization, opts.Subject.Organization) ||
+		!equalStrings(cert.Subject.OrganizationalUnit, opts.Subject.OrganizationalUnit) {
+		return false
+	}
+	certURIs, _ := san.URIs(cert.Extensions)
+	return equalStrings(cert.DNSNames, opts.DNSNames) &&
+		equalStrings(cert.EmailAddresses, opts.EmailAddresses) &&
+		equalStrings(ipStrings(cert.IPAddresses), ipStrings(opts.IPAddresses)) &&
+		equalStrings(san.Names{URIs: certURIs}.Strings(), san.Names{URIs: opts.URIs}.Strings())
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, 0, len(ips))
+	for _, v := range ips {
+		s = append(s, v.String())
+	}
+	return s
+}
+
+// equalStrings 忽略顺序比较两个字符串列表
+func equalStrings(a, b []string) bool {
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+func init() {
+	rootCmd.AddCommand(scopeCmd)
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// scopeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	scopeCmd.Flags().StringVarP(&ScopeFile, "file", "f", "topology.yaml", "set topology manifest file path")
 }
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildScopeTwice(t *testing.T) {
+	newTestConfig(t)
+	defer func() { ScopeFile = "" }()
+	const manifest = `
+organizations:
+  - name: org1
+    domain: org1.example.com
+    intermediate: true
+    nodes:
+      - name: node1
+`
+	ScopeFile = filepath.Join(t.TempDir(), "topology.yaml")
+	writeManifest := func(m string) {
+		if err := os.WriteFile(ScopeFile, []byte(m), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certFile := func(node string) string {
+		return filepath.Join(Path, "crypto-config", "org1", "nodes", node, node+".cert")
+	}
+	readCerts := func() map[string][]byte {
+		certs := map[string][]byte{}
+		for _, v := range []string{"node1", "node2", "node3", "node4"} {
+			b, err := os.ReadFile(certFile(v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs[v] = b
+		}
+		return certs
+	}
+
+	// 避免输出到测试日志中
+	stdout := os.Stdout
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	defer func() {
+		os.Stdout.Close()
+		os.Stdout = stdout
+	}()
+
+	writeManifest(manifest + `
+      - name: node2
+      - name: node3
+      - name: node4
+`)
+	if err := buildScope(); err != nil {
+		t.Fatal(err)
+	}
+	first := readCerts()
+	if err := buildScope(); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range readCerts() {
+		if !bytes.Equal(v, first[k]) {
+			t.Errorf("%s is reissued, want skipped", k)
+		}
+	}
+
+	// node2 修改 OU, node3 修改模板, node4 的私钥被替换
+	writeManifest(manifest + `
+      - name: node2
+        OU: ["peer"]
+      - name: node3
+        profile: tlcp-sign
+      - name: node4
+`)
+	node1Key := filepath.Join(Path, "crypto-config", "org1", "nodes", "node1", "node1.key")
+	node4Key := filepath.Join(Path, "crypto-config", "org1", "nodes", "node4", "node4.key")
+	b, err := os.ReadFile(node1Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(node4Key, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = buildScope(); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range readCerts() {
+		if reissued, want := !bytes.Equal(v, first[k]), k != "node1"; reissued != want {
+			t.Errorf("%s reissued = %v, want %v", k, reissued, want)
+		}
+	}
+	if cert := readTestCerts(t, certFile("node2"))[0]; len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "peer" {
+		t.Errorf("OU of node2 = %v, want peer", cert.Subject.OrganizationalUnit)
+	}
+}
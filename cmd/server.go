@@ -6,35 +6,101 @@ Copyright © 2023 jaronnie <jaron@jaronnie.com>
 package cmd
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
 	"github.com/jaronnie/jcert-gm/server"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
 )
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
 	Use:   "server",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "start web server",
+	Long: `start web server with web ui, json api and ocsp responder.
+
+api requests are authenticated by tokens, basic auth or client certificates,
+configured in [server.auth] of the config file.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+besides http, the server can listen on tls and tlcp at the same time, server
+certificates are issued by the ca automatically on first start`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return server.RunServer()
 	},
 }
 
+// serverPasswdCmd represents the server passwd command
+var serverPasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "hash password for basic auth",
+	Long:  `read password from terminal or stdin, and print bcrypt hash for [[server.auth.users]] of the config file`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return hashPassword()
+	},
+}
+
+func hashPassword() error {
+	var password []byte
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Enter password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, "Verifying - Enter password: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b, again) {
+			return errors.New("passwords do not match")
+		}
+		password = b
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return errors.Wrap(err, "read password from stdin")
+		}
+		password = bytes.TrimRight(line, "\r\n")
+	}
+	if len(password) == 0 {
+		return errors.New("password is empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(serverCmd)
+	serverCmd.AddCommand(serverPasswdCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// serverCmd.PersistentFlags().String("foo", "", "A help for foo")
+	serverCmd.Flags().String("addr", ":9999", "set http listen address, empty means not listen")
+	serverCmd.Flags().String("tls-addr", "", "set tls listen address, empty means not listen")
+	serverCmd.Flags().String("tlcp-addr", "", "set tlcp listen address, empty means not listen")
+	serverCmd.Flags().String("client-auth", "none", "set client certificate mode of tls and tlcp, support none, request and require")
+	serverCmd.Flags().StringSlice("host", nil, "set san of auto issued server certificates, default localhost, 127.0.0.1 and hostname")
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// serverCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	for key, flag := range map[string]string{
+		"server.addr":       "addr",
+		"server.tls.addr":   "tls-addr",
+		"server.tlcp.addr":  "tlcp-addr",
+		"server.clientAuth": "client-auth",
+		"server.hosts":      "host",
+	} {
+		_ = viper.BindPFlag(key, serverCmd.Flags().Lookup(flag))
+	}
 }
@@ -11,57 +11,343 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/emmansun/gmsm/pkcs7"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/pkcs12"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
 )
 
+/*
+	证书格式转换, 不指定 --from 时自动识别输入格式, 不指定 -o 时输出到标准输出.
+
+	jcert-gm trans node1.p7b --to pem
+	jcert-gm trans node1.cert --key node1.key --to pkcs12 -o node1.p12
+	jcert-gm trans node1.p12 --to pem -o node1.pem
+
+	支持的格式:
+	1. pem: 证书链以及私钥
+	2. der: 单个证书, 输入中有多个证书时只输出终端证书
+	3. pkcs7: der 编码的 PKCS#7 证书包
+	4. pkcs7-base64: base64 编码的 PKCS#7 证书包, 即 cert -o pkcs7 生成的格式
+	5. pkcs7-pem: PEM 格式的 PKCS#7 证书包 (-----BEGIN PKCS7-----)
+	6. pkcs12: 证书, 证书链以及 SM2 私钥, 使用口令保护, 参考 pkg/pkcs12
+
+	der 和 pkcs7 不能保存私钥, 输出时丢弃私钥并提示. 输出 pkcs12 时需要私钥, 可以来自输入或者 --key.
+	PKCS#12 的口令来自 --p12-passphrase-file 或环境变量 JCERT_GM_P12_PASSPHRASE, 否则终端提示.
+
+	输出 pem 时私钥按 --encrypt-key 以及私钥口令决定是否加密, 未加密的私钥需要指定 -o 保存到文件, 或者指定 --print-key 输出到标准输出:
+
+	jcert-gm trans node1.p12 --to pem --print-key
+*/
+
+const (
+	FormatPEM         = "pem"
+	FormatDER         = "der"
+	FormatPKCS7       = "pkcs7"
+	FormatPKCS7Base64 = "pkcs7-base64"
+	FormatPKCS7PEM    = "pkcs7-pem"
+	FormatPKCS12      = "pkcs12"
+)
+
+var transFormats = []string{FormatPEM, FormatDER, FormatPKCS7, FormatPKCS7Base64, FormatPKCS7PEM, FormatPKCS12}
+
+var (
+	From     string
+	To       string
+	OutFile  string
+	PrintKey bool
+)
+
+// transBundle 转换的内容, certs 为 der 格式
+type transBundle struct {
+	certs [][]byte
+	key   *sm2.PrivateKey
+}
+
 // transCmd represents the trans command
 var transCmd = &cobra.Command{
 	Use:   "trans",
-	Short: "trans pkcs7 to pem",
-	Long:  `trans pkcs7 to pem`,
+	Short: "trans certs and key between pem, der, pkcs7 and pkcs12",
+	Long:  `trans certs and key between ` + strings.Join(transFormats, ", ") + `, input format is detected automatically`,
 	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		f := args[0]
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !validFormat(To) || From != "" && !validFormat(From) {
+			return errors.Errorf("not support format, support %s", strings.Join(transFormats, ", "))
+		}
 
-		b, err := os.ReadFile(f)
-		cobra.CheckErr(err)
+		b, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
 
-		p, err := base64.StdEncoding.DecodeString(string(b))
-		cobra.CheckErr(err)
+		from := From
+		if from == "" {
+			if from = detectFormat(b); from == "" {
+				return errors.Errorf("unknown format of %s, set it by --from", args[0])
+			}
+		}
+		bundle, err := readBundle(b, from)
+		if err != nil {
+			return errors.Wrapf(err, "read %s as %s", args[0], from)
+		}
+		if KeyFile != "" {
+			if bundle.key, err = keyfile.ReadFile(KeyFile, keyfile.LeafPassphrase()); err != nil {
+				return err
+			}
+		}
+		if len(bundle.certs) == 0 {
+			return errors.Errorf("no cert found in %s", args[0])
+		}
+		// 避免未加密的私钥意外输出到终端或者日志中
+		if OutFile == "" && !PrintKey && To == FormatPEM && bundle.key != nil && !encryptKey() {
+			return errors.New("refuse to write unencrypted private key to stdout, set -o, --encrypt-key or --print-key")
+		}
 
-		// 解码 PKCS#7 数据
-		certs, err := pkcs7.Parse(p)
+		out, err := writeBundle(bundle, To)
 		if err != nil {
-			fmt.Println("Error parsing PKCS#7 data:", err)
-			os.Exit(1)
+			return err
+		}
+		if OutFile == "" {
+			_, err = os.Stdout.Write(out)
+			return err
 		}
+		// 包含私钥时与私钥文件的权限相同
+		if bundle.key != nil && (To == FormatPEM || To == FormatPKCS12) {
+			return keyfile.WriteFile(OutFile, out)
+		}
+		return os.WriteFile(OutFile, out, 0o644)
+	},
+}
+
+// encryptKey 输出 pem 时是否加密私钥, 与 keyfile.Encode 一致
+func encryptKey() bool {
+	return viper.GetBool("key.encrypt") || keyfile.LeafPassphrase().Available()
+}
 
-		// 将解码后的证书转换为 PEM 格式
+func validFormat(format string) bool {
+	for _, v := range transFormats {
+		if v == format {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFormat 识别输入格式, 无法识别时返回空
+func detectFormat(b []byte) string {
+	if block, _ := pem.Decode(b); block != nil {
+		for rest := b; ; {
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				return FormatPEM
+			}
+			if block.Type == "PKCS7" {
+				return FormatPKCS7PEM
+			}
+		}
+	}
+	if _, err := x509.ParseCertificate(b); err == nil {
+		return FormatDER
+	}
+	if _, err := pkcs7.Parse(b); err == nil {
+		return FormatPKCS7
+	}
+	if pkcs12.Detect(b) {
+		return FormatPKCS12
+	}
+	if der, err := decodeBase64(b); err == nil {
+		if _, err := pkcs7.Parse(der); err == nil {
+			return FormatPKCS7Base64
+		}
+	}
+	return ""
+}
+
+func readBundle(b []byte, format string) (*transBundle, error) {
+	bundle := &transBundle{}
+	switch format {
+	case FormatPEM, FormatPKCS7PEM:
+		for {
+			block, rest := pem.Decode(b)
+			if block == nil {
+				break
+			}
+			b = rest
+
+			switch block.Type {
+			case "CERTIFICATE":
+				if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+					return nil, err
+				}
+				bundle.certs = append(bundle.certs, block.Bytes)
+			case "PKCS7":
+				certs, err := readPKCS7(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				bundle.certs = append(bundle.certs, certs...)
+			case "PRIVATE KEY", "EC PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+				key, err := keyfile.Parse(pem.EncodeToMemory(block), keyfile.LeafPassphrase())
+				if err != nil {
+					return nil, err
+				}
+				bundle.key = key
+			default:
+				fmt.Fprintf(os.Stderr, "skip unsupported PEM block %s\n", block.Type)
+			}
+		}
+	case FormatDER:
+		if _, err := x509.ParseCertificate(b); err != nil {
+			return nil, err
+		}
+		bundle.certs = [][]byte{b}
+	case FormatPKCS7:
+		certs, err := readPKCS7(b)
+		if err != nil {
+			return nil, err
+		}
+		bundle.certs = certs
+	case FormatPKCS7Base64:
+		der, err := decodeBase64(b)
+		if err != nil {
+			return nil, err
+		}
+		certs, err := readPKCS7(der)
+		if err != nil {
+			return nil, err
+		}
+		bundle.certs = certs
+	case FormatPKCS12:
+		pwd, err := keyfile.PKCS12Passphrase().Get()
+		if err != nil {
+			return nil, err
+		}
+		keyDER, certs, err := pkcs12.Decode(b, pwd)
+		if err != nil {
+			return nil, err
+		}
+		if keyDER != nil {
+			if bundle.key, err = x509.ParsePKCS8UnecryptedPrivateKey(keyDER); err != nil {
+				return nil, errors.Wrap(err, "only sm2 private key is supported")
+			}
+		}
+		bundle.certs = certs
+	}
+	return bundle, nil
+}
+
+func readPKCS7(der []byte) ([][]byte, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, err
+	}
+	var certs [][]byte
+	for _, v := range p7.Certificates {
+		certs = append(certs, v.Raw)
+	}
+	return certs, nil
+}
+
+func decodeBase64(b []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(b)), ""))
+}
+
+func writeBundle(bundle *transBundle, format string) ([]byte, error) {
+	if bundle.key != nil && format != FormatPEM && format != FormatPKCS12 {
+		fmt.Fprintf(os.Stderr, "private key is dropped, %s can not hold private key\n", format)
+	}
+
+	switch format {
+	case FormatPEM:
 		buffer := &bytes.Buffer{}
-		for _, cert := range certs.Certificates {
-			block := &pem.Block{
-				Type:  "CERTIFICATE",
-				Bytes: cert.Raw,
+		for _, v := range bundle.certs {
+			_ = pem.Encode(buffer, &pem.Block{Type: "CERTIFICATE", Bytes: v})
+		}
+		if bundle.key != nil {
+			keyPEM, err := keyfile.Encode(bundle.key, keyfile.LeafPassphrase())
+			if err != nil {
+				return nil, err
 			}
-			pemData := pem.EncodeToMemory(block)
-			buffer.Write(pemData)
+			buffer.Write(keyPEM)
 		}
-		fmt.Printf("%s\n", buffer.Bytes())
-	},
+		return buffer.Bytes(), nil
+	case FormatDER:
+		leaf, err := leafIndex(bundle)
+		if err != nil {
+			return nil, err
+		}
+		if len(bundle.certs) > 1 {
+			fmt.Fprintf(os.Stderr, "%d certs of chain are dropped, der can only hold one cert\n", len(bundle.certs)-1)
+		}
+		return bundle.certs[leaf], nil
+	case FormatPKCS12:
+		if bundle.key == nil {
+			return nil, errors.New("private key is required for pkcs12, set it by --key")
+		}
+		return encodePKCS12(bundle.key, bundle.certs, "")
+	}
+
+	p7b, err := pkcs7.DegenerateCertificate(bytes.Join(bundle.certs, nil))
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatPKCS7Base64:
+		return []byte(base64.StdEncoding.EncodeToString(p7b)), nil
+	case FormatPKCS7PEM:
+		return pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7b}), nil
+	}
+	return p7b, nil
 }
 
-func init() {
-	rootCmd.AddCommand(transCmd)
+// leafIndex 返回终端证书的位置: 有私钥时为与私钥匹配的证书, 否则为第一个非 CA 证书
+func leafIndex(bundle *transBundle) (int, error) {
+	var spki []byte
+	if bundle.key != nil {
+		var err error
+		if spki, err = x509.MarshalSm2PublicKey(&bundle.key.PublicKey); err != nil {
+			return 0, err
+		}
+	}
 
-	// Here you will define your flags and configuration settings.
+	leaf := -1
+	for i, v := range bundle.certs {
+		cert, err := x509.ParseCertificate(v)
+		if err != nil {
+			return 0, err
+		}
+		if spki != nil {
+			if bytes.Equal(cert.RawSubjectPublicKeyInfo, spki) {
+				return i, nil
+			}
+			continue
+		}
+		if leaf == -1 && !cert.IsCA {
+			leaf = i
+		}
+	}
+	if spki != nil {
+		return 0, errors.New("private key does not match any cert")
+	}
+	if leaf == -1 {
+		leaf = 0
+	}
+	return leaf, nil
+}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// transCmd.PersistentFlags().String("foo", "", "A help for foo")
+func init() {
+	rootCmd.AddCommand(transCmd)
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// transCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	transCmd.Flags().StringVarP(&From, "from", "", "", "set input format, detected automatically by default, support "+strings.Join(transFormats, ", "))
+	transCmd.Flags().StringVarP(&To, "to", "", FormatPEM, "set output format, support "+strings.Join(transFormats, ", "))
+	transCmd.Flags().StringVarP(&OutFile, "out", "o", "", "set output file, default stdout")
+	transCmd.Flags().StringVarP(&KeyFile, "key", "", "", "set private key file, required when trans cert to pkcs12 without key")
+	transCmd.Flags().BoolVarP(&PrintKey, "print-key", "", false, "allow writing unencrypted private key to stdout")
 }
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTransBundle 返回终端证书, CA 证书以及终端证书的私钥
+func newTransBundle(t *testing.T) *transBundle {
+	t.Helper()
+	root := testcert.New(t, testcert.CATemplate("test root", -1), nil)
+	leaf := testcert.New(t, &x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}, root)
+	return &transBundle{certs: [][]byte{leaf.Cert.Raw, root.Cert.Raw}, key: leaf.Key}
+}
+
+func TestTransRoundTrip(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv(keyfile.PassphraseEnv, "key passphrase")
+	t.Setenv(keyfile.P12PassphraseEnv, "p12 passphrase")
+	bundle := newTransBundle(t)
+
+	tests := []struct {
+		format string
+		// certs 转换后保留的证书, 为 bundle.certs 中的位置
+		certs []int
+		key   bool
+	}{
+		{format: FormatPEM, certs: []int{0, 1}, key: true},
+		{format: FormatDER, certs: []int{0}},
+		{format: FormatPKCS7, certs: []int{0, 1}},
+		{format: FormatPKCS7Base64, certs: []int{0, 1}},
+		{format: FormatPKCS7PEM, certs: []int{0, 1}},
+		{format: FormatPKCS12, certs: []int{0, 1}, key: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out, err := writeBundle(bundle, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := detectFormat(out); got != tt.format {
+				t.Errorf("detectFormat() = %s, want %s", got, tt.format)
+			}
+
+			got, err := readBundle(out, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.certs) != len(tt.certs) {
+				t.Fatalf("certs = %d, want %d", len(got.certs), len(tt.certs))
+			}
+			for i, v := range tt.certs {
+				if !bytes.Equal(got.certs[i], bundle.certs[v]) {
+					t.Errorf("cert %d does not match", i)
+				}
+			}
+			if (got.key != nil) != tt.key {
+				t.Fatalf("key = %v, want %v", got.key != nil, tt.key)
+			}
+			if got.key != nil && got.key.D.Cmp(bundle.key.D) != 0 {
+				t.Error("key does not match")
+			}
+		})
+	}
+}
+
+func TestDetectFormatUnknown(t *testing.T) {
+	for name, data := range map[string][]byte{"garbage": []byte("garbage"), "base64 garbage": []byte("Z2FyYmFnZQ==")} {
+		if got := detectFormat(data); got != "" {
+			t.Errorf("detectFormat(%s) = %s, want empty", name, got)
+		}
+	}
+}
+
+func TestLeafIndex(t *testing.T) {
+	bundle := newTransBundle(t)
+	leaf, root := bundle.certs[0], bundle.certs[1]
+	other := newTransBundle(t)
+
+	tests := []struct {
+		name    string
+		bundle  *transBundle
+		want    int
+		wantErr bool
+	}{
+		{name: "key", bundle: &transBundle{certs: [][]byte{root, leaf}, key: bundle.key}, want: 1},
+		{name: "first non ca", bundle: &transBundle{certs: [][]byte{root, leaf}}, want: 1},
+		{name: "all ca", bundle: &transBundle{certs: [][]byte{root}}, want: 0},
+		{name: "key mismatch", bundle: &transBundle{certs: [][]byte{root, leaf}, key: other.key}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := leafIndex(tt.bundle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("leafIndex() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("leafIndex() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransPrivateKeyToStdout(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	defer func() { From, To, OutFile, PrintKey = "", FormatPEM, "", false }()
+	t.Setenv(keyfile.PassphraseEnv, "")
+	t.Setenv(keyfile.P12PassphraseEnv, "p12 passphrase")
+
+	dir := t.TempDir()
+	p12, err := writeBundle(newTransBundle(t), FormatPKCS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "node1.p12")
+	if err = os.WriteFile(input, p12, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		to       string
+		out      string
+		printKey bool
+		encrypt  bool
+		wantErr  bool
+	}{
+		{name: "refuse", to: FormatPEM, wantErr: true},
+		{name: "print key", to: FormatPEM, printKey: true},
+		{name: "encrypt key", to: FormatPEM, encrypt: true},
+		{name: "out file", to: FormatPEM, out: filepath.Join(dir, "node1.pem")},
+		{name: "no key in output", to: FormatPKCS7PEM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			To, OutFile, PrintKey = tt.to, tt.out, tt.printKey
+			if tt.encrypt {
+				viper.Set("key.encrypt", true)
+				t.Setenv(keyfile.PassphraseEnv, "key passphrase")
+				defer viper.Set("key.encrypt", false)
+			}
+
+			// 避免私钥输出到测试日志中
+			stdout := os.Stdout
+			os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			err := transCmd.RunE(transCmd, []string{input})
+			os.Stdout.Close()
+			os.Stdout = stdout
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("trans error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "--print-key") {
+				t.Errorf("trans error = %v, want hint of --print-key", err)
+			}
+			if tt.out != "" {
+				if info, err := os.Stat(tt.out); err != nil || info.Mode().Perm() != 0o600 {
+					t.Errorf("out file = %v, %v, want mode 0600", info, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2023 jaronnie <jaron@jaronnie.com>
+
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/verify"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	校验证书链, 逐级输出每个证书的检查结果, 校验失败时退出码非 0.
+
+	jcert-gm verify node1.cert --purpose serverAuth --host node1.example.com --check-crl
+
+	默认信任配置目录中的根 CA, 中间 CA 作为候选的签发者, 检查吊销状态时使用各签发机构目录下的 CRL.
+	指定 --roots 时只信任指定的根证书, 证书文件中终端证书之后的证书以及 --intermediates 作为候选的签发者.
+*/
+
+var (
+	Roots         []string
+	Intermediates []string
+	Purpose       string
+	Host          string
+	CRLFiles      []string
+	CheckCRL      bool
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verify cert chain",
+	Long:  `build chain from cert to trusted roots, and check signature, validity, ca constraints, name constraints, purpose, hostname and crl of each cert`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := verifyOptions(args[0])
+		if err != nil {
+			return err
+		}
+		leaf, err := readLeafCert(args[0])
+		if err != nil {
+			return err
+		}
+
+		// 校验失败时只输出原因, 不输出帮助
+		cmd.SilenceUsage = true
+		result := verify.Verify(leaf, opts)
+		printVerifyResult(result)
+		if !result.OK() {
+			return errors.Errorf("verify failed, %d errors", len(result.Errors()))
+		}
+		return nil
+	},
+}
+
+// verifyOptions 根据命令行参数以及配置目录准备信任的根证书, 中间证书以及 CRL
+func verifyOptions(certFile string) (verify.Options, error) {
+	opts := verify.Options{
+		Host:     Host,
+		CheckCRL: CheckCRL || len(CRLFiles) > 0,
+	}
+	if Purpose != "" {
+		usages, err := profile.ParseExtKeyUsage([]string{Purpose})
+		if err != nil {
+			return opts, err
+		}
+		opts.KeyUsages = usages
+	}
+
+	// 证书文件中终端证书之后的证书链
+	certs, err := readCerts(certFile)
+	if err != nil {
+		return opts, err
+	}
+	opts.Intermediates = append(opts.Intermediates, certs...)
+	for _, v := range Intermediates {
+		certs, err := readCerts(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Intermediates = append(opts.Intermediates, certs...)
+	}
+	for _, v := range CRLFiles {
+		crl, err := readCRL(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.CRLs = append(opts.CRLs, crl)
+	}
+
+	if len(Roots) > 0 {
+		for _, v := range Roots {
+			certs, err := readCerts(v)
+			if err != nil {
+				return opts, err
+			}
+			opts.Roots = append(opts.Roots, certs...)
+		}
+		return opts, nil
+	}
+
+	// 默认使用配置目录中的签发机构
+	configDir := filepath.Dir(viper.ConfigFileUsed())
+	names, err := authority.List(configDir)
+	if err != nil {
+		return opts, err
+	}
+	for _, name := range names {
+		cert, err := authority.LoadCert(configDir, name)
+		if err != nil {
+			return opts, err
+		}
+		if name == "" {
+			opts.Roots = append(opts.Roots, cert)
+		} else {
+			opts.Intermediates = append(opts.Intermediates, cert)
+		}
+
+		if !opts.CheckCRL {
+			continue
+		}
+		for _, file := range []string{ca.CRLFileDER, ca.CRLFilePEM} {
+			path := filepath.Join(authority.Dir(configDir, name), file)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			crl, err := readCRL(path)
+			if err != nil {
+				return opts, err
+			}
+			opts.CRLs = append(opts.CRLs, crl)
+		}
+	}
+	return opts, nil
+}
+
+func printVerifyResult(result *verify.Result) {
+	// 检查项名称按最长的名称对齐, 与详情之间至少保留两个空格
+	width := 0
+	for _, link := range result.Chain {
+		for _, c := range link.Checks {
+			if len(c.Name) > width {
+				width = len(c.Name)
+			}
+		}
+	}
+	width += 2
+
+	for i, link := range result.Chain {
+		anchor := ""
+		if link.Anchor {
+			anchor = color.CyanString(" (trust anchor)")
+		}
+		fmt.Printf("[%d] %s%s\n", i, color.BlueString(link.Cert.Subject.String()), anchor)
+		for _, c := range link.Checks {
+			if c.Err != nil {
+				fmt.Printf("    %s %-*s%s\n", color.RedString("FAIL"), width, c.Name, c.Err)
+				continue
+			}
+			fmt.Printf("    %s   %-*s%s\n", color.GreenString("OK"), width, c.Name, c.Detail)
+		}
+	}
+	if result.Err != nil {
+		fmt.Printf("%s %s\n", color.RedString("FAIL"), result.Err)
+	}
+	if result.OK() {
+		fmt.Println(color.GreenString("verify ok"))
+	}
+}
+
+// readCerts 读取 PEM 格式的证书包或者 der 格式的证书
+func readCerts(path string) ([]*x509.Certificate, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block == nil {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read certs %s", path)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+	for {
+		block, rest := pem.Decode(b)
+		if block == nil {
+			break
+		}
+		b = rest
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read certs %s", path)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// readCRL 读取 der 或 PEM 格式的 CRL
+func readCRL(path string) (*pkix.CertificateList, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseCRL(bytes.TrimSpace(b))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read crl %s", path)
+	}
+	return crl, nil
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().StringArrayVarP(&Roots, "roots", "", nil, "set trusted root certs file, default root ca in config dir")
+	verifyCmd.Flags().StringArrayVarP(&Intermediates, "intermediates", "", nil, "set intermediate certs file")
+	verifyCmd.Flags().StringVarP(&Purpose, "purpose", "", "", "set ext key usage to check, such as serverAuth, clientAuth, codeSigning, emailProtection, any")
+	verifyCmd.Flags().StringVarP(&Host, "host", "", "", "set dns name or ip to check")
+	verifyCmd.Flags().StringArrayVarP(&CRLFiles, "crl", "", nil, "set crl file, der or pem, implies --check-crl")
+	verifyCmd.Flags().BoolVarP(&CheckCRL, "check-crl", "", false, "check revocation status with crl, default crl of authorities in config dir")
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/jaronnie/jcert-gm/pkg/verify"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestPrintVerifyResult(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	result := &verify.Result{Chain: []*verify.Link{{
+		Cert: &x509.Certificate{},
+		Checks: []verify.Check{
+			{Name: verify.CheckSignature, Detail: "sm2-sm3"},
+			{Name: verify.CheckNames, Detail: "none"},
+			{Name: verify.CheckRevocation, Err: errors.New("revoked")},
+		},
+	}}}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	printVerifyResult(result)
+	os.Stdout = stdout
+	w.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"OK   signature         sm2-sm3",
+		"OK   name constraints  none",
+		"FAIL revocation        revoked",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output does not contain %q:\n%s", want, b)
+		}
+	}
+}
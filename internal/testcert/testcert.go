@@ -0,0 +1,124 @@
+package testcert
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	各个包的测试共用的 SM2 证书, 只在测试中使用:
+
+	root := testcert.New(t, testcert.CATemplate("test root", -1), nil)
+	leaf := testcert.New(t, &x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}, root)
+	testcert.WriteAuthority(t, configDir, "", root)
+*/
+
+// Cert 证书以及私钥
+type Cert struct {
+	Cert *x509.Certificate
+	Key  *sm2.PrivateKey
+}
+
+// PEM 返回 PEM 格式的证书
+func (c *Cert) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// New 生成新的私钥以及由 parent 签发的证书, parent 为 nil 时自签名.
+// template 中未设置的序列号为随机数, 有效期为一小时前到一年后, CA 证书的 Subject Key Identifier 由公钥计算
+func New(t testing.TB, template *x509.Certificate, parent *Cert) *Cert {
+	t.Helper()
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber == nil {
+		if template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().AddDate(1, 0, 0)
+	}
+	if template.IsCA && template.SubjectKeyId == nil {
+		ski := sha1.Sum(elliptic.Marshal(key.Curve, key.X, key.Y))
+		template.SubjectKeyId = ski[:]
+	}
+	template.SignatureAlgorithm = x509.SM2WithSM3
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.Cert, parent.Key
+	}
+	der, err := x509.CreateCertificate(template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Cert{Cert: cert, Key: key}
+}
+
+// CATemplate 返回 CA 证书模板, pathLen 小于 0 表示不限制路径长度
+func CATemplate(cn string, pathLen int) *x509.Certificate {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
+	}
+	if pathLen < 0 {
+		template.MaxPathLen = -1
+	}
+	return template
+}
+
+// WriteAuthority 将签发机构的证书, 未加密的私钥以及证书链写入配置目录, name 为空表示根 CA.
+// 证书链为 c 以及 chain 中的证书
+func WriteAuthority(t testing.TB, configDir string, name string, c *Cert, chain ...*Cert) {
+	t.Helper()
+	dir := authority.Dir(configDir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := keyfile.Marshal(c.Key, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = keyfile.WriteFile(filepath.Join(dir, authority.KeyFile), keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, authority.CertFile), c.PEM(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if name == "" {
+		return
+	}
+	chainPEM := c.PEM()
+	for _, v := range chain {
+		chainPEM = append(chainPEM, v.PEM()...)
+	}
+	if err = os.WriteFile(filepath.Join(dir, authority.ChainFile), chainPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
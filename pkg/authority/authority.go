@@ -0,0 +1,206 @@
+package authority
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/signer"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	签发机构, 可以是根 CA, 也可以是由根 CA 签发的中间 CA.
+
+	根 CA 的文件保存在配置目录下:
+	ca.key, ca.cert
+
+	中间 CA 的文件保存在配置目录的 intermediates/<name> 目录下:
+	ca.key, ca.cert 以及 chain.cert (从该中间 CA 到根 CA 的完整证书链)
+
+	使用中间 CA 签发证书时不需要读取根 CA 的私钥, 根 CA 的私钥可以离线保存.
+	私钥也可以不在配置目录中, 由配置文件中的 [signer] 选择后端, 参考 pkg/signer.
+*/
+
+const (
+	CertFile  = "ca.cert"
+	KeyFile   = "ca.key"
+	ChainFile = "chain.cert"
+)
+
+// Authority 表示一个可以签发证书的机构
+type Authority struct {
+	// Name 为空表示根 CA
+	Name    string
+	Cert    *x509.Certificate
+	CertPEM []byte
+	Key     crypto.Signer
+
+	// Chain 为从该机构到根 CA 的完整证书链, 顺序为 自身 -> ... -> 根 CA
+	Chain []byte
+}
+
+// Dir 返回签发机构文件所在的目录, name 为空表示根 CA
+func Dir(configDir string, name string) string {
+	if name == "" {
+		return configDir
+	}
+	return filepath.Join(configDir, "intermediates", name)
+}
+
+// Load 读取签发机构的证书, 私钥以及证书链, name 为空表示根 CA
+func Load(configDir string, name string) (*Authority, error) {
+	dir := Dir(configDir, name)
+
+	// 读取机构 ca 文件
+	certPEM, err := os.ReadFile(filepath.Join(dir, CertFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read authority %s", DisplayName(name))
+	}
+
+	cert, err := ParseCert(certPEM)
+	if err != nil {
+		return nil, errors.Wrapf(err, "authority %s", DisplayName(name))
+	}
+
+	// 打开机构 ca 私钥, 私钥文件加密时需要 CA 私钥口令
+	c, err := signer.ConfigOf(name)
+	if err != nil {
+		return nil, err
+	}
+	key, err := signer.Open(c, filepath.Join(dir, KeyFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read authority %s", DisplayName(name))
+	}
+	if err = matchKey(cert, key); err != nil {
+		return nil, errors.Wrapf(err, "authority %s", DisplayName(name))
+	}
+
+	chain := certPEM
+	if name != "" {
+		chain, err = os.ReadFile(filepath.Join(dir, ChainFile))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read authority %s", DisplayName(name))
+		}
+	}
+
+	return &Authority{
+		Name:    name,
+		Cert:    cert,
+		CertPEM: certPEM,
+		Key:     key,
+		Chain:   chain,
+	}, nil
+}
+
+// matchKey 检查私钥与证书的公钥是否一致
+func matchKey(cert *x509.Certificate, key crypto.Signer) error {
+	pub, ok := key.Public().(*sm2.PublicKey)
+	if !ok {
+		return errors.New("private key is not sm2")
+	}
+	der, err := x509.MarshalSm2PublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(der, cert.RawSubjectPublicKeyInfo) {
+		return errors.New("private key does not match cert")
+	}
+	return nil
+}
+
+// NewKey 返回新签发机构的私钥, file 后端生成新的私钥, 由 SaveKey 保存,
+// pkcs11 以及 remote 后端使用已有的私钥
+func NewKey(c signer.Config, defaultPath string) (crypto.Signer, *sm2.PublicKey, error) {
+	if c.External() {
+		key, err := signer.Open(c, defaultPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub, ok := key.Public().(*sm2.PublicKey)
+		if !ok {
+			return nil, nil, errors.Errorf("%s signer key is not sm2", c.Type)
+		}
+		return key, pub, nil
+	}
+
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, &key.PublicKey, nil
+}
+
+// SaveKey 在签发机构的证书创建成功后保存 file 后端新生成的私钥, 配置了口令时加密保存
+func SaveKey(c signer.Config, defaultPath string, key crypto.Signer) error {
+	if c.External() {
+		return nil
+	}
+	privateKey, ok := key.(*sm2.PrivateKey)
+	if !ok {
+		return errors.Errorf("not support private key %T", key)
+	}
+	b, err := keyfile.Encode(privateKey, keyfile.CAPassphrase())
+	if err != nil {
+		return err
+	}
+	return keyfile.WriteFile(c.KeyPath(defaultPath), b)
+}
+
+// LoadCert 只读取签发机构的证书, 不需要私钥
+func LoadCert(configDir string, name string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(Dir(configDir, name), CertFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read authority %s", DisplayName(name))
+	}
+	return ParseCert(certPEM)
+}
+
+// List 返回所有签发机构的名称, 第一个为根 CA (空字符串)
+func List(configDir string) ([]string, error) {
+	names := []string{""}
+
+	entries, err := os.ReadDir(filepath.Join(configDir, "intermediates"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return names, nil
+		}
+		return nil, err
+	}
+
+	var intermediates []string
+	for _, v := range entries {
+		if !v.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(configDir, "intermediates", v.Name(), CertFile)); err == nil {
+			intermediates = append(intermediates, v.Name())
+		}
+	}
+	sort.Strings(intermediates)
+	return append(names, intermediates...), nil
+}
+
+// ParseCert 解码 PEM 格式的证书, 只解析第一个证书
+func ParseCert(certPEM []byte) (*x509.Certificate, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("type is not CERTIFICATE")
+	}
+	return x509.ParseCertificate(certBlock.Bytes)
+}
+
+// DisplayName 返回签发机构用于展示的名称
+func DisplayName(name string) string {
+	if name == "" {
+		return "root ca"
+	}
+	return name
+}
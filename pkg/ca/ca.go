@@ -0,0 +1,316 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	证书机构的公共接口, 命令行和 server 共用, 也可以作为库直接使用:
+
+	c, err := ca.NewCA(ca.Options{ConfigDir: "/etc/jcert-gm"})
+	key, err := ca.NewKey(ca.KeyOptions{})
+	csr, err := ca.NewCSR(key, ca.CSROptions{Subject: pkix.Name{CommonName: "node1"}, DNSNames: []string{"node1"}})
+	cert, err := c.IssueFromCSR(ctx, csr, "server", ca.IssueOptions{Issuer: "ops"})
+	b, err := cert.Encode(ca.FormatPEM)
+	reason, err := ca.ParseReason("keyCompromise")
+	rv, err := c.Revoke(ctx, cert.Cert.SerialNumber, ca.RevokeOptions{Reason: reason})
+	ica, err := c.IssueIntermediate(ctx, ca.IntermediateOptions{Name: "ops", Subject: pkix.Name{CommonName: "Ops SM2 CA"}})
+
+	签发机构的私钥在第一次使用时读取并缓存, 签发机构的证书变化 (重新 init 或 intermediate) 后重新读取.
+	签发以及吊销会修改 CA 状态, 同一个 CA 上的调用是串行的.
+*/
+
+var (
+	// ErrRejected csr 不满足证书模板或签发策略的要求
+	ErrRejected = policy.ErrRejected
+	// ErrUnknownProfile 证书模板不存在
+	ErrUnknownProfile = profile.ErrNotFound
+	// ErrNotFound 证书不存在
+	ErrNotFound = store.ErrNotFound
+	// ErrAlreadyRevoked 证书已经被吊销
+	ErrAlreadyRevoked = store.ErrAlreadyRevoked
+	// ErrInvalidIssuer 签发机构名称不合法
+	ErrInvalidIssuer = errors.New("invalid issuer")
+)
+
+// Options 创建 CA 的选项
+type Options struct {
+	// ConfigDir 配置目录, 为空时使用当前配置文件所在的目录
+	ConfigDir string
+}
+
+// CA 配置目录中的根 CA 以及所有中间 CA
+type CA struct {
+	configDir string
+
+	mu          sync.Mutex
+	authorities map[string]*authority.Authority
+}
+
+// NewCA 打开配置目录中的 CA, 不会读取签发机构的私钥
+func NewCA(opts Options) (*CA, error) {
+	dir := opts.ConfigDir
+	if dir == "" {
+		if viper.ConfigFileUsed() == "" {
+			return nil, errors.New("config dir is required")
+		}
+		dir = filepath.Dir(viper.ConfigFileUsed())
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dir)
+	}
+	return &CA{configDir: dir, authorities: map[string]*authority.Authority{}}, nil
+}
+
+// ConfigDir 返回配置目录
+func (c *CA) ConfigDir() string {
+	return c.configDir
+}
+
+// ValidIssuer 签发机构名称会作为目录名, 不允许包含路径
+func ValidIssuer(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// Authority 返回签发机构的证书, 私钥以及证书链, name 为空表示根 CA
+func (c *CA) Authority(name string) (*authority.Authority, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authority(name)
+}
+
+func (c *CA) authority(name string) (*authority.Authority, error) {
+	if !ValidIssuer(name) {
+		return nil, errors.Wrap(ErrInvalidIssuer, name)
+	}
+	certPEM, err := os.ReadFile(filepath.Join(authority.Dir(c.configDir, name), authority.CertFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read authority %s", authority.DisplayName(name))
+	}
+	if a, ok := c.authorities[name]; ok && bytes.Equal(a.CertPEM, certPEM) {
+		return a, nil
+	}
+
+	a, err := authority.Load(c.configDir, name)
+	if err != nil {
+		return nil, err
+	}
+	c.authorities[name] = a
+	return a, nil
+}
+
+// IssueOptions 签发证书的选项
+type IssueOptions struct {
+	// Issuer 签发机构, 为空表示根 CA
+	Issuer string
+	// Requester 记录到证书清单中的申请人
+	Requester string
+}
+
+// Certificate 签发的证书
+type Certificate struct {
+	Cert    *x509.Certificate
+	Issuer  string
+	Profile string
+	// Chain 签发机构的证书链, PEM 格式, 顺序为 签发机构 -> ... -> 根 CA
+	Chain []byte
+}
+
+// IssueFromCSR 使用签发机构以及证书模板根据 csr 签发证书, 并记录到证书清单中.
+// csr 不满足模板或签发策略时返回 ErrRejected, 模板不存在时返回 ErrUnknownProfile.
+func (c *CA) IssueFromCSR(ctx context.Context, csr *x509.CertificateRequest, profileName string, opts IssueOptions) (*Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	issuer, err := c.authority(opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.Open(c.configDir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := issue(issuer, s, csr, profileName, opts.Requester)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.Get(cert.SerialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Cert: cert, Issuer: issuer.Name, Profile: r.Profile, Chain: issuer.Chain}, nil
+}
+
+// issue 使用签发机构根据 csr 签发证书, 并记录到证书清单中
+func issue(issuer *authority.Authority, s *store.Store, csr *x509.CertificateRequest, profileName string, requester string) (*x509.Certificate, error) {
+	// 获取证书模板, 并检查 csr 是否满足模板以及签发策略的要求
+	p, err := profile.Get(profileName)
+	if err != nil {
+		return nil, err
+	}
+	pl, err := policy.Load()
+	if err != nil {
+		return nil, err
+	}
+	notBefore := time.Now()
+	if err = pl.Check(csr, p, notBefore, p.NotAfter(notBefore)); err != nil {
+		return nil, err
+	}
+	pub, err := publicKey(csr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	// 创建证书模板
+	template := &x509.Certificate{
+		Subject: csr.Subject,
+		// 保留 csr 中的原始主题, 包括 pkix.Name 不支持的属性以及属性顺序
+		RawSubject:            csr.RawSubject,
+		NotBefore:             notBefore,
+		SubjectKeyId:          SubjectKeyID(pub),
+		PublicKeyAlgorithm:    csr.PublicKeyAlgorithm,
+		SignatureAlgorithm:    x509.SM2WithSM3,
+		DNSNames:              csr.DNSNames,
+		IPAddresses:           csr.IPAddresses,
+		EmailAddresses:        csr.EmailAddresses,
+		CRLDistributionPoints: viper.GetStringSlice("CRLDistributionPoints"),
+		OCSPServer:            viper.GetStringSlice("OCSPServer"),
+	}
+	if err = p.Apply(template); err != nil {
+		return nil, err
+	}
+	// 证书的有效期不能超过签发者
+	if template.NotAfter.After(issuer.Cert.NotAfter) {
+		template.NotAfter = issuer.Cert.NotAfter
+	}
+	var uris []*url.URL
+	if p.AllowSAN("uri") {
+		uris, _ = san.URIs(csr.Extensions)
+	}
+	if err = checkChain(issuer, template, uris); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	// tjfoc/gmsm 不支持 URI, 包含 URI 时生成完整的 SAN 扩展
+	if len(uris) > 0 {
+		ext, err := san.Names{
+			DNSNames:       template.DNSNames,
+			EmailAddresses: template.EmailAddresses,
+			IPAddresses:    template.IPAddresses,
+			URIs:           uris,
+		}.Extension()
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+
+	// 申请序列号, 签名并记录到证书清单中, 只写入一次状态文件
+	var cert *x509.Certificate
+	err = s.Update(func(tx *store.Store) error {
+		// 随机生成一个, 并保证从未使用过
+		serialNumber, err := tx.NewSerial()
+		if err != nil {
+			return err
+		}
+		template.SerialNumber = serialNumber
+
+		// 使用SM2密钥对签名证书
+		derBytes, err := x509.CreateCertificate(template, issuer.Cert, pub, issuer.Key)
+		if err != nil {
+			return err
+		}
+		if cert, err = x509.ParseCertificate(derBytes); err != nil {
+			return err
+		}
+		return tx.AddCertificate(store.NewRecord(cert, issuer.Name, p.Name, requester))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// checkChain 检查签发者证书链中每个 CA 的名称约束, 签发 CA 证书时还要检查路径长度约束
+func checkChain(issuer *authority.Authority, template *x509.Certificate, uris []*url.URL) error {
+	chain, err := ParseChain(issuer.Chain)
+	if err != nil {
+		return err
+	}
+	if template.IsCA {
+		if err = CheckPathLen(chain); err != nil {
+			return err
+		}
+	}
+
+	names := Names{
+		DNSNames:       template.DNSNames,
+		IPAddresses:    template.IPAddresses,
+		EmailAddresses: template.EmailAddresses,
+		URIs:           uris,
+	}
+	if !template.IsCA {
+		names.CommonName = template.Subject.CommonName
+	}
+	for _, v := range chain {
+		nc, err := ParseNameConstraints(v)
+		if err != nil {
+			return errors.Wrapf(err, "ca %s", v.Subject.CommonName)
+		}
+		if nc == nil {
+			continue
+		}
+		if err = nc.Check(names); err != nil {
+			return errors.Wrapf(err, "ca %s", v.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// SubjectKeyID 根据 RFC 5280 4.2.1.2 的方法一, 对公钥做 sha1 得到 Subject Key Identifier.
+func SubjectKeyID(pub *sm2.PublicKey) []byte {
+	b := sha1.Sum(elliptic.Marshal(pub.Curve, pub.X, pub.Y))
+	return b[:]
+}
+
+// publicKey 返回 csr 中的公钥, 支持 SM2 以及 ECDSA P-256 (用于标准 TLS).
+// tjfoc/gmsm 的 CreateCertificate 只接受 *sm2.PublicKey, 但会根据曲线编码公钥, 因此 P-256 公钥同样使用 sm2.PublicKey 传递.
+func publicKey(csr *x509.CertificateRequest) (*sm2.PublicKey, error) {
+	switch pub := csr.PublicKey.(type) {
+	case *sm2.PublicKey:
+		return pub, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != sm2.P256Sm2() && pub.Curve != elliptic.P256() {
+			return nil, errors.Errorf("not support curve %s", pub.Curve.Params().Name)
+		}
+		return &sm2.PublicKey{Curve: pub.Curve, X: pub.X, Y: pub.Y}, nil
+	}
+	return x509.ParseSm2PublicKey(csr.RawSubjectPublicKeyInfo)
+}
@@ -0,0 +1,199 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/emmansun/gmsm/pkcs7"
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTestCA 在临时目录中创建根 CA, 私钥不加密
+func newTestCA(t *testing.T) *CA {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	template := testcert.CATemplate("test root", -1)
+	template.SerialNumber = big.NewInt(1)
+	testcert.WriteAuthority(t, dir, "", testcert.New(t, template, nil))
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.UseSerial(big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCA(Options{ConfigDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestIssueFromCSR(t *testing.T) {
+	c := newTestCA(t)
+	root, err := c.Authority("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/node1")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		profile string
+		opts    IssueOptions
+		csr     CSROptions
+		policy  map[string]interface{}
+		wantErr error
+	}{
+		{name: "server", profile: "server", opts: IssueOptions{Requester: "admin"}, csr: CSROptions{
+			Subject:     pkix.Name{CommonName: "node1.example.com"},
+			DNSNames:    []string{"node1.example.com"},
+			IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			URIs:        []*url.URL{spiffe},
+		}},
+		{name: "default profile", csr: CSROptions{Subject: pkix.Name{CommonName: "node2"}}},
+		{name: "unknown profile", profile: "unknown", csr: CSROptions{Subject: pkix.Name{CommonName: "node1"}}, wantErr: ErrUnknownProfile},
+		{name: "invalid issuer", opts: IssueOptions{Issuer: "../ops"}, csr: CSROptions{Subject: pkix.Name{CommonName: "node1"}}, wantErr: ErrInvalidIssuer},
+		{name: "missing issuer", opts: IssueOptions{Issuer: "ops"}, csr: CSROptions{Subject: pkix.Name{CommonName: "node1"}}, wantErr: os.ErrNotExist},
+		{name: "profile rejected", profile: "server", csr: CSROptions{DNSNames: []string{"node1.example.com"}}, wantErr: ErrRejected},
+		{name: "policy rejected", csr: CSROptions{Subject: pkix.Name{CommonName: "node1"}}, policy: map[string]interface{}{"requiredSubject": []string{"O"}}, wantErr: ErrRejected},
+		{name: "canceled", ctx: canceled, csr: CSROptions{Subject: pkix.Name{CommonName: "node1"}}, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("policy", tt.policy)
+			defer viper.Set("policy", nil)
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			key, err := NewKey(KeyOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr, err := NewCSR(key, tt.csr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cert, err := c.IssueFromCSR(ctx, csr, tt.profile, tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IssueFromCSR() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err = cert.Cert.CheckSignatureFrom(root.Cert); err != nil {
+				t.Errorf("cert is not signed by root: %v", err)
+			}
+			if cert.Cert.NotAfter.After(root.Cert.NotAfter) {
+				t.Errorf("cert not after %s exceeds root %s", cert.Cert.NotAfter, root.Cert.NotAfter)
+			}
+			if len(cert.Cert.DNSNames) != len(tt.csr.DNSNames) || len(cert.Cert.IPAddresses) != len(tt.csr.IPAddresses) {
+				t.Errorf("sans = %v %v, want %v %v", cert.Cert.DNSNames, cert.Cert.IPAddresses, tt.csr.DNSNames, tt.csr.IPAddresses)
+			}
+			if uris, _ := san.URIs(cert.Cert.Extensions); len(uris) != len(tt.csr.URIs) {
+				t.Errorf("uris = %v, want %v", uris, tt.csr.URIs)
+			}
+
+			s, err := store.Open(c.ConfigDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := s.Get(cert.Cert.SerialNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Profile != cert.Profile || r.Issuer != tt.opts.Issuer || r.Requester != tt.opts.Requester {
+				t.Errorf("record profile = %s, issuer = %s, requester = %s", r.Profile, r.Issuer, r.Requester)
+			}
+		})
+	}
+}
+
+func TestCertificateEncode(t *testing.T) {
+	c := newTestCA(t)
+	key, err := NewKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := NewCSR(key, CSROptions{Subject: pkix.Name{CommonName: "node1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.IssueFromCSR(context.Background(), csr, "", IssueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format  string
+		certs   int
+		wantErr bool
+	}{
+		{format: FormatPEM, certs: 2},
+		{format: FormatDER, certs: 1},
+		{format: FormatPKCS7, certs: 2},
+		{format: "pkcs12", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			b, err := cert.Encode(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var certs []*x509.Certificate
+			switch tt.format {
+			case FormatPEM:
+				certs, err = ParseChain(b)
+			case FormatDER:
+				var v *x509.Certificate
+				v, err = x509.ParseCertificate(b)
+				certs = []*x509.Certificate{v}
+			case FormatPKCS7:
+				var der []byte
+				if der, err = base64.StdEncoding.DecodeString(string(b)); err == nil {
+					var p7 *pkcs7.PKCS7
+					if p7, err = pkcs7.Parse(der); err == nil {
+						for _, v := range p7.Certificates {
+							certs = append(certs, &x509.Certificate{Raw: v.Raw})
+						}
+					}
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != tt.certs {
+				t.Fatalf("certs = %d, want %d", len(certs), tt.certs)
+			}
+			if string(certs[0].Raw) != string(cert.Cert.Raw) {
+				t.Error("first cert is not the issued cert")
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	CA 证书的名称约束 (RFC 5280 4.2.1.10) 以及路径长度约束.
+
+	tjfoc/gmsm 只解析以及校验 permitted 中的 dns, 因此直接解析扩展:
+	1. dns: example.com 匹配自身及子域名, .example.com 只匹配子域名
+	2. ip: 网段 (CIDR)
+	3. email: 完整的邮箱, 域名或者 .域名
+	4. uri: 只匹配 uri 中的主机名, 规则与 dns 相同
+
+	终端证书的 CN 为域名或 IP 时同样检查, 因为 tjfoc/gmsm 在没有 DNS SAN 时使用 CN 校验主机名.
+	包含其他类型的约束时, 标记为 critical 的扩展会拒绝签发.
+*/
+
+var oidExtensionNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+
+// general name 的类型, RFC 5280 4.2.1.6
+const (
+	nameTypeEmail = 1
+	nameTypeDNS   = 2
+	nameTypeURI   = 6
+	nameTypeIP    = 7
+)
+
+// NameConstraints 名称约束
+type NameConstraints struct {
+	Critical bool
+
+	PermittedDNS   []string
+	ExcludedDNS    []string
+	PermittedIP    []*net.IPNet
+	ExcludedIP     []*net.IPNet
+	PermittedEmail []string
+	ExcludedEmail  []string
+	PermittedURI   []string
+	ExcludedURI    []string
+}
+
+// Names 需要满足名称约束的名称
+type Names struct {
+	CommonName     string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+type generalSubtree struct {
+	Base asn1.RawValue
+}
+
+type nameConstraints struct {
+	Permitted []generalSubtree `asn1:"optional,tag:0"`
+	Excluded  []generalSubtree `asn1:"optional,tag:1"`
+}
+
+// ParseNameConstraints 解析 CA 证书的名称约束, 没有名称约束时返回 nil
+func ParseNameConstraints(cert *x509.Certificate) (*NameConstraints, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtensionNameConstraints) {
+			continue
+		}
+		var constraints nameConstraints
+		if rest, err := asn1.Unmarshal(ext.Value, &constraints); err != nil {
+			return nil, errors.Wrap(err, "parse name constraints")
+		} else if len(rest) != 0 {
+			return nil, errors.New("trailing data after name constraints")
+		}
+
+		nc := &NameConstraints{Critical: ext.Critical}
+		var err error
+		if nc.PermittedDNS, nc.PermittedIP, nc.PermittedEmail, nc.PermittedURI, err = parseSubtrees(constraints.Permitted, ext.Critical); err != nil {
+			return nil, err
+		}
+		if nc.ExcludedDNS, nc.ExcludedIP, nc.ExcludedEmail, nc.ExcludedURI, err = parseSubtrees(constraints.Excluded, ext.Critical); err != nil {
+			return nil, err
+		}
+		return nc, nil
+	}
+	return nil, nil
+}
+
+func parseSubtrees(subtrees []generalSubtree, critical bool) (dns []string, ips []*net.IPNet, emails []string, uris []string, err error) {
+	for _, v := range subtrees {
+		if v.Base.Class != asn1.ClassContextSpecific {
+			return nil, nil, nil, nil, errors.New("invalid name constraint")
+		}
+		switch v.Base.Tag {
+		case nameTypeDNS:
+			dns = append(dns, string(v.Base.Bytes))
+		case nameTypeEmail:
+			emails = append(emails, string(v.Base.Bytes))
+		case nameTypeURI:
+			uris = append(uris, string(v.Base.Bytes))
+		case nameTypeIP:
+			n := len(v.Base.Bytes)
+			if n != 2*net.IPv4len && n != 2*net.IPv6len {
+				return nil, nil, nil, nil, errors.Errorf("invalid ip name constraint length %d", n)
+			}
+			ips = append(ips, &net.IPNet{IP: v.Base.Bytes[:n/2], Mask: v.Base.Bytes[n/2:]})
+		default:
+			if critical {
+				return nil, nil, nil, nil, errors.Errorf("not support name constraint type %d", v.Base.Tag)
+			}
+		}
+	}
+	return dns, ips, emails, uris, nil
+}
+
+// String 返回 permitted 以及 excluded 的所有约束
+func (nc *NameConstraints) String() string {
+	join := func(dns []string, ips []*net.IPNet, emails []string, uris []string) string {
+		var s []string
+		s = append(s, dns...)
+		for _, v := range ips {
+			s = append(s, v.String())
+		}
+		s = append(s, emails...)
+		for _, v := range uris {
+			s = append(s, "uri:"+v)
+		}
+		return strings.Join(s, ",")
+	}
+	var s []string
+	if v := join(nc.PermittedDNS, nc.PermittedIP, nc.PermittedEmail, nc.PermittedURI); v != "" {
+		s = append(s, "permitted "+v)
+	}
+	if v := join(nc.ExcludedDNS, nc.ExcludedIP, nc.ExcludedEmail, nc.ExcludedURI); v != "" {
+		s = append(s, "excluded "+v)
+	}
+	return strings.Join(s, "; ")
+}
+
+// Check 检查名称是否满足约束
+func (nc *NameConstraints) Check(names Names) error {
+	dnsNames := names.DNSNames
+	ips := names.IPAddresses
+	if ip := net.ParseIP(names.CommonName); ip != nil {
+		ips = append(append([]net.IP{}, ips...), ip)
+	} else if isDomain(names.CommonName) {
+		dnsNames = append(append([]string{}, dnsNames...), names.CommonName)
+	}
+
+	for _, v := range dnsNames {
+		if err := check("dns", v, nc.PermittedDNS, nc.ExcludedDNS, matchDomain); err != nil {
+			return err
+		}
+	}
+	for _, v := range names.EmailAddresses {
+		if err := check("email", v, nc.PermittedEmail, nc.ExcludedEmail, matchEmail); err != nil {
+			return err
+		}
+	}
+	for _, v := range names.URIs {
+		if err := check("uri", v.String(), nc.PermittedURI, nc.ExcludedURI, matchURI); err != nil {
+			return err
+		}
+	}
+	for _, ip := range ips {
+		if ok, _ := matchIPNets(ip, nc.ExcludedIP); ok {
+			return errors.Errorf("ip %s is excluded by name constraints", ip)
+		}
+		if ok, empty := matchIPNets(ip, nc.PermittedIP); !ok && !empty {
+			return errors.Errorf("ip %s is not permitted by name constraints", ip)
+		}
+	}
+	return nil
+}
+
+// CheckNameConstraints 检查证书的名称是否满足 CA 证书的名称约束
+func CheckNameConstraints(cert *x509.Certificate, caCert *x509.Certificate) error {
+	nc, err := ParseNameConstraints(caCert)
+	if err != nil || nc == nil {
+		return err
+	}
+	uris, err := san.URIs(cert.Extensions)
+	if err != nil {
+		return err
+	}
+	names := Names{
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           uris,
+	}
+	// CA 证书的 CN 是名称而不是主机名
+	if !cert.IsCA {
+		names.CommonName = cert.Subject.CommonName
+	}
+	return nc.Check(names)
+}
+
+// CheckPathLen 检查 chain 中每个 CA 的路径长度约束是否允许其下再签发 CA 证书,
+// chain 的顺序为 签发机构 -> ... -> 根 CA
+func CheckPathLen(chain []*x509.Certificate) error {
+	for i, v := range chain {
+		// 签发后, 第 i 级 CA 下方有 i+1 个中间 CA
+		if (v.MaxPathLen > 0 || v.MaxPathLenZero) && i+1 > v.MaxPathLen {
+			return errors.Errorf("path length of %s is %d, can not issue ca", v.Subject.CommonName, v.MaxPathLen)
+		}
+	}
+	return nil
+}
+
+// ParseChain 解码 PEM 格式的证书链
+func ParseChain(chain []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(bytes.TrimSpace(chain)) != 0 {
+		return nil, errors.New("trailing data after certificate chain")
+	}
+	return certs, nil
+}
+
+func check(typ string, name string, permitted, excluded []string, match func(name, constraint string) bool) error {
+	for _, c := range excluded {
+		if match(name, c) {
+			return errors.Errorf("%s %s is excluded by name constraints %s", typ, name, c)
+		}
+	}
+	if len(permitted) == 0 {
+		return nil
+	}
+	for _, c := range permitted {
+		if match(name, c) {
+			return nil
+		}
+	}
+	return errors.Errorf("%s %s is not permitted by name constraints %s", typ, name, strings.Join(permitted, ","))
+}
+
+func matchIPNets(ip net.IP, nets []*net.IPNet) (ok bool, empty bool) {
+	for _, n := range nets {
+		if len(n.IP) == net.IPv4len && ip.To4() == nil || len(n.IP) == net.IPv6len && ip.To4() != nil {
+			continue
+		}
+		if n.Contains(ip) {
+			return true, false
+		}
+	}
+	return false, len(nets) == 0
+}
+
+// matchDomain 不区分大小写, .example.com 只匹配子域名, example.com 同时匹配自身
+func matchDomain(domain, constraint string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	constraint = strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchEmail 包含 @ 时匹配完整的邮箱, 否则匹配邮箱的域名
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	host := strings.ToLower(email[i+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+// matchURI 匹配 uri 中的主机名, 没有主机名时不匹配
+func matchURI(uri, constraint string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
+
+// isDomain CN 是否为域名, 允许第一级为通配符
+func isDomain(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for i, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if label == "" {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
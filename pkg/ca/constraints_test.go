@@ -0,0 +1,53 @@
+package ca
+
+import (
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestNameConstraintsCheck(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.0.0.0/8")
+	nc := &NameConstraints{
+		PermittedDNS:   []string{"example.com", ".example.org"},
+		ExcludedDNS:    []string{"admin.example.com"},
+		PermittedIP:    []*net.IPNet{ipNet},
+		PermittedEmail: []string{"example.com"},
+		PermittedURI:   []string{".example.com"},
+	}
+	spiffe, _ := url.Parse("spiffe://node.example.com/app")
+	evilURI, _ := url.Parse("spiffe://evil.org/app")
+
+	tests := []struct {
+		name  string
+		names Names
+		ok    bool
+	}{
+		{name: "dns self", names: Names{DNSNames: []string{"example.com"}}, ok: true},
+		{name: "dns subdomain", names: Names{DNSNames: []string{"www.EXAMPLE.com"}}, ok: true},
+		{name: "dns leading dot excludes self", names: Names{DNSNames: []string{"example.org"}}},
+		{name: "dns leading dot subdomain", names: Names{DNSNames: []string{"www.example.org"}}, ok: true},
+		{name: "dns not permitted", names: Names{DNSNames: []string{"evil.org"}}},
+		{name: "dns suffix is not subdomain", names: Names{DNSNames: []string{"badexample.com"}}},
+		{name: "dns excluded", names: Names{DNSNames: []string{"admin.example.com"}}},
+		{name: "cn as dns", names: Names{CommonName: "evil.org"}},
+		{name: "cn without dot", names: Names{CommonName: "node1"}},
+		{name: "cn is not a hostname", names: Names{CommonName: "Ops Team"}, ok: true},
+		{name: "cn as ip", names: Names{CommonName: "192.168.1.1"}},
+		{name: "ip permitted", names: Names{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, ok: true},
+		{name: "ip not permitted", names: Names{IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}}},
+		{name: "ipv6 not permitted", names: Names{IPAddresses: []net.IP{net.ParseIP("::1")}}},
+		{name: "email permitted", names: Names{EmailAddresses: []string{"ops@example.com"}}, ok: true},
+		{name: "email not permitted", names: Names{EmailAddresses: []string{"ops@evil.org"}}},
+		{name: "uri permitted", names: Names{URIs: []*url.URL{spiffe}}, ok: true},
+		{name: "uri not permitted", names: Names{URIs: []*url.URL{evilURI}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := nc.Check(tt.names)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Check() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package ca
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+/*
+	根据 CA 状态中的吊销记录重新生成签发机构的 CRL, CRL Number 单调递增.
+
+	下次更新时间以及输出格式可通过配置文件指定:
+
+	[crl]
+	nextUpdate = "168h"
+	format = "der"
+
+	der 格式保存为 crl.crl, pem 格式保存为 crl.pem, 位于签发机构的目录下, 生成时删除另一种格式的旧文件.
+*/
+
+const (
+	CRLFileDER = "crl.crl"
+	CRLFilePEM = "crl.pem"
+)
+
+var (
+	oidSignatureSM2WithSM3       = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}
+	oidExtensionAuthorityKeyId   = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidExtensionCRLNumber        = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode       = asn1.ObjectIdentifier{2, 5, 29, 21}
+	defaultCRLNextUpdateDuration = 7 * 24 * time.Hour
+)
+
+// RFC 5280 5.3.1 CRLReason
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"caCompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"aaCompromise":         10,
+}
+
+// ParseReason 支持 RFC 5280 中的原因名称或者原因码, 为空时为 unspecified
+func ParseReason(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if code, ok := revocationReasons[s]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.Errorf("not support reason %s", s)
+	}
+	for _, v := range revocationReasons {
+		if v == code {
+			return code, nil
+		}
+	}
+	return 0, errors.Errorf("not support reason code %d", code)
+}
+
+// ReasonName 返回原因码对应的 RFC 5280 名称, 未知的原因码返回数字
+func ReasonName(code int) string {
+	for k, v := range revocationReasons {
+		if v == code {
+			return k
+		}
+	}
+	return strconv.Itoa(code)
+}
+
+// CRLOptions 生成 CRL 的选项
+type CRLOptions struct {
+	// NextUpdate 为 0 时使用配置项 crl.nextUpdate, 默认 168h
+	NextUpdate time.Duration
+	// Format 为空时使用配置项 crl.format, 默认 der
+	Format string
+}
+
+// RevokeOptions 吊销证书的选项
+type RevokeOptions struct {
+	// Issuer 为空时使用证书清单中记录的签发机构, 不为空时需要与记录一致
+	Issuer string
+	// Unrecorded 允许吊销证书清单中没有记录的证书, 此时吊销记录在 Issuer 指定的签发机构下 (为空时为根 CA),
+	// 调用者需要确认证书由该机构签发, 例如检查证书的签名. 为 false 时没有记录的证书返回 store.ErrNotFound
+	Unrecorded bool
+	// Reason RFC 5280 中的原因码, 参考 ParseReason
+	Reason int
+	// Time 为零值时使用当前时间
+	Time time.Time
+	// CRL 吊销后重新生成 CRL 的选项
+	CRL CRLOptions
+}
+
+// Revoke 吊销证书, 记录到 CA 状态中并重新生成签发机构的 CRL.
+// 已经吊销时返回 ErrAlreadyRevoked, 证书清单中没有记录并且未设置 opts.Unrecorded 时返回 store.ErrNotFound.
+func (c *CA) Revoke(ctx context.Context, serial *big.Int, opts RevokeOptions) (*store.Revocation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s, err := store.Open(c.configDir)
+	if err != nil {
+		return nil, err
+	}
+	// 指定的签发机构需要与证书清单中的记录一致
+	issuerName := opts.Issuer
+	r, err := s.Get(serial)
+	switch {
+	case err == nil:
+		if issuerName == "" {
+			issuerName = r.Issuer
+		} else if issuerName != r.Issuer {
+			return nil, errors.Wrapf(ErrInvalidIssuer, "serial %s is issued by %s, not %s",
+				store.SerialHex(serial), authority.DisplayName(r.Issuer), authority.DisplayName(issuerName))
+		}
+	case !errors.Is(err, store.ErrNotFound) || !opts.Unrecorded:
+		// 没有记录时无法确认签发机构, 避免吊销记录到错误的签发机构下
+		return nil, err
+	}
+	if !ValidIssuer(issuerName) {
+		return nil, errors.Wrap(ErrInvalidIssuer, issuerName)
+	}
+
+	revokedAt := opts.Time
+	if revokedAt.IsZero() {
+		revokedAt = time.Now()
+	}
+	if err = s.Revoke(issuerName, serial, opts.Reason, revokedAt); err != nil {
+		return nil, err
+	}
+	if err = c.generateCRL(s, issuerName, opts.CRL); err != nil {
+		return nil, errors.Wrap(err, "certificate revoked, but generate crl failed")
+	}
+	return s.Revocation(issuerName, serial), nil
+}
+
+// GenerateCRL 重新生成签发机构的 CRL 并保存到签发机构的目录下, issuerName 为空表示根 CA
+func (c *CA) GenerateCRL(ctx context.Context, issuerName string, opts CRLOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s, err := store.Open(c.configDir)
+	if err != nil {
+		return err
+	}
+	return c.generateCRL(s, issuerName, opts)
+}
+
+func (c *CA) generateCRL(s *store.Store, issuerName string, opts CRLOptions) error {
+	issuer, err := c.authority(issuerName)
+	if err != nil {
+		return err
+	}
+
+	nextUpdate := opts.NextUpdate
+	if nextUpdate == 0 {
+		nextUpdate = viper.GetDuration("crl.nextUpdate")
+	}
+	if nextUpdate <= 0 {
+		nextUpdate = defaultCRLNextUpdateDuration
+	}
+	format := opts.Format
+	if format == "" {
+		format = viper.GetString("crl.format")
+	}
+	switch format {
+	case "":
+		format = FormatDER
+	case FormatDER, FormatPEM:
+	default:
+		return errors.Errorf("not support crl format %s", format)
+	}
+
+	// 在状态文件的锁内读取吊销记录, 递增 CRL Number 并写入 CRL, 多个进程同时生成时 CRL Number 不会重复,
+	// 写入的 CRL 也总是包含最新的吊销记录. 写入失败时不保存 CRL Number
+	return s.Update(func(tx *store.Store) error {
+		var revokedCerts []pkix.RevokedCertificate
+		for _, v := range tx.Revoked(issuerName) {
+			serial, err := store.ParseSerial(v.Serial)
+			if err != nil {
+				return err
+			}
+			rc := pkix.RevokedCertificate{
+				SerialNumber:   serial,
+				RevocationTime: v.RevokedAt,
+			}
+			// reasonCode 为 unspecified 时不应该出现在 CRL 中
+			if v.Reason != 0 {
+				b, err := asn1.Marshal(asn1.Enumerated(v.Reason))
+				if err != nil {
+					return err
+				}
+				rc.Extensions = append(rc.Extensions, pkix.Extension{Id: oidExtensionReasonCode, Value: b})
+			}
+			revokedCerts = append(revokedCerts, rc)
+		}
+
+		number, err := tx.NextCRLNumber(issuerName)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		crlBytes, err := CreateCRL(issuer, revokedCerts, number, now, now.Add(nextUpdate))
+		if err != nil {
+			return err
+		}
+
+		// 只保留当前格式的 CRL, 避免切换格式后仍然发布旧的 CRL
+		dir := authority.Dir(c.configDir, issuerName)
+		file, stale := CRLFileDER, CRLFilePEM
+		if format == FormatPEM {
+			file, stale = CRLFilePEM, CRLFileDER
+			crlBytes = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlBytes})
+		}
+		if err = os.WriteFile(filepath.Join(dir, file), crlBytes, 0o644); err != nil {
+			return err
+		}
+		if err = os.Remove(filepath.Join(dir, stale)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+type authKeyId struct {
+	Id []byte `asn1:"optional,tag:0"`
+}
+
+// CreateCRL 生成 der 格式的 CRL, 与 tjfoc/gmsm 的 CreateCRL 相比增加了 CRL Number 扩展
+func CreateCRL(issuer *authority.Authority, revokedCerts []pkix.RevokedCertificate, number *big.Int, now, nextUpdate time.Time) ([]byte, error) {
+	var issuerName pkix.RDNSequence
+	if _, err := asn1.Unmarshal(issuer.Cert.RawSubject, &issuerName); err != nil {
+		return nil, err
+	}
+
+	// Force revocation times to UTC per RFC 5280.
+	for i := range revokedCerts {
+		revokedCerts[i].RevocationTime = revokedCerts[i].RevocationTime.UTC()
+	}
+
+	tbsCertList := pkix.TBSCertificateList{
+		Version:             1,
+		Signature:           pkix.AlgorithmIdentifier{Algorithm: oidSignatureSM2WithSM3},
+		Issuer:              issuerName,
+		ThisUpdate:          now.UTC(),
+		NextUpdate:          nextUpdate.UTC(),
+		RevokedCertificates: revokedCerts,
+	}
+
+	if len(issuer.Cert.SubjectKeyId) > 0 {
+		b, err := asn1.Marshal(authKeyId{Id: issuer.Cert.SubjectKeyId})
+		if err != nil {
+			return nil, err
+		}
+		tbsCertList.Extensions = append(tbsCertList.Extensions, pkix.Extension{Id: oidExtensionAuthorityKeyId, Value: b})
+	}
+
+	b, err := asn1.Marshal(number)
+	if err != nil {
+		return nil, err
+	}
+	tbsCertList.Extensions = append(tbsCertList.Extensions, pkix.Extension{Id: oidExtensionCRLNumber, Value: b})
+
+	tbsCertListContents, err := asn1.Marshal(tbsCertList)
+	if err != nil {
+		return nil, err
+	}
+
+	// sm2 签名时会在内部使用 sm3 计算摘要, 直接传入原文
+	signature, err := issuer.Key.Sign(rand.Reader, tbsCertListContents, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbsCertList,
+		SignatureAlgorithm: tbsCertList.Signature,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	})
+}
@@ -0,0 +1,103 @@
+package ca
+
+import (
+	"context"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// readCRL 读取根 CA 的 CRL, 返回 CRL Number 以及吊销的证书数量
+func readCRL(t *testing.T, c *CA, format string) (*big.Int, int) {
+	t.Helper()
+	name := CRLFileDER
+	if format == FormatPEM {
+		name = CRLFilePEM
+	}
+	b, err := os.ReadFile(filepath.Join(c.ConfigDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format == FormatPEM {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			t.Fatal("invalid pem crl")
+		}
+		b = block.Bytes
+	}
+	crl, err := x509.ParseDERCRL(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range crl.TBSCertList.Extensions {
+		if ext.Id.Equal(oidExtensionCRLNumber) {
+			number := new(big.Int)
+			if _, err = asn1.Unmarshal(ext.Value, &number); err != nil {
+				t.Fatal(err)
+			}
+			return number, len(crl.TBSCertList.RevokedCertificates)
+		}
+	}
+	t.Fatal("no crl number")
+	return nil, 0
+}
+
+func TestCRLNumber(t *testing.T) {
+	c := newTestCA(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		revoke  *big.Int
+		format  string
+		number  int64
+		revoked int
+		// recorded 只吊销证书清单中有记录的证书
+		recorded bool
+		err      error
+	}{
+		{name: "first", number: 1},
+		{name: "again", number: 2},
+		{name: "revoke", revoke: big.NewInt(0x10), number: 3, revoked: 1},
+		{name: "revoke twice", revoke: big.NewInt(0x10), number: 3, revoked: 1, err: ErrAlreadyRevoked},
+		{name: "revoke another", revoke: big.NewInt(0x11), number: 4, revoked: 2},
+		{name: "not recorded", revoke: big.NewInt(0x12), recorded: true, number: 4, revoked: 2, err: store.ErrNotFound},
+		{name: "pem", format: FormatPEM, number: 5, revoked: 2},
+		{name: "der", number: 6, revoked: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := CRLOptions{Format: tt.format}
+			var err error
+			if tt.revoke != nil {
+				_, err = c.Revoke(ctx, tt.revoke, RevokeOptions{Reason: 1, Unrecorded: !tt.recorded, CRL: opts})
+			} else {
+				err = c.GenerateCRL(ctx, "", opts)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+
+			// 只保留最新格式的 CRL
+			stale := CRLFilePEM
+			if tt.format == FormatPEM {
+				stale = CRLFileDER
+			}
+			if _, err = os.Stat(filepath.Join(c.ConfigDir(), stale)); !os.IsNotExist(err) {
+				t.Errorf("stale crl %s exists", stale)
+			}
+
+			number, revoked := readCRL(t, c, tt.format)
+			if number.Int64() != tt.number || revoked != tt.revoked {
+				t.Errorf("crl number = %d, revoked = %d, want %d, %d", number, revoked, tt.number, tt.revoked)
+			}
+		})
+	}
+}
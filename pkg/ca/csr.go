@@ -0,0 +1,108 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+
+	stdx509 "crypto/x509"
+
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	生成私钥以及 csr, 算法名称与签发策略中的 keyAlgorithms 一致:
+	1. sm2: 默认, 用于国密证书以及 TLCP
+	2. ecdsa-p256: 用于标准 TLS
+*/
+
+const (
+	KeySM2       = "sm2"
+	KeyECDSAP256 = "ecdsa-p256"
+)
+
+// KeyOptions 生成私钥的选项
+type KeyOptions struct {
+	// Algorithm 为空时使用 sm2
+	Algorithm string
+}
+
+// CSROptions 生成 csr 的选项
+type CSROptions struct {
+	Subject pkix.Name
+	// RawSubject 不为空时代替 Subject, 用于保留原证书主题中的属性顺序以及 pkix.Name 不支持的属性
+	RawSubject     []byte
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+}
+
+// NewKey 生成私钥, sm2 返回 *sm2.PrivateKey, ecdsa-p256 返回 *ecdsa.PrivateKey
+func NewKey(opts KeyOptions) (crypto.Signer, error) {
+	switch opts.Algorithm {
+	case "", KeySM2:
+		return sm2.GenerateKey(rand.Reader)
+	case KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return nil, errors.Errorf("not support key algorithm %s, support sm2 and ecdsa-p256", opts.Algorithm)
+}
+
+// NewCSR 使用私钥签名 csr, 支持 NewKey 生成的 sm2 以及 ecdsa-p256 私钥
+func NewCSR(key crypto.Signer, opts CSROptions) (*x509.CertificateRequest, error) {
+	template := &x509.CertificateRequest{
+		Subject:        opts.Subject,
+		RawSubject:     opts.RawSubject,
+		DNSNames:       opts.DNSNames,
+		IPAddresses:    opts.IPAddresses,
+		EmailAddresses: opts.EmailAddresses,
+	}
+	// tjfoc/gmsm 不支持 URI, 包含 URI 时生成完整的 SAN 扩展
+	if len(opts.URIs) > 0 {
+		ext, err := san.Names{
+			DNSNames:       opts.DNSNames,
+			EmailAddresses: opts.EmailAddresses,
+			IPAddresses:    opts.IPAddresses,
+			URIs:           opts.URIs,
+		}.Extension()
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+
+	var (
+		der []byte
+		err error
+	)
+	switch k := key.(type) {
+	case *sm2.PrivateKey:
+		template.SignatureAlgorithm = x509.SM2WithSM3
+		template.PublicKeyAlgorithm = x509.PublicKeyAlgorithm(x509.SM2WithSM3)
+		der, err = x509.CreateCertificateRequest(rand.Reader, template, k)
+	case *ecdsa.PrivateKey:
+		// tjfoc/gmsm 无法使用 ECDSA 私钥签名 csr
+		der, err = stdx509.CreateCertificateRequest(rand.Reader, &stdx509.CertificateRequest{
+			Subject:         template.Subject,
+			RawSubject:      template.RawSubject,
+			DNSNames:        template.DNSNames,
+			IPAddresses:     template.IPAddresses,
+			EmailAddresses:  template.EmailAddresses,
+			ExtraExtensions: template.ExtraExtensions,
+		}, k)
+	default:
+		return nil, errors.Errorf("not support private key %T", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(der)
+}
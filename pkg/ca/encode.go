@@ -0,0 +1,60 @@
+package ca
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+
+	"github.com/emmansun/gmsm/pkcs7"
+	"github.com/pkg/errors"
+)
+
+/*
+	签发的证书的输出格式:
+	1. pem: 证书以及签发机构的证书链, 顺序为 证书 -> 中间 CA -> 根 CA
+	2. der: 只有证书
+	3. pkcs7: base64 编码的 pkcs7, 包含证书以及证书链, 顺序与 pem 相同
+
+	需要私钥的 pkcs12 参考 pkg/pkcs12.
+*/
+
+const (
+	FormatPEM   = "pem"
+	FormatDER   = "der"
+	FormatPKCS7 = "pkcs7"
+)
+
+// PEM 返回 PEM 格式的证书, 不包含证书链
+func (c *Certificate) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// Encode 将证书以及证书链编码为 pem, der 或 pkcs7
+func (c *Certificate) Encode(format string) ([]byte, error) {
+	switch format {
+	case FormatPEM:
+		buffer := &bytes.Buffer{}
+		buffer.Write(c.PEM())
+		buffer.Write(c.Chain)
+		return buffer.Bytes(), nil
+	case FormatDER:
+		return c.Cert.Raw, nil
+	case FormatPKCS7:
+		ders := append([]byte{}, c.Cert.Raw...)
+		b := c.Chain
+		for {
+			block, rest := pem.Decode(b)
+			if block == nil {
+				break
+			}
+			ders = append(ders, block.Bytes...)
+			b = rest
+		}
+		p7b, err := pkcs7.DegenerateCertificate(ders)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(base64.StdEncoding.EncodeToString(p7b)), nil
+	}
+	return nil, errors.Errorf("not support format %s, support pem, der and pkcs7", format)
+}
@@ -0,0 +1,198 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/signer"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	签发中间 CA, 命令行的 intermediate 以及 scope 共用.
+
+	与签发证书相同, 检查模板 (默认为 ca) 以及签发策略, 并且在同一次状态文件的写入中申请序列号以及记录到证书清单,
+	任何一步失败时不会留下没有记录的序列号. 中间 CA 的私钥由本机构生成, 没有 csr, 使用主题以及公钥检查.
+*/
+
+// IntermediateProfile 签发中间 CA 默认使用的模板
+const IntermediateProfile = "ca"
+
+// IntermediateOptions 签发中间 CA 的选项
+type IntermediateOptions struct {
+	// Name 中间 CA 的名称, 同时作为目录名
+	Name string
+	// Issuer 为空表示由根 CA 签发
+	Issuer string
+	// Profile 必须是 CA 模板, 为空时为 IntermediateProfile
+	Profile string
+	Subject pkix.Name
+	// Expiration 有效期, 依次为年, 月, 日, 为空时为 10 年
+	Expiration []int
+	// PathLen 为负数时不限制路径长度
+	PathLen int
+	// KeyUsage 为空时使用模板中的 key usage
+	KeyUsage     []string
+	PermittedDNS []string
+	// Force 覆盖已经存在的中间 CA
+	Force bool
+	// Requester 记录到证书清单中的申请人
+	Requester string
+	// CRL 签发后生成中间 CA 的 CRL 的选项
+	CRL CRLOptions
+}
+
+// IssueIntermediate 签发中间 CA, 私钥, 证书以及证书链保存到中间 CA 的目录下, 记录到证书清单中并生成其 CRL.
+// 不满足模板或签发策略时返回 ErrRejected.
+func (c *CA) IssueIntermediate(ctx context.Context, opts IntermediateOptions) (*Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Name == "" || !ValidIssuer(opts.Name) {
+		return nil, errors.Wrap(ErrInvalidIssuer, opts.Name)
+	}
+	dir := authority.Dir(c.configDir, opts.Name)
+	if !opts.Force {
+		if _, err := os.Stat(filepath.Join(dir, authority.CertFile)); err == nil {
+			return nil, errors.Errorf("intermediate ca %s already exists, use --force to overwrite it", opts.Name)
+		}
+	}
+
+	parent, err := c.authority(opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	profileName := opts.Profile
+	if profileName == "" {
+		profileName = IntermediateProfile
+	}
+	p, err := profile.Get(profileName)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsCA {
+		return nil, errors.Errorf("profile %s is not a ca profile", p.Name)
+	}
+	pl, err := policy.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	sc, err := signer.ConfigOf(opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(dir, authority.KeyFile)
+	key, pub, err := authority.NewKey(sc, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		Subject:               opts.Subject,
+		NotBefore:             time.Now(),
+		SubjectKeyId:          SubjectKeyID(pub),
+		SignatureAlgorithm:    x509.SM2WithSM3,
+		CRLDistributionPoints: viper.GetStringSlice("CRLDistributionPoints"),
+		OCSPServer:            viper.GetStringSlice("OCSPServer"),
+	}
+	if err = p.Apply(template); err != nil {
+		return nil, err
+	}
+	if len(opts.KeyUsage) > 0 {
+		if template.KeyUsage, err = profile.ParseKeyUsage(opts.KeyUsage); err != nil {
+			return nil, err
+		}
+	}
+	template.MaxPathLen = opts.PathLen
+	template.MaxPathLenZero = opts.PathLen == 0
+	if opts.PathLen < 0 {
+		template.MaxPathLen = -1
+	}
+	if len(opts.PermittedDNS) > 0 {
+		template.PermittedDNSDomains = opts.PermittedDNS
+		template.PermittedDNSDomainsCritical = true
+	}
+
+	year, month, day := 10, 0, 0
+	if len(opts.Expiration) == 3 {
+		year, month, day = opts.Expiration[0], opts.Expiration[1], opts.Expiration[2]
+	}
+	template.NotAfter = template.NotBefore.AddDate(year, month, day)
+	if err = pl.CheckUnsigned(&x509.CertificateRequest{Subject: opts.Subject, PublicKey: pub}, p, template.NotBefore, template.NotAfter); err != nil {
+		return nil, err
+	}
+	// 中间 CA 的有效期不能超过签发者
+	if template.NotAfter.After(parent.Cert.NotAfter) {
+		template.NotAfter = parent.Cert.NotAfter
+	}
+	// 签发者的路径长度约束需要允许其下再有一级 CA
+	if err = checkChain(parent, template, nil); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	s, err := store.Open(c.configDir)
+	if err != nil {
+		return nil, err
+	}
+	// 申请序列号, 签名, 记录到证书清单并保存文件, 只写入一次状态文件
+	var cert *x509.Certificate
+	err = s.Update(func(tx *store.Store) error {
+		serialNumber, err := tx.NewSerial()
+		if err != nil {
+			return err
+		}
+		template.SerialNumber = serialNumber
+
+		derBytes, err := x509.CreateCertificate(template, parent.Cert, pub, parent.Key)
+		if err != nil {
+			return err
+		}
+		if cert, err = x509.ParseCertificate(derBytes); err != nil {
+			return err
+		}
+		if err = tx.AddCertificate(store.NewRecord(cert, parent.Name, p.Name, opts.Requester)); err != nil {
+			return err
+		}
+		// 重新签发后, 之前的吊销记录不再有效
+		if err = tx.Reset(opts.Name); err != nil {
+			return err
+		}
+
+		// 证书创建成功后再保存私钥以及证书, 中间 CA 的私钥与根 CA 使用相同的口令
+		if err = os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err = authority.SaveKey(sc, keyPath, key); err != nil {
+			return err
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+		if err = os.WriteFile(filepath.Join(dir, authority.CertFile), certPEM, 0o644); err != nil {
+			return err
+		}
+		chain := append(append(bytes.TrimSpace(certPEM), '\n'), parent.Chain...)
+		return os.WriteFile(filepath.Join(dir, authority.ChainFile), chain, 0o644)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = c.generateCRL(s, opts.Name, opts.CRL); err != nil {
+		return nil, errors.Wrap(err, "intermediate ca issued, but generate crl failed")
+	}
+	return &Certificate{Cert: cert, Issuer: parent.Name, Profile: p.Name, Chain: parent.Chain}, nil
+}
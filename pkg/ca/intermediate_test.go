@@ -0,0 +1,81 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/spf13/viper"
+)
+
+func TestIssueIntermediate(t *testing.T) {
+	c := newTestCA(t)
+	ctx := context.Background()
+	ops := IntermediateOptions{Name: "ops", Subject: pkix.Name{CommonName: "Ops SM2 CA"}, Requester: "admin"}
+	if _, err := c.IssueIntermediate(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    IntermediateOptions
+		policy  map[string]interface{}
+		wantErr error
+	}{
+		{name: "sub ca", opts: IntermediateOptions{Name: "ops-sub", Issuer: "ops", PathLen: -1, Subject: pkix.Name{CommonName: "Ops Sub CA"}}, wantErr: ErrRejected},
+		{name: "policy rejected", opts: IntermediateOptions{Name: "dev", Subject: pkix.Name{CommonName: "Dev SM2 CA"}}, policy: map[string]interface{}{"requiredSubject": []string{"O"}}, wantErr: ErrRejected},
+		{name: "profile rejected", opts: IntermediateOptions{Name: "dev"}, wantErr: ErrRejected},
+		{name: "not ca profile", opts: IntermediateOptions{Name: "dev", Profile: "server", Subject: pkix.Name{CommonName: "Dev SM2 CA"}}, wantErr: errors.New("profile server is not a ca profile")},
+		{name: "invalid name", opts: IntermediateOptions{Name: "../dev", Subject: pkix.Name{CommonName: "Dev SM2 CA"}}, wantErr: ErrInvalidIssuer},
+		{name: "exists", opts: ops, wantErr: errors.New("intermediate ca ops already exists, use --force to overwrite it")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("policy", tt.policy)
+			defer viper.Set("policy", nil)
+
+			_, err := c.IssueIntermediate(ctx, tt.opts)
+			if err == nil || (!errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error()) {
+				t.Fatalf("IssueIntermediate() error = %v, want %v", err, tt.wantErr)
+			}
+			// 失败时不保存任何文件以及证书记录
+			if tt.opts.Name != ops.Name {
+				if _, err = os.Stat(authority.Dir(c.ConfigDir(), tt.opts.Name)); !os.IsNotExist(err) {
+					t.Errorf("intermediate dir of %s exists", tt.opts.Name)
+				}
+			}
+		})
+	}
+
+	s, err := store.Open(c.ConfigDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := s.Search(store.Query{})
+	if len(records) != 1 || records[0].Profile != IntermediateProfile || records[0].Requester != "admin" {
+		t.Fatalf("records = %+v, want only ops with profile %s", records, IntermediateProfile)
+	}
+
+	a, err := c.Authority("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ParseChain(a.Chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[1].Subject.CommonName != "test root" {
+		t.Errorf("chain of ops = %d certs, want ops -> test root", len(chain))
+	}
+	if !a.Cert.IsCA || a.Cert.MaxPathLen != 0 || !a.Cert.MaxPathLenZero {
+		t.Errorf("ops is ca = %v, path len = %d, want ca with path len 0", a.Cert.IsCA, a.Cert.MaxPathLen)
+	}
+	if _, err = os.Stat(filepath.Join(authority.Dir(c.ConfigDir(), "ops"), CRLFileDER)); err != nil {
+		t.Errorf("crl of ops: %v", err)
+	}
+}
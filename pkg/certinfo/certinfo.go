@@ -0,0 +1,397 @@
+package certinfo
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/emmansun/gmsm/pkcs7"
+	ssm2 "github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	证书, csr, CRL, PKCS#7 以及密钥的结构化描述, 用于 parse 命令输出 text, json 或 yaml.
+	私钥只描述算法, 曲线以及公钥, 不会输出私钥本身.
+*/
+
+const (
+	TypeCertificate        = "certificate"
+	TypeCertificateRequest = "certificate request"
+	TypeCRL                = "crl"
+	TypePKCS7              = "pkcs7"
+	TypePublicKey          = "public key"
+	TypePrivateKey         = "private key"
+)
+
+// Name 主题或签发者
+type Name struct {
+	DN                 string   `json:"dn" yaml:"dn"`
+	CommonName         string   `json:"commonName,omitempty" yaml:"commonName,omitempty"`
+	Organization       []string `json:"organization,omitempty" yaml:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty" yaml:"organizationalUnit,omitempty"`
+	Country            []string `json:"country,omitempty" yaml:"country,omitempty"`
+	Province           []string `json:"province,omitempty" yaml:"province,omitempty"`
+	Locality           []string `json:"locality,omitempty" yaml:"locality,omitempty"`
+}
+
+// Validity 有效期
+type Validity struct {
+	NotBefore time.Time `json:"notBefore" yaml:"notBefore"`
+	NotAfter  time.Time `json:"notAfter" yaml:"notAfter"`
+	// DaysRemaining 距离过期的天数, 已过期时为负数
+	DaysRemaining int `json:"daysRemaining" yaml:"daysRemaining"`
+	// Status valid, expired 或 not yet valid
+	Status string `json:"status" yaml:"status"`
+}
+
+// SANs subject alternative name
+type SANs struct {
+	DNS   []string `json:"dns,omitempty" yaml:"dns,omitempty"`
+	IP    []string `json:"ip,omitempty" yaml:"ip,omitempty"`
+	Email []string `json:"email,omitempty" yaml:"email,omitempty"`
+	URI   []string `json:"uri,omitempty" yaml:"uri,omitempty"`
+}
+
+// BasicConstraints 基本约束, MaxPathLen 为 -1 表示不限制
+type BasicConstraints struct {
+	IsCA       bool `json:"isCA" yaml:"isCA"`
+	MaxPathLen int  `json:"maxPathLen" yaml:"maxPathLen"`
+}
+
+// Fingerprints der 编码的摘要, 十六进制
+type Fingerprints struct {
+	SM3    string `json:"sm3" yaml:"sm3"`
+	SHA256 string `json:"sha256" yaml:"sha256"`
+}
+
+// Certificate 证书
+type Certificate struct {
+	Type               string            `json:"type" yaml:"type"`
+	Version            int               `json:"version" yaml:"version"`
+	Serial             string            `json:"serial" yaml:"serial"`
+	Subject            Name              `json:"subject" yaml:"subject"`
+	Issuer             Name              `json:"issuer" yaml:"issuer"`
+	Validity           Validity          `json:"validity" yaml:"validity"`
+	SignatureAlgorithm string            `json:"signatureAlgorithm" yaml:"signatureAlgorithm"`
+	PublicKeyAlgorithm string            `json:"publicKeyAlgorithm" yaml:"publicKeyAlgorithm"`
+	KeyUsage           []string          `json:"keyUsage,omitempty" yaml:"keyUsage,omitempty"`
+	ExtKeyUsage        []string          `json:"extKeyUsage,omitempty" yaml:"extKeyUsage,omitempty"`
+	SANs               SANs              `json:"sans" yaml:"sans"`
+	BasicConstraints   *BasicConstraints `json:"basicConstraints,omitempty" yaml:"basicConstraints,omitempty"`
+	SubjectKeyID       string            `json:"subjectKeyId,omitempty" yaml:"subjectKeyId,omitempty"`
+	AuthorityKeyID     string            `json:"authorityKeyId,omitempty" yaml:"authorityKeyId,omitempty"`
+	CRLDistribution    []string          `json:"crlDistributionPoints,omitempty" yaml:"crlDistributionPoints,omitempty"`
+	OCSPServers        []string          `json:"ocspServers,omitempty" yaml:"ocspServers,omitempty"`
+	IssuingCertURLs    []string          `json:"issuingCertificateURLs,omitempty" yaml:"issuingCertificateURLs,omitempty"`
+	Fingerprints       Fingerprints      `json:"fingerprints" yaml:"fingerprints"`
+	// PublicKeyFingerprints 公钥 SubjectPublicKeyInfo 的摘要, 用于与私钥比对
+	PublicKeyFingerprints Fingerprints `json:"publicKeyFingerprints" yaml:"publicKeyFingerprints"`
+}
+
+// CertificateRequest csr
+type CertificateRequest struct {
+	Type               string       `json:"type" yaml:"type"`
+	Subject            Name         `json:"subject" yaml:"subject"`
+	SignatureAlgorithm string       `json:"signatureAlgorithm" yaml:"signatureAlgorithm"`
+	PublicKeyAlgorithm string       `json:"publicKeyAlgorithm" yaml:"publicKeyAlgorithm"`
+	SANs               SANs         `json:"sans" yaml:"sans"`
+	SignatureValid     bool         `json:"signatureValid" yaml:"signatureValid"`
+	Fingerprints       Fingerprints `json:"fingerprints" yaml:"fingerprints"`
+	// PublicKeyFingerprints 公钥 SubjectPublicKeyInfo 的摘要, 用于与私钥比对
+	PublicKeyFingerprints Fingerprints `json:"publicKeyFingerprints" yaml:"publicKeyFingerprints"`
+}
+
+// RevokedCertificate CRL 中吊销的证书
+type RevokedCertificate struct {
+	Serial         string    `json:"serial" yaml:"serial"`
+	RevocationTime time.Time `json:"revocationTime" yaml:"revocationTime"`
+	Reason         string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// CRL 证书吊销列表
+type CRL struct {
+	Type               string               `json:"type" yaml:"type"`
+	Issuer             Name                 `json:"issuer" yaml:"issuer"`
+	Number             string               `json:"number,omitempty" yaml:"number,omitempty"`
+	ThisUpdate         time.Time            `json:"thisUpdate" yaml:"thisUpdate"`
+	NextUpdate         time.Time            `json:"nextUpdate,omitempty" yaml:"nextUpdate,omitempty"`
+	SignatureAlgorithm string               `json:"signatureAlgorithm" yaml:"signatureAlgorithm"`
+	AuthorityKeyID     string               `json:"authorityKeyId,omitempty" yaml:"authorityKeyId,omitempty"`
+	Revoked            []RevokedCertificate `json:"revoked" yaml:"revoked"`
+	Fingerprints       Fingerprints         `json:"fingerprints" yaml:"fingerprints"`
+}
+
+// PKCS7 PKCS#7 证书包
+type PKCS7 struct {
+	Type         string         `json:"type" yaml:"type"`
+	Certificates []*Certificate `json:"certificates" yaml:"certificates"`
+	CRLs         []*CRL         `json:"crls,omitempty" yaml:"crls,omitempty"`
+}
+
+// Key 公钥或私钥
+type Key struct {
+	Type string `json:"type" yaml:"type"`
+	// Format PKIX, PKCS#8 或 SEC1
+	Format    string `json:"format" yaml:"format"`
+	Encrypted bool   `json:"encrypted" yaml:"encrypted"`
+	// 以下字段在私钥加密时为空
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	Curve     string `json:"curve,omitempty" yaml:"curve,omitempty"`
+	// PublicKey 椭圆曲线公钥为非压缩格式的点, 十六进制
+	PublicKey string `json:"publicKey,omitempty" yaml:"publicKey,omitempty"`
+	// Fingerprints 公钥 SubjectPublicKeyInfo 的摘要, 与证书的公钥相同时摘要相同
+	Fingerprints *Fingerprints `json:"fingerprints,omitempty" yaml:"fingerprints,omitempty"`
+}
+
+// NewCertificate 描述证书, now 用于计算剩余天数
+func NewCertificate(cert *x509.Certificate, now time.Time) *Certificate {
+	c := &Certificate{
+		Type:               TypeCertificate,
+		Version:            cert.Version,
+		Serial:             store.SerialHex(cert.SerialNumber),
+		Subject:            NewName(cert.Subject),
+		Issuer:             NewName(cert.Issuer),
+		Validity:           NewValidity(cert.NotBefore, cert.NotAfter, now),
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: policy.KeyAlgorithm(cert.PublicKey),
+		KeyUsage:           profile.KeyUsageNames(cert.KeyUsage),
+		ExtKeyUsage:        profile.ExtKeyUsageNames(cert.ExtKeyUsage, cert.UnknownExtKeyUsage),
+		SANs:               newSANs(cert.DNSNames, cert.IPAddresses, cert.EmailAddresses, cert.Extensions),
+		SubjectKeyID:       hex.EncodeToString(cert.SubjectKeyId),
+		AuthorityKeyID:     hex.EncodeToString(cert.AuthorityKeyId),
+		CRLDistribution:    cert.CRLDistributionPoints,
+		OCSPServers:        cert.OCSPServer,
+		IssuingCertURLs:    cert.IssuingCertificateURL,
+		Fingerprints:       NewFingerprints(cert.Raw),
+
+		PublicKeyFingerprints: NewFingerprints(cert.RawSubjectPublicKeyInfo),
+	}
+	if len(c.ExtKeyUsage) == 0 {
+		c.ExtKeyUsage = nil
+	}
+	if cert.BasicConstraintsValid {
+		c.BasicConstraints = &BasicConstraints{IsCA: cert.IsCA, MaxPathLen: cert.MaxPathLen}
+		if cert.MaxPathLen == 0 && !cert.MaxPathLenZero {
+			c.BasicConstraints.MaxPathLen = -1
+		}
+	}
+	return c
+}
+
+// NewCertificateRequest 描述 csr
+func NewCertificateRequest(csr *x509.CertificateRequest) *CertificateRequest {
+	return &CertificateRequest{
+		Type:               TypeCertificateRequest,
+		Subject:            NewName(csr.Subject),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: policy.KeyAlgorithm(csr.PublicKey),
+		SANs:               newSANs(csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.Extensions),
+		SignatureValid:     csr.CheckSignature() == nil,
+		Fingerprints:       NewFingerprints(csr.Raw),
+
+		PublicKeyFingerprints: NewFingerprints(csr.RawSubjectPublicKeyInfo),
+	}
+}
+
+// NewCRL 描述 der 编码的 CRL
+func NewCRL(crl *pkix.CertificateList, der []byte) *CRL {
+	tbs := crl.TBSCertList
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&tbs.Issuer)
+	c := &CRL{
+		Type:               TypeCRL,
+		Issuer:             NewName(issuer),
+		ThisUpdate:         tbs.ThisUpdate,
+		NextUpdate:         tbs.NextUpdate,
+		SignatureAlgorithm: signatureAlgorithm(crl.SignatureAlgorithm.Algorithm),
+		Revoked:            []RevokedCertificate{},
+		Fingerprints:       NewFingerprints(der),
+	}
+	for _, ext := range tbs.Extensions {
+		switch {
+		case ext.Id.Equal(oidExtensionCRLNumber):
+			number := new(big.Int)
+			if _, err := asn1.Unmarshal(ext.Value, &number); err == nil {
+				c.Number = number.String()
+			}
+		case ext.Id.Equal(oidExtensionAuthorityKeyId):
+			var aki authKeyId
+			if _, err := asn1.Unmarshal(ext.Value, &aki); err == nil {
+				c.AuthorityKeyID = hex.EncodeToString(aki.Id)
+			}
+		}
+	}
+	for _, v := range tbs.RevokedCertificates {
+		rc := RevokedCertificate{Serial: store.SerialHex(v.SerialNumber), RevocationTime: v.RevocationTime}
+		for _, ext := range v.Extensions {
+			var reason asn1.Enumerated
+			if ext.Id.Equal(oidExtensionReasonCode) {
+				if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil {
+					rc.Reason = ca.ReasonName(int(reason))
+				}
+			}
+		}
+		c.Revoked = append(c.Revoked, rc)
+	}
+	return c
+}
+
+// NewPKCS7 描述 PKCS#7 中的证书以及 CRL
+func NewPKCS7(p7 *pkcs7.PKCS7, now time.Time) (*PKCS7, error) {
+	p := &PKCS7{Type: TypePKCS7, Certificates: []*Certificate{}}
+	for _, v := range p7.Certificates {
+		cert, err := x509.ParseCertificate(v.Raw)
+		if err != nil {
+			return nil, err
+		}
+		p.Certificates = append(p.Certificates, NewCertificate(cert, now))
+	}
+	for i := range p7.CRLs {
+		der, err := asn1.Marshal(p7.CRLs[i])
+		if err != nil {
+			return nil, err
+		}
+		p.CRLs = append(p.CRLs, NewCRL(&p7.CRLs[i], der))
+	}
+	return p, nil
+}
+
+// NewPublicKey 描述公钥, pub 为 smx509 解析的公钥
+func NewPublicKey(format string, pub interface{}) *Key {
+	k := &Key{
+		Type:      TypePublicKey,
+		Format:    format,
+		Algorithm: policy.KeyAlgorithm(pub),
+	}
+	if ec, ok := pub.(*ecdsa.PublicKey); ok {
+		if ec.Curve == ssm2.P256() {
+			k.Algorithm = "sm2"
+		}
+		k.Curve = ec.Curve.Params().Name
+		k.PublicKey = hex.EncodeToString(marshalPoint(ec))
+	}
+	if der, err := smx509.MarshalPKIXPublicKey(pub); err == nil {
+		f := NewFingerprints(der)
+		k.Fingerprints = &f
+	}
+	return k
+}
+
+// NewPrivateKey 描述私钥, 只包含公钥部分
+func NewPrivateKey(format string, pub interface{}) *Key {
+	k := NewPublicKey(format, pub)
+	k.Type = TypePrivateKey
+	return k
+}
+
+// NewEncryptedPrivateKey 描述加密的 PKCS#8 私钥, 没有口令时无法得到公钥
+func NewEncryptedPrivateKey() *Key {
+	return &Key{Type: TypePrivateKey, Format: FormatPKCS8, Encrypted: true}
+}
+
+// NewName 描述主题或签发者
+func NewName(name pkix.Name) Name {
+	return Name{
+		DN:                 name.String(),
+		CommonName:         name.CommonName,
+		Organization:       name.Organization,
+		OrganizationalUnit: name.OrganizationalUnit,
+		Country:            name.Country,
+		Province:           name.Province,
+		Locality:           name.Locality,
+	}
+}
+
+// NewValidity 计算有效期状态以及剩余天数
+func NewValidity(notBefore, notAfter, now time.Time) Validity {
+	v := Validity{
+		NotBefore:     notBefore,
+		NotAfter:      notAfter,
+		DaysRemaining: int(math.Floor(notAfter.Sub(now).Hours() / 24)),
+		Status:        "valid",
+	}
+	switch {
+	case now.Before(notBefore):
+		v.Status = "not yet valid"
+	case now.After(notAfter):
+		v.Status = "expired"
+	}
+	return v
+}
+
+// NewFingerprints 计算 der 编码的 SM3 以及 SHA-256 摘要
+func NewFingerprints(der []byte) Fingerprints {
+	sum := sha256.Sum256(der)
+	return Fingerprints{
+		SM3:    hex.EncodeToString(sm3.Sm3Sum(der)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// marshalPoint 将椭圆曲线公钥编码为非压缩格式的点
+func marshalPoint(pub *ecdsa.PublicKey) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	b := make([]byte, 1+2*size)
+	b[0] = 4
+	pub.X.FillBytes(b[1 : 1+size])
+	pub.Y.FillBytes(b[1+size:])
+	return b
+}
+
+// signatureAlgorithm 返回签名算法 OID 的名称, 与证书的签名算法名称一致
+func signatureAlgorithm(oid asn1.ObjectIdentifier) string {
+	for _, v := range signatureAlgorithms {
+		if v.oid.Equal(oid) {
+			return v.algorithm.String()
+		}
+	}
+	return oid.String()
+}
+
+func newSANs(dns []string, ips []net.IP, emails []string, extensions []pkix.Extension) SANs {
+	s := SANs{DNS: dns, Email: emails}
+	for _, v := range ips {
+		s.IP = append(s.IP, v.String())
+	}
+	// tjfoc/gmsm 不解析 URI
+	uris, _ := san.URIs(extensions)
+	for _, v := range uris {
+		s.URI = append(s.URI, v.String())
+	}
+	return s
+}
+
+type authKeyId struct {
+	Id []byte `asn1:"optional,tag:0"`
+}
+
+var (
+	oidExtensionAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidExtensionCRLNumber      = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidExtensionReasonCode     = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+var signatureAlgorithms = []struct {
+	algorithm x509.SignatureAlgorithm
+	oid       asn1.ObjectIdentifier
+}{
+	{x509.SM2WithSM3, asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}},
+	{x509.ECDSAWithSHA256, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+	{x509.ECDSAWithSHA384, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}},
+	{x509.ECDSAWithSHA512, asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}},
+	{x509.SHA256WithRSA, asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}},
+}
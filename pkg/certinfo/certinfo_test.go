@@ -0,0 +1,144 @@
+package certinfo
+
+import (
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestNewCertificate(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.com/node1")
+	uriExt, err := san.Names{DNSNames: []string{"node1.example.com"}, URIs: []*url.URL{spiffe}}.Extension()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template *x509.Certificate
+		check    func(t *testing.T, c *Certificate)
+	}{
+		{
+			name: "leaf",
+			template: &x509.Certificate{
+				SerialNumber:   big.NewInt(0x1a2b),
+				Subject:        pkix.Name{CommonName: "node1", Organization: []string{"org1"}},
+				KeyUsage:       x509.KeyUsageDigitalSignature,
+				ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+				DNSNames:       []string{"node1.example.com"},
+				IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+				EmailAddresses: []string{"node1@example.com"},
+			},
+			check: func(t *testing.T, c *Certificate) {
+				if c.Serial != "1a2b" || c.Subject.CommonName != "node1" || !reflect.DeepEqual(c.Subject.Organization, []string{"org1"}) {
+					t.Errorf("serial = %s, subject = %+v", c.Serial, c.Subject)
+				}
+				if c.PublicKeyAlgorithm != "sm2" {
+					t.Errorf("public key algorithm = %s, want sm2", c.PublicKeyAlgorithm)
+				}
+				if !reflect.DeepEqual(c.KeyUsage, []string{"digitalSignature"}) || !reflect.DeepEqual(c.ExtKeyUsage, []string{"serverAuth"}) {
+					t.Errorf("key usage = %v, ext key usage = %v", c.KeyUsage, c.ExtKeyUsage)
+				}
+				want := SANs{DNS: []string{"node1.example.com"}, IP: []string{"10.0.0.1"}, Email: []string{"node1@example.com"}}
+				if !reflect.DeepEqual(c.SANs, want) {
+					t.Errorf("sans = %+v, want %+v", c.SANs, want)
+				}
+				if c.BasicConstraints != nil {
+					t.Errorf("basic constraints = %+v, want nil", c.BasicConstraints)
+				}
+			},
+		},
+		{
+			name:     "uri",
+			template: &x509.Certificate{Subject: pkix.Name{CommonName: "node1"}, ExtraExtensions: []pkix.Extension{uriExt}},
+			check: func(t *testing.T, c *Certificate) {
+				if !reflect.DeepEqual(c.SANs.URI, []string{spiffe.String()}) {
+					t.Errorf("uri = %v, want %s", c.SANs.URI, spiffe)
+				}
+			},
+		},
+		{
+			name:     "ca unlimited",
+			template: &x509.Certificate{Subject: pkix.Name{CommonName: "root"}, BasicConstraintsValid: true, IsCA: true, MaxPathLen: -1},
+			check: func(t *testing.T, c *Certificate) {
+				if c.BasicConstraints == nil || !c.BasicConstraints.IsCA || c.BasicConstraints.MaxPathLen != -1 {
+					t.Errorf("basic constraints = %+v, want ca unlimited", c.BasicConstraints)
+				}
+			},
+		},
+		{
+			name:     "ca path len zero",
+			template: &x509.Certificate{Subject: pkix.Name{CommonName: "sub"}, BasicConstraintsValid: true, IsCA: true, MaxPathLenZero: true},
+			check: func(t *testing.T, c *Certificate) {
+				if c.BasicConstraints == nil || c.BasicConstraints.MaxPathLen != 0 {
+					t.Errorf("basic constraints = %+v, want path len 0", c.BasicConstraints)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := testcert.New(t, tt.template, nil).Cert
+			c := NewCertificate(cert, time.Now())
+			if c.Type != TypeCertificate || c.Fingerprints != NewFingerprints(cert.Raw) {
+				t.Errorf("type = %s, fingerprints = %+v", c.Type, c.Fingerprints)
+			}
+			if c.PublicKeyFingerprints != NewFingerprints(cert.RawSubjectPublicKeyInfo) {
+				t.Error("public key fingerprints do not match")
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestNewValidity(t *testing.T) {
+	notBefore := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.AddDate(0, 0, 10)
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		status string
+		days   int
+	}{
+		{name: "not yet valid", now: notBefore.Add(-time.Hour), status: "not yet valid", days: 10},
+		{name: "valid", now: notBefore.Add(36 * time.Hour), status: "valid", days: 8},
+		{name: "expired", now: notAfter.Add(time.Hour), status: "expired", days: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewValidity(notBefore, notAfter, tt.now)
+			if v.Status != tt.status || v.DaysRemaining != tt.days {
+				t.Errorf("status = %s, days = %d, want %s, %d", v.Status, v.DaysRemaining, tt.status, tt.days)
+			}
+		})
+	}
+}
+
+func TestNewCertificateRequest(t *testing.T) {
+	key, err := ca.NewKey(ca.KeyOptions{Algorithm: ca.KeyECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ca.NewCSR(key, ca.CSROptions{Subject: pkix.Name{CommonName: "node1"}, DNSNames: []string{"node1.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCertificateRequest(csr)
+	if c.Subject.CommonName != "node1" || !reflect.DeepEqual(c.SANs.DNS, []string{"node1.example.com"}) {
+		t.Errorf("subject = %+v, sans = %+v", c.Subject, c.SANs)
+	}
+	if c.PublicKeyAlgorithm != "ecdsa-p256" || !c.SignatureValid {
+		t.Errorf("public key algorithm = %s, signature valid = %v", c.PublicKeyAlgorithm, c.SignatureValid)
+	}
+}
@@ -0,0 +1,187 @@
+package certinfo
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/emmansun/gmsm/pkcs7"
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	自动识别输入的格式:
+	1. PEM: 逐个解析 PEM 块, 不支持的类型会被跳过并返回其类型. OpenSSL 输出的 SM2 PRIVATE KEY 按 SEC1 解析
+	2. DER: 依次尝试证书, csr, CRL, PKCS#7, 公钥, PKCS#8 私钥, SEC1 私钥以及加密的 PKCS#8 私钥
+	3. base64 编码的 DER, 如 cert -o pkcs7 输出的证书包
+*/
+
+const (
+	FormatPKIX  = "PKIX"
+	FormatPKCS8 = "PKCS#8"
+	FormatSEC1  = "SEC1"
+)
+
+// Parse 解析 PEM, DER 或 base64 编码的 DER 数据, skipped 为跳过的 PEM 块的类型
+func Parse(data []byte, now time.Time) (items []interface{}, skipped []string, err error) {
+	if block, _ := pem.Decode(data); block != nil {
+		for {
+			block, rest := pem.Decode(data)
+			if block == nil {
+				return items, skipped, nil
+			}
+			data = rest
+
+			item, err := ParseBlock(block, now)
+			if errors.Is(err, ErrUnsupported) {
+				skipped = append(skipped, block.Type)
+				continue
+			}
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "parse %s", block.Type)
+			}
+			items = append(items, item)
+		}
+	}
+
+	item, err := ParseDER(data, now)
+	if err != nil {
+		der, decodeErr := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+		if decodeErr != nil {
+			return nil, nil, err
+		}
+		if item, err = ParseDER(der, now); err != nil {
+			return nil, nil, err
+		}
+	}
+	return []interface{}{item}, nil, nil
+}
+
+// ErrUnsupported 不支持的 PEM 块类型
+var ErrUnsupported = errors.New("unsupported PEM block")
+
+// ParseBlock 根据类型解析 PEM 块, 不支持的类型返回 ErrUnsupported
+func ParseBlock(block *pem.Block, now time.Time) (interface{}, error) {
+	switch block.Type {
+	case "CERTIFICATE":
+		return parseCertificate(block.Bytes, now)
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		return parseCertificateRequest(block.Bytes, now)
+	case "X509 CRL":
+		return parseCRL(block.Bytes, now)
+	case "PKCS7":
+		return parsePKCS7(block.Bytes, now)
+	case "PUBLIC KEY":
+		return parsePublicKey(block.Bytes, now)
+	case "PRIVATE KEY":
+		return parsePKCS8PrivateKey(block.Bytes, now)
+	case "EC PRIVATE KEY", "SM2 PRIVATE KEY":
+		return parseECPrivateKey(block.Bytes, now)
+	case "ENCRYPTED PRIVATE KEY":
+		return parseEncryptedPrivateKey(block.Bytes, now)
+	}
+	return nil, errors.Wrap(ErrUnsupported, block.Type)
+}
+
+// ParseDER 自动识别并解析 der 编码的数据
+func ParseDER(der []byte, now time.Time) (interface{}, error) {
+	for _, parse := range []func([]byte, time.Time) (interface{}, error){
+		parseCertificate,
+		parseCertificateRequest,
+		parseCRL,
+		parsePKCS7,
+		parsePublicKey,
+		parsePKCS8PrivateKey,
+		parseECPrivateKey,
+		parseEncryptedPrivateKey,
+	} {
+		if item, err := parse(der, now); err == nil {
+			return item, nil
+		}
+	}
+	return nil, errors.New("unknown format, support certificate, csr, crl, pkcs7, public key and private key in PEM, DER or base64")
+}
+
+func parseCertificate(der []byte, now time.Time) (interface{}, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewCertificate(cert, now), nil
+}
+
+func parseCertificateRequest(der []byte, _ time.Time) (interface{}, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewCertificateRequest(csr), nil
+}
+
+func parseCRL(der []byte, _ time.Time) (interface{}, error) {
+	crl, err := x509.ParseDERCRL(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewCRL(crl, der), nil
+}
+
+func parsePKCS7(der []byte, now time.Time) (interface{}, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewPKCS7(p7, now)
+}
+
+func parsePublicKey(der []byte, _ time.Time) (interface{}, error) {
+	pub, err := smx509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewPublicKey(FormatPKIX, pub), nil
+}
+
+func parsePKCS8PrivateKey(der []byte, _ time.Time) (interface{}, error) {
+	key, err := smx509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("not support private key %T", key)
+	}
+	return NewPrivateKey(FormatPKCS8, signer.Public()), nil
+}
+
+func parseECPrivateKey(der []byte, _ time.Time) (interface{}, error) {
+	key, err := smx509.ParseTypedECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return NewPrivateKey(FormatSEC1, key.(crypto.Signer).Public()), nil
+}
+
+// encryptedPrivateKeyInfo RFC 5208 EncryptedPrivateKeyInfo
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+func parseEncryptedPrivateKey(der []byte, _ time.Time) (interface{}, error) {
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after encrypted private key")
+	}
+	return NewEncryptedPrivateKey(), nil
+}
@@ -0,0 +1,207 @@
+package certinfo
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emmansun/gmsm/pkcs7"
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestParse(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	now := time.Now()
+	root := testcert.New(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test root"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}, nil)
+	cert, key := root.Cert, root.Key
+
+	csrKey, err := ca.NewKey(ca.KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ca.NewCSR(csrKey, ca.CSROptions{Subject: pkix.Name{CommonName: "node1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reason, err := asn1.Marshal(asn1.Enumerated(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := []pkix.RevokedCertificate{{
+		SerialNumber:   big.NewInt(0x1a2b),
+		RevocationTime: now,
+		Extensions:     []pkix.Extension{{Id: oidExtensionReasonCode, Value: reason}},
+	}}
+	crl, err := ca.CreateCRL(&authority.Authority{Cert: cert, Key: key}, revoked, big.NewInt(7), now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p7b, err := pkcs7.DegenerateCertificate(cert.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalSm2PublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalSm2PrivateKey(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1PEM, err := keyfile.EncodeAs(key, keyfile.FormatSEC1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(keyfile.PassphraseEnv, "key passphrase")
+	encryptedPEM, err := keyfile.EncodeAs(key, keyfile.FormatEncrypted, keyfile.LeafPassphrase())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, _ := pem.Decode(sec1PEM)
+	encrypted, _ := pem.Decode(encryptedPEM)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		check   func(t *testing.T, item interface{})
+		skipped []string
+		wantErr bool
+	}{
+		{name: "certificate pem", data: certPEM, check: checkCertificate(cert)},
+		{name: "certificate der", data: cert.Raw, check: checkCertificate(cert)},
+		{name: "certificate base64", data: []byte(base64.StdEncoding.EncodeToString(cert.Raw)), check: checkCertificate(cert)},
+		{name: "csr pem", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}), check: checkCertificateRequest},
+		{name: "csr der", data: csr.Raw, check: checkCertificateRequest},
+		{name: "crl pem", data: pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl}), check: checkCRL},
+		{name: "crl der", data: crl, check: checkCRL},
+		{name: "pkcs7 pem", data: pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7b}), check: checkPKCS7},
+		{name: "pkcs7 der", data: p7b, check: checkPKCS7},
+		{name: "pkcs7 base64", data: []byte(base64.StdEncoding.EncodeToString(p7b)), check: checkPKCS7},
+		{name: "public key pem", data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), check: checkKey(TypePublicKey, FormatPKIX, cert)},
+		{name: "public key der", data: pub, check: checkKey(TypePublicKey, FormatPKIX, cert)},
+		{name: "pkcs8 pem", data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), check: checkKey(TypePrivateKey, FormatPKCS8, cert)},
+		{name: "pkcs8 der", data: pkcs8, check: checkKey(TypePrivateKey, FormatPKCS8, cert)},
+		{name: "sec1 pem", data: sec1PEM, check: checkKey(TypePrivateKey, FormatSEC1, cert)},
+		{name: "sm2 private key pem", data: pem.EncodeToMemory(&pem.Block{Type: "SM2 PRIVATE KEY", Bytes: sec1.Bytes}), check: checkKey(TypePrivateKey, FormatSEC1, cert)},
+		{name: "sec1 der", data: sec1.Bytes, check: checkKey(TypePrivateKey, FormatSEC1, cert)},
+		{name: "encrypted pem", data: encryptedPEM, check: checkEncryptedKey},
+		{name: "encrypted der", data: encrypted.Bytes, check: checkEncryptedKey},
+		{
+			name:    "unsupported block",
+			data:    append(pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: []byte{1}}), certPEM...),
+			check:   checkCertificate(cert),
+			skipped: []string{"DH PARAMETERS"},
+		},
+		{name: "invalid pem", data: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), wantErr: true},
+		{name: "garbage", data: []byte("garbage"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, skipped, err := Parse(tt.data, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("Parse() skipped = %v, want %v", skipped, tt.skipped)
+			}
+			if len(items) != 1 {
+				t.Fatalf("Parse() items = %d, want 1", len(items))
+			}
+			tt.check(t, items[0])
+		})
+	}
+}
+
+func checkCertificate(want *x509.Certificate) func(t *testing.T, item interface{}) {
+	return func(t *testing.T, item interface{}) {
+		c, ok := item.(*Certificate)
+		if !ok {
+			t.Fatalf("item = %T, want *Certificate", item)
+		}
+		if c.Subject.CommonName != want.Subject.CommonName || c.Fingerprints != NewFingerprints(want.Raw) {
+			t.Errorf("subject = %+v, fingerprints = %+v", c.Subject, c.Fingerprints)
+		}
+	}
+}
+
+func checkCertificateRequest(t *testing.T, item interface{}) {
+	c, ok := item.(*CertificateRequest)
+	if !ok {
+		t.Fatalf("item = %T, want *CertificateRequest", item)
+	}
+	if c.Subject.CommonName != "node1" || !c.SignatureValid {
+		t.Errorf("subject = %+v, signature valid = %v", c.Subject, c.SignatureValid)
+	}
+}
+
+func checkCRL(t *testing.T, item interface{}) {
+	c, ok := item.(*CRL)
+	if !ok {
+		t.Fatalf("item = %T, want *CRL", item)
+	}
+	if c.Issuer.CommonName != "test root" || c.Number != "7" || c.AuthorityKeyID != "01020304" {
+		t.Errorf("issuer = %+v, number = %s, authority key id = %s", c.Issuer, c.Number, c.AuthorityKeyID)
+	}
+	if len(c.Revoked) != 1 || c.Revoked[0].Serial != "1a2b" || c.Revoked[0].Reason != "keyCompromise" {
+		t.Errorf("revoked = %+v", c.Revoked)
+	}
+}
+
+func checkPKCS7(t *testing.T, item interface{}) {
+	p, ok := item.(*PKCS7)
+	if !ok {
+		t.Fatalf("item = %T, want *PKCS7", item)
+	}
+	if len(p.Certificates) != 1 || p.Certificates[0].Subject.CommonName != "test root" {
+		t.Errorf("certificates = %+v", p.Certificates)
+	}
+}
+
+func checkKey(typ, format string, cert *x509.Certificate) func(t *testing.T, item interface{}) {
+	return func(t *testing.T, item interface{}) {
+		k, ok := item.(*Key)
+		if !ok {
+			t.Fatalf("item = %T, want *Key", item)
+		}
+		if k.Type != typ || k.Format != format || k.Encrypted || k.Algorithm != "sm2" {
+			t.Errorf("type = %s, format = %s, encrypted = %v, algorithm = %s", k.Type, k.Format, k.Encrypted, k.Algorithm)
+		}
+		// 与证书的公钥摘要相同
+		if k.Fingerprints == nil || *k.Fingerprints != NewFingerprints(cert.RawSubjectPublicKeyInfo) {
+			t.Errorf("fingerprints = %+v, want fingerprints of cert public key", k.Fingerprints)
+		}
+	}
+}
+
+func checkEncryptedKey(t *testing.T, item interface{}) {
+	k, ok := item.(*Key)
+	if !ok {
+		t.Fatalf("item = %T, want *Key", item)
+	}
+	if k.Type != TypePrivateKey || !k.Encrypted || k.Fingerprints != nil {
+		t.Errorf("key = %+v, want encrypted private key without public key", k)
+	}
+}
@@ -0,0 +1,215 @@
+package keyfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	SM2 私钥以及公钥的格式转换.
+
+	私钥格式:
+	1. pkcs8: PEM 格式的 PKCS#8 (PRIVATE KEY)
+	2. sec1: PEM 格式的 SEC1 (EC PRIVATE KEY)
+	3. encrypted: PEM 格式的加密 PKCS#8 (ENCRYPTED PRIVATE KEY), 算法由 key.cipher 决定
+	4. hex: 32 字节私钥标量的十六进制
+	5. jwk: JSON Web Key, kty 为 EC, crv 为 SM2
+
+	公钥格式:
+	1. pem: PEM 格式的 SubjectPublicKeyInfo (PUBLIC KEY)
+	2. der: der 格式的 SubjectPublicKeyInfo
+	3. hex: 非压缩格式的点 04||X||Y 的十六进制
+	4. jwk: 不包含 d 的 JSON Web Key
+
+	解码时自动识别格式, pkcs8 和 sec1 还支持 der 编码.
+*/
+
+const (
+	FormatPKCS8     = "pkcs8"
+	FormatSEC1      = "sec1"
+	FormatEncrypted = "encrypted"
+	FormatHex       = "hex"
+	FormatJWK       = "jwk"
+	FormatPEM       = "pem"
+	FormatDER       = "der"
+)
+
+// PrivateFormats 支持的私钥格式
+var PrivateFormats = []string{FormatPKCS8, FormatSEC1, FormatEncrypted, FormatHex, FormatJWK}
+
+// PublicFormats 支持的公钥格式
+var PublicFormats = []string{FormatPEM, FormatDER, FormatHex, FormatJWK}
+
+// jwkCurve JWK 中 SM2 曲线的名称
+const jwkCurve = "SM2"
+
+// JWK SM2 密钥的 JSON Web Key 表示, 坐标以及私钥均为 32 字节的 base64url
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	D   string `json:"d,omitempty"`
+}
+
+// Decode 自动识别格式并解码私钥, 返回识别出的格式
+func Decode(data []byte, passphrase *Passphrase) (*sm2.PrivateKey, string, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		format := FormatPKCS8
+		switch block.Type {
+		case "EC PRIVATE KEY", "SM2 PRIVATE KEY":
+			format = FormatSEC1
+		case "ENCRYPTED PRIVATE KEY":
+			format = FormatEncrypted
+		}
+		key, err := Parse(data, passphrase)
+		return key, format, err
+	}
+
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "{") {
+		key, err := parseJWK([]byte(text))
+		return key, FormatJWK, err
+	}
+	if b, err := hex.DecodeString(strings.TrimPrefix(text, "0x")); err == nil && len(b) == 32 {
+		key, err := newPrivateKey(b)
+		return key, FormatHex, err
+	}
+
+	if key, err := x509.ParsePKCS8UnecryptedPrivateKey(data); err == nil {
+		return key, FormatPKCS8, nil
+	}
+	if key, err := smx509.ParseSM2PrivateKey(data); err == nil {
+		return fromEmmansun(key), FormatSEC1, nil
+	}
+	return nil, "", errors.Errorf("unknown private key format, support %s", strings.Join(PrivateFormats, ", "))
+}
+
+// EncodeAs 将私钥编码为指定格式, encrypted 格式使用 passphrase 获取口令
+func EncodeAs(key *sm2.PrivateKey, format string, passphrase *Passphrase) ([]byte, error) {
+	switch format {
+	case FormatPKCS8:
+		return Marshal(key, nil, "")
+	case FormatSEC1:
+		return MarshalSEC1(key)
+	case FormatEncrypted:
+		pwd, err := passphrase.GetNew()
+		if err != nil {
+			return nil, err
+		}
+		return Marshal(key, pwd, viper.GetString("key.cipher"))
+	case FormatHex:
+		return []byte(hex.EncodeToString(fixed(key.D)) + "\n"), nil
+	case FormatJWK:
+		jwk := newJWK(&key.PublicKey)
+		jwk.D = base64.RawURLEncoding.EncodeToString(fixed(key.D))
+		return marshalJWK(jwk)
+	}
+	return nil, errors.Errorf("not support private key format %s, support %s", format, strings.Join(PrivateFormats, ", "))
+}
+
+// EncodePublic 将公钥编码为指定格式
+func EncodePublic(pub *sm2.PublicKey, format string) ([]byte, error) {
+	switch format {
+	case FormatPEM:
+		return x509.WritePublicKeyToPem(pub)
+	case FormatDER:
+		return x509.MarshalSm2PublicKey(pub)
+	case FormatHex:
+		point := append([]byte{4}, fixed(pub.X)...)
+		point = append(point, fixed(pub.Y)...)
+		return []byte(hex.EncodeToString(point) + "\n"), nil
+	case FormatJWK:
+		return marshalJWK(newJWK(pub))
+	}
+	return nil, errors.Errorf("not support public key format %s, support %s", format, strings.Join(PublicFormats, ", "))
+}
+
+// MarshalSEC1 将私钥编码为 PEM 格式的 SEC1 (EC PRIVATE KEY), 不支持加密
+func MarshalSEC1(key *sm2.PrivateKey) ([]byte, error) {
+	der, err := smx509.MarshalSM2PrivateKey(toEmmansun(key))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func newJWK(pub *sm2.PublicKey) *JWK {
+	return &JWK{
+		Kty: "EC",
+		Crv: jwkCurve,
+		X:   base64.RawURLEncoding.EncodeToString(fixed(pub.X)),
+		Y:   base64.RawURLEncoding.EncodeToString(fixed(pub.Y)),
+	}
+}
+
+func marshalJWK(jwk *JWK) ([]byte, error) {
+	b, err := json.MarshalIndent(jwk, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func parseJWK(data []byte) (*sm2.PrivateKey, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, errors.Wrap(err, "parse jwk")
+	}
+	if jwk.Kty != "EC" || jwk.Crv != jwkCurve {
+		return nil, errors.Errorf("not support jwk kty %s crv %s, only EC SM2 is supported", jwk.Kty, jwk.Crv)
+	}
+	if jwk.D == "" {
+		return nil, errors.New("jwk is a public key, d is required")
+	}
+	d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse jwk d")
+	}
+	key, err := newPrivateKey(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// x 和 y 与私钥不一致时说明 JWK 已损坏
+	if jwk.X != "" || jwk.Y != "" {
+		pub := newJWK(&key.PublicKey)
+		if pub.X != jwk.X || pub.Y != jwk.Y {
+			return nil, errors.New("jwk x and y do not match d")
+		}
+	}
+	return key, nil
+}
+
+// newPrivateKey 由私钥标量计算公钥
+func newPrivateKey(d []byte) (*sm2.PrivateKey, error) {
+	curve := sm2.P256Sm2()
+	k := new(big.Int).SetBytes(d)
+	if k.Sign() == 0 || k.Cmp(new(big.Int).Sub(curve.Params().N, big.NewInt(1))) >= 0 {
+		return nil, errors.New("invalid sm2 private key, out of range")
+	}
+	key := &sm2.PrivateKey{D: k}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(fixed(k))
+	return key, nil
+}
+
+// fixed 将整数编码为 32 字节的大端序
+func fixed(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) >= 32 {
+		return b
+	}
+	return append(bytes.Repeat([]byte{0}, 32-len(b)), b...)
+}
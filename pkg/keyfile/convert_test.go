@@ -0,0 +1,131 @@
+package keyfile
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := EncodeAs(key, FormatPKCS8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pkcs8)
+
+	// 重新加密时使用与解密不同的口令
+	t.Setenv(PassphraseEnv, "old passphrase")
+	t.Setenv(NewPassphraseEnv, "new passphrase")
+	oldPassphrase := &Passphrase{Env: PassphraseEnv, Name: "private key"}
+	encrypted, err := EncodeAs(key, FormatEncrypted, NewPassphrase(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		format     string
+		cipher     string
+		passphrase *Passphrase
+		// data 不为空时直接解码, 否则使用 format 编码后解码
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "pkcs8", format: FormatPKCS8, want: FormatPKCS8},
+		{name: "sec1", format: FormatSEC1, want: FormatSEC1},
+		{name: "hex", format: FormatHex, want: FormatHex},
+		{name: "jwk", format: FormatJWK, want: FormatJWK},
+		{name: "encrypted sm4", format: FormatEncrypted, cipher: "sm4", passphrase: NewPassphrase(""), want: FormatEncrypted},
+		{name: "encrypted aes", format: FormatEncrypted, cipher: "aes", passphrase: NewPassphrase(""), want: FormatEncrypted},
+		{name: "pkcs8 der", data: block.Bytes, want: FormatPKCS8},
+		{name: "new passphrase", data: encrypted, passphrase: NewPassphrase(""), want: FormatEncrypted},
+		{name: "old passphrase", data: encrypted, passphrase: oldPassphrase, wantErr: true},
+		{name: "unknown", data: []byte("not a key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("key.cipher", tt.cipher)
+			data := tt.data
+			if data == nil {
+				var err error
+				if data, err = EncodeAs(key, tt.format, tt.passphrase); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, format, err := Decode(data, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if format != tt.want {
+				t.Errorf("Decode() format = %s, want %s", format, tt.want)
+			}
+			if got.D.Cmp(key.D) != 0 || got.X.Cmp(key.X) != 0 || got.Y.Cmp(key.Y) != 0 {
+				t.Error("Decode() returns a different key")
+			}
+		})
+	}
+}
+
+func TestEncodePublic(t *testing.T) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalSm2PublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format  string
+		wantErr bool
+	}{
+		{format: FormatPEM},
+		{format: FormatDER},
+		{format: FormatHex},
+		{format: FormatJWK},
+		{format: FormatSEC1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			out, err := EncodePublic(&key.PublicKey, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EncodePublic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			switch tt.format {
+			case FormatPEM:
+				block, _ := pem.Decode(out)
+				if block == nil || string(block.Bytes) != string(der) {
+					t.Error("pem public key does not match")
+				}
+			case FormatDER:
+				if string(out) != string(der) {
+					t.Error("der public key does not match")
+				}
+			case FormatHex:
+				if len(out) != 131 || string(out[:2]) != "04" {
+					t.Errorf("hex public key = %s, want 04||X||Y", out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package keyfile
+
+import (
+	"crypto/ecdsa"
+	"encoding/pem"
+	"os"
+
+	"github.com/emmansun/gmsm/pkcs"
+	"github.com/emmansun/gmsm/pkcs8"
+	ssm2 "github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	私钥文件的读写.
+
+	私钥以 PKCS#8 格式保存, 指定口令时使用 PBES2 加密 (ENCRYPTED PRIVATE KEY):
+	1. sm4: PBKDF2 with HMAC-SM3, SM4-CBC, 默认
+	2. aes: PBKDF2 with HMAC-SHA256, AES-256-CBC
+
+	是否加密以及加密算法可通过命令行参数或配置文件指定, 提供了口令文件或口令环境变量时总是加密:
+
+	[key]
+	encrypt = true
+	cipher = "sm4"
+	passphraseFile = "/path/to/passphrase"
+	caPassphraseFile = "/path/to/ca-passphrase"
+
+	私钥文件的权限为 0600.
+*/
+
+const (
+	CipherSM4 = "sm4"
+	CipherAES = "aes"
+
+	// FileMode 私钥文件的权限
+	FileMode = 0o600
+)
+
+// pbkdf2Iterations PBKDF2 的迭代次数
+const pbkdf2Iterations = 10000
+
+var ciphers = map[string]*pkcs8.Opts{
+	CipherSM4: {
+		Cipher:  pkcs.SM4CBC,
+		KDFOpts: pkcs8.PBKDF2Opts{SaltSize: 16, IterationCount: pbkdf2Iterations, HMACHash: pkcs8.SM3},
+	},
+	CipherAES: {
+		Cipher:  pkcs.AES256CBC,
+		KDFOpts: pkcs8.PBKDF2Opts{SaltSize: 16, IterationCount: pbkdf2Iterations, HMACHash: pkcs8.SHA256},
+	},
+}
+
+// Marshal 将私钥编码为 PEM 格式的 PKCS#8, passphrase 为空时不加密
+func Marshal(key *sm2.PrivateKey, passphrase []byte, cipher string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		der, err := x509.MarshalSm2PrivateKey(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	if cipher == "" {
+		cipher = CipherSM4
+	}
+	opts, ok := ciphers[cipher]
+	if !ok {
+		return nil, errors.Errorf("not support key cipher %s, support sm4 and aes", cipher)
+	}
+
+	der, err := pkcs8.MarshalPrivateKey(toEmmansun(key), passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// Parse 解码 PEM 格式的私钥, 支持 PKCS#8 (加密或未加密) 以及 SEC1 (EC PRIVATE KEY 或 SM2 PRIVATE KEY).
+// 私钥加密时才会通过 passphrase 获取口令.
+func Parse(keyPEM []byte, passphrase *Passphrase) (*sm2.PrivateKey, error) {
+	for {
+		block, rest := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, errors.New("type is not PRIVATE KEY")
+		}
+		keyPEM = rest
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8UnecryptedPrivateKey(block.Bytes)
+		case "EC PRIVATE KEY", "SM2 PRIVATE KEY":
+			key, err := smx509.ParseSM2PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return fromEmmansun(key), nil
+		case "ENCRYPTED PRIVATE KEY":
+			if passphrase == nil {
+				return nil, errors.New("private key is encrypted, passphrase is required")
+			}
+			pwd, err := passphrase.Get()
+			if err != nil {
+				return nil, err
+			}
+			key, err := pkcs8.ParsePKCS8PrivateKeySM2(block.Bytes, pwd)
+			if err != nil {
+				return nil, errors.Wrap(err, "decrypt private key, maybe wrong passphrase")
+			}
+			return fromEmmansun(key), nil
+		}
+	}
+}
+
+// ReadFile 读取私钥文件
+func ReadFile(path string, passphrase *Passphrase) (*sm2.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := Parse(b, passphrase)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read private key %s", path)
+	}
+	return key, nil
+}
+
+// Encode 根据配置决定是否加密, 将私钥编码为 PEM 格式
+func Encode(key *sm2.PrivateKey, passphrase *Passphrase) ([]byte, error) {
+	var pwd []byte
+	if viper.GetBool("key.encrypt") || passphrase.Available() {
+		var err error
+		if pwd, err = passphrase.GetNew(); err != nil {
+			return nil, err
+		}
+	}
+	return Marshal(key, pwd, viper.GetString("key.cipher"))
+}
+
+// WriteFile 以 0600 权限写入私钥文件, 文件已经存在时同样修改权限
+func WriteFile(path string, keyPEM []byte) error {
+	if err := os.WriteFile(path, keyPEM, FileMode); err != nil {
+		return err
+	}
+	return os.Chmod(path, FileMode)
+}
+
+func toEmmansun(key *sm2.PrivateKey) *ssm2.PrivateKey {
+	return &ssm2.PrivateKey{PrivateKey: ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: ssm2.P256(), X: key.X, Y: key.Y},
+		D:         key.D,
+	}}
+}
+
+func fromEmmansun(key *ssm2.PrivateKey) *sm2.PrivateKey {
+	return &sm2.PrivateKey{
+		PublicKey: sm2.PublicKey{Curve: sm2.P256Sm2(), X: key.X, Y: key.Y},
+		D:         key.D,
+	}
+}
@@ -0,0 +1,158 @@
+package keyfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+/*
+	私钥口令按 口令文件 -> 环境变量 -> 终端提示 的顺序获取, 获取后在进程内缓存.
+
+	CA 私钥 (根 CA 以及中间 CA) 和其他私钥使用不同的口令:
+	1. CA 私钥: 配置项 key.caPassphraseFile, 环境变量 JCERT_GM_CA_PASSPHRASE
+	2. 其他私钥: 配置项 key.passphraseFile, 环境变量 JCERT_GM_PASSPHRASE
+
+	PKCS#12 文件使用单独的口令: 配置项 pkcs12.passphraseFile, 环境变量 JCERT_GM_P12_PASSPHRASE
+
+	key convert 重新加密私钥时, 新的口令使用环境变量 JCERT_GM_NEW_PASSPHRASE, 参考 NewPassphrase.
+*/
+
+const (
+	CAPassphraseEnv  = "JCERT_GM_CA_PASSPHRASE"
+	PassphraseEnv    = "JCERT_GM_PASSPHRASE"
+	P12PassphraseEnv = "JCERT_GM_P12_PASSPHRASE"
+	NewPassphraseEnv = "JCERT_GM_NEW_PASSPHRASE"
+)
+
+// Passphrase 私钥口令的来源
+type Passphrase struct {
+	// File 口令文件, 结尾的换行会被去掉
+	File string
+	// Env 保存口令的环境变量
+	Env string
+	// Name 终端提示时的私钥名称
+	Name string
+
+	mu    sync.Mutex
+	value []byte
+}
+
+var (
+	caPassphrase   *Passphrase
+	leafPassphrase *Passphrase
+	p12Passphrase  *Passphrase
+	passphraseOnce sync.Once
+)
+
+// CAPassphrase 返回 CA 私钥的口令来源
+func CAPassphrase() *Passphrase {
+	passphraseOnce.Do(initPassphrase)
+	return caPassphrase
+}
+
+// LeafPassphrase 返回证书私钥的口令来源
+func LeafPassphrase() *Passphrase {
+	passphraseOnce.Do(initPassphrase)
+	return leafPassphrase
+}
+
+// PKCS12Passphrase 返回 PKCS#12 文件的口令来源
+func PKCS12Passphrase() *Passphrase {
+	passphraseOnce.Do(initPassphrase)
+	return p12Passphrase
+}
+
+// NewPassphrase 返回重新加密私钥时新口令的来源, file 为空时使用环境变量 JCERT_GM_NEW_PASSPHRASE
+func NewPassphrase(file string) *Passphrase {
+	return &Passphrase{File: file, Env: NewPassphraseEnv, Name: "new private key"}
+}
+
+func initPassphrase() {
+	caPassphrase = &Passphrase{File: viper.GetString("key.caPassphraseFile"), Env: CAPassphraseEnv, Name: "ca key"}
+	leafPassphrase = &Passphrase{File: viper.GetString("key.passphraseFile"), Env: PassphraseEnv, Name: "private key"}
+	p12Passphrase = &Passphrase{File: viper.GetString("pkcs12.passphraseFile"), Env: P12PassphraseEnv, Name: "pkcs12"}
+}
+
+// Available 是否可以不经过终端提示获取口令
+func (p *Passphrase) Available() bool {
+	return p != nil && (p.File != "" || os.Getenv(p.Env) != "")
+}
+
+// Get 获取解密私钥的口令
+func (p *Passphrase) Get() ([]byte, error) {
+	return p.get(false)
+}
+
+// GetNew 获取加密私钥的口令, 终端提示时需要输入两次确认
+func (p *Passphrase) GetNew() ([]byte, error) {
+	return p.get(true)
+}
+
+func (p *Passphrase) get(confirm bool) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.value != nil {
+		return p.value, nil
+	}
+
+	var (
+		value []byte
+		err   error
+	)
+	switch {
+	case p.File != "":
+		value, err = os.ReadFile(p.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "read passphrase file")
+		}
+		value = bytes.TrimRight(value, "\r\n")
+	case os.Getenv(p.Env) != "":
+		value = []byte(os.Getenv(p.Env))
+	default:
+		value, err = p.prompt(confirm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(value) == 0 {
+		return nil, errors.Errorf("passphrase of %s is empty", p.Name)
+	}
+	p.value = value
+	return value, nil
+}
+
+func (p *Passphrase) prompt(confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.Errorf("passphrase of %s is required, set it by passphrase file or env %s", p.Name, p.Env)
+	}
+
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", p.Name)
+	value, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !confirm {
+		return value, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Verifying - Enter passphrase for %s: ", p.Name)
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(value, again) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return value, nil
+}
@@ -0,0 +1,316 @@
+package ocsp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	RFC 6960 OCSP 请求的解析以及响应的生成.
+
+	golang.org/x/crypto/ocsp 只支持标准库的 x509 证书和签名算法, 这里使用 SM2 with SM3 签名响应,
+	CertID 的摘要算法支持 SHA-1, SHA-256 以及 SM3.
+*/
+
+// ResponseStatus OCSP 响应状态
+type ResponseStatus int
+
+const (
+	Successful       ResponseStatus = 0
+	MalformedRequest ResponseStatus = 1
+	InternalError    ResponseStatus = 2
+	TryLater         ResponseStatus = 3
+	SigRequired      ResponseStatus = 5
+	Unauthorized     ResponseStatus = 6
+)
+
+// CertStatus 证书状态
+type CertStatus int
+
+const (
+	Good CertStatus = iota
+	Revoked
+	Unknown
+)
+
+var (
+	oidSignatureSM2WithSM3 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}
+	oidResponseTypeBasic   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidExtensionNonce      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSM3    = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 401}
+)
+
+// hashes CertID 支持的摘要算法
+var hashes = []struct {
+	oid asn1.ObjectIdentifier
+	new func() hash.Hash
+}{
+	{oidSHA1, sha1.New},
+	{oidSHA256, sha256.New},
+	{oidSM3, sm3.New},
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type request struct {
+	Cert certID
+}
+
+type tbsRequest struct {
+	Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+	RequestList       []request
+	RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+// ocspRequest 忽略了可选的请求签名
+type ocspRequest struct {
+	TBSRequest tbsRequest
+}
+
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []singleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag   `asn1:"tag:0,optional"`
+	Revoked    revokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag   `asn1:"tag:2,optional"`
+	ThisUpdate time.Time   `asn1:"generalized"`
+	NextUpdate time.Time   `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// Request 解析后的 OCSP 请求
+type Request struct {
+	Items []RequestItem
+	// Nonce 请求中的 nonce 扩展, 响应时原样返回
+	Nonce *pkix.Extension
+}
+
+// RequestItem 请求查询的单个证书
+type RequestItem struct {
+	id certID
+}
+
+// SerialNumber 返回查询的证书序列号
+func (i RequestItem) SerialNumber() *big.Int {
+	return i.id.SerialNumber
+}
+
+// MatchIssuer 判断查询的证书是否由 issuer 签发
+func (i RequestItem) MatchIssuer(issuer *x509.Certificate) bool {
+	for _, v := range hashes {
+		if !v.oid.Equal(i.id.HashAlgorithm.Algorithm) {
+			continue
+		}
+		nameHash, keyHash, err := issuerHashes(issuer, v.new)
+		if err != nil {
+			return false
+		}
+		return string(nameHash) == string(i.id.NameHash) && string(keyHash) == string(i.id.IssuerKeyHash)
+	}
+	return false
+}
+
+// ParseRequest 解析 der 格式的 OCSP 请求
+func ParseRequest(der []byte) (*Request, error) {
+	var req ocspRequest
+	rest, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data in OCSP request")
+	}
+	if len(req.TBSRequest.RequestList) == 0 {
+		return nil, errors.New("OCSP request contains no request body")
+	}
+
+	r := &Request{}
+	for _, v := range req.TBSRequest.RequestList {
+		r.Items = append(r.Items, RequestItem{id: v.Cert})
+	}
+	for _, v := range req.TBSRequest.RequestExtensions {
+		if v.Id.Equal(oidExtensionNonce) {
+			ext := v
+			r.Nonce = &ext
+		}
+	}
+	return r, nil
+}
+
+// CreateRequest 生成查询 cert 状态的 der 格式 OCSP 请求, CertID 使用 SM3 摘要
+func CreateRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	nameHash, keyHash, err := issuerHashes(issuer, sm3.New)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: tbsRequest{
+			RequestList: []request{{
+				Cert: certID{
+					HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSM3, Parameters: asn1.NullRawValue},
+					NameHash:      nameHash,
+					IssuerKeyHash: keyHash,
+					SerialNumber:  cert.SerialNumber,
+				},
+			}},
+		},
+	})
+}
+
+// SingleResponse 单个证书的状态
+type SingleResponse struct {
+	Item             RequestItem
+	Status           CertStatus
+	RevokedAt        time.Time
+	RevocationReason int
+	ThisUpdate       time.Time
+	NextUpdate       time.Time
+}
+
+// ErrorResponse 生成不带签名的错误响应
+func ErrorResponse(status ResponseStatus) []byte {
+	b, _ := asn1.Marshal(responseASN1{Status: asn1.Enumerated(status)})
+	return b
+}
+
+// CreateResponse 使用 signer 的证书和私钥生成签名的 OCSP 响应.
+// signer 为签发机构本身时不需要附带证书, 为委托签名证书时通过 certs 附带.
+func CreateResponse(signer *x509.Certificate, key crypto.Signer, responses []SingleResponse, nonce *pkix.Extension, certs []*x509.Certificate) ([]byte, error) {
+	_, keyHash, err := issuerHashes(signer, sha1.New)
+	if err != nil {
+		return nil, err
+	}
+	// ResponderID 使用 byKey, 即签名证书公钥的 SHA-1 摘要
+	responderID, err := asn1.Marshal(keyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	data := responseData{
+		RawResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	for _, v := range responses {
+		single := singleResponse{
+			CertID:     v.Item.id,
+			ThisUpdate: v.ThisUpdate.UTC(),
+			NextUpdate: v.NextUpdate.UTC(),
+		}
+		switch v.Status {
+		case Good:
+			single.Good = true
+		case Revoked:
+			single.Revoked = revokedInfo{
+				RevocationTime: v.RevokedAt.UTC(),
+				Reason:         asn1.Enumerated(v.RevocationReason),
+			}
+		default:
+			single.Unknown = true
+		}
+		data.Responses = append(data.Responses, single)
+	}
+	if nonce != nil {
+		data.ResponseExtensions = append(data.ResponseExtensions, *nonce)
+	}
+
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// sm2 签名时会在内部使用 sm3 计算摘要, 直接传入原文
+	signature, err := key.Sign(rand.Reader, tbs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSignatureSM2WithSM3},
+		Signature:          asn1.BitString{Bytes: signature, BitLength: len(signature) * 8},
+	}
+	for _, v := range certs {
+		basic.Certificates = append(basic.Certificates, asn1.RawValue{FullBytes: v.Raw})
+	}
+
+	basicBytes, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(responseASN1{
+		Status: asn1.Enumerated(Successful),
+		Response: responseBytes{
+			ResponseType: oidResponseTypeBasic,
+			Response:     basicBytes,
+		},
+	})
+}
+
+// issuerHashes 计算签发机构主题以及公钥的摘要
+func issuerHashes(issuer *x509.Certificate, newHash func() hash.Hash) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	}
+
+	h := newHash()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h = newHash()
+	h.Write(spki.PublicKey.RightAlign())
+	return nameHash, h.Sum(nil), nil
+}
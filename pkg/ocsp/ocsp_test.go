@@ -0,0 +1,154 @@
+package ocsp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/tjfoc/gmsm/sm3"
+)
+
+func TestParseRequest(t *testing.T) {
+	root := newTestCert(t, 1, "test root", true, nil)
+	other := newTestCert(t, 2, "other root", true, nil)
+	leaf := newTestCert(t, 3, "node1", false, root)
+
+	// 使用不同摘要算法生成的请求
+	request := func(oid asn1.ObjectIdentifier, newHash func() hash.Hash, nonce bool) []byte {
+		nameHash, keyHash, err := issuerHashes(root.Cert, newHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := ocspRequest{TBSRequest: tbsRequest{RequestList: []request{{Cert: certID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			NameHash:      nameHash,
+			IssuerKeyHash: keyHash,
+			SerialNumber:  leaf.Cert.SerialNumber,
+		}}}}}
+		if nonce {
+			req.TBSRequest.RequestExtensions = []pkix.Extension{{Id: oidExtensionNonce, Value: []byte{0x04, 0x02, 0x01, 0x02}}}
+		}
+		b, err := asn1.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	sm3Req, err := CreateRequest(leaf.Cert, root.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		der     []byte
+		nonce   bool
+		wantErr bool
+	}{
+		{name: "sm3", der: sm3Req},
+		{name: "sha1", der: request(oidSHA1, sha1.New, false)},
+		{name: "sha256 with nonce", der: request(oidSHA256, sha256.New, true), nonce: true},
+		{name: "sm3 with nonce", der: request(oidSM3, sm3.New, true), nonce: true},
+		{name: "trailing data", der: append(append([]byte{}, sm3Req...), 0), wantErr: true},
+		{name: "empty", der: []byte{0x30, 0x04, 0x30, 0x02, 0x30, 0x00}, wantErr: true},
+		{name: "garbage", der: []byte("ocsp"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ParseRequest(tt.der)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(req.Items) != 1 {
+				t.Fatalf("items = %d, want 1", len(req.Items))
+			}
+			item := req.Items[0]
+			if item.SerialNumber().Cmp(leaf.Cert.SerialNumber) != 0 {
+				t.Errorf("serial = %s, want %s", item.SerialNumber(), leaf.Cert.SerialNumber)
+			}
+			if !item.MatchIssuer(root.Cert) {
+				t.Error("request does not match issuer")
+			}
+			if item.MatchIssuer(other.Cert) {
+				t.Error("request matches other issuer")
+			}
+			if (req.Nonce != nil) != tt.nonce {
+				t.Errorf("nonce = %v, want %v", req.Nonce, tt.nonce)
+			}
+		})
+	}
+}
+
+func TestCreateResponse(t *testing.T) {
+	root := newTestCert(t, 1, "test root", true, nil)
+	leaf := newTestCert(t, 3, "node1", false, root)
+	der, err := CreateRequest(leaf.Cert, root.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := ParseRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := &pkix.Extension{Id: oidExtensionNonce, Value: []byte{0x04, 0x01, 0x07}}
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		single SingleResponse
+		nonce  *pkix.Extension
+	}{
+		{name: "good", single: SingleResponse{Status: Good}},
+		{name: "revoked", single: SingleResponse{Status: Revoked, RevokedAt: now.Add(-time.Hour), RevocationReason: 1}},
+		{name: "unknown with nonce", single: SingleResponse{Status: Unknown}, nonce: nonce},
+		{name: "next update", single: SingleResponse{Status: Good, NextUpdate: now.Add(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.single.Item, tt.single.ThisUpdate = req.Items[0], now
+			b, err := CreateResponse(root.Cert, root.Key, []SingleResponse{tt.single}, tt.nonce, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, data := parseTestResponse(t, b, root.Cert)
+			if status != Successful {
+				t.Fatalf("response status = %d, want successful", status)
+			}
+
+			single := data.Responses[0]
+			if single.CertID.SerialNumber.Cmp(leaf.Cert.SerialNumber) != 0 {
+				t.Errorf("serial = %s, want %s", single.CertID.SerialNumber, leaf.Cert.SerialNumber)
+			}
+			if bool(single.Good) != (tt.single.Status == Good) || bool(single.Unknown) != (tt.single.Status == Unknown) {
+				t.Errorf("good = %v, unknown = %v, want status %d", single.Good, single.Unknown, tt.single.Status)
+			}
+			if tt.single.Status == Revoked {
+				if !single.Revoked.RevocationTime.Equal(tt.single.RevokedAt) || int(single.Revoked.Reason) != tt.single.RevocationReason {
+					t.Errorf("revoked = %+v, want %s reason %d", single.Revoked, tt.single.RevokedAt, tt.single.RevocationReason)
+				}
+			}
+			if !single.ThisUpdate.Equal(now) || !single.NextUpdate.Equal(tt.single.NextUpdate) {
+				t.Errorf("thisUpdate = %s, nextUpdate = %s", single.ThisUpdate, single.NextUpdate)
+			}
+			if tt.nonce != nil && (len(data.ResponseExtensions) != 1 || string(data.ResponseExtensions[0].Value) != string(tt.nonce.Value)) {
+				t.Errorf("response extensions = %v, want nonce", data.ResponseExtensions)
+			}
+		})
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	for _, status := range []ResponseStatus{MalformedRequest, InternalError, Unauthorized} {
+		got, _ := parseTestResponse(t, ErrorResponse(status), nil)
+		if got != status {
+			t.Errorf("response status = %d, want %d", got, status)
+		}
+	}
+}
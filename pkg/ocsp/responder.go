@@ -0,0 +1,323 @@
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	OCSP 服务, 根据 CA 状态 (pkg/store) 中的证书清单以及吊销记录回答证书状态:
+
+	1. 已吊销的证书返回 revoked
+	2. 证书清单中存在的证书返回 good
+	3. 其他证书返回 unknown
+
+	每个签发机构默认使用自身的私钥签名响应, 也可以指定由签发机构签发的委托签名证书 (extKeyUsage 包含 ocspSigning),
+	此时签发机构的私钥可以离线保存. 既没有私钥也没有委托签名证书的签发机构不会被响应.
+
+	启动后新增的签发机构: 请求中的签发机构没有匹配时重新读取配置目录中的签发机构的证书, 最多每秒读取一次.
+	证书没有变化的签发机构沿用已经打开的私钥, 只为新的签发机构打开私钥. jcert-gm server 中私钥由 ca.CA 打开, 与签发证书共用.
+	私钥无法打开 (口令错误, token 离线, 远程签名服务不可用等) 的签发机构会被跳过并输出到标准错误, 不影响其他签发机构,
+	证书没有变化时不会再次尝试打开.
+
+	支持 RFC 6960 附录 A 中的 GET (base64 编码的请求位于路径中) 以及 POST (application/ocsp-request) 请求.
+*/
+
+const (
+	RequestContentType  = "application/ocsp-request"
+	ResponseContentType = "application/ocsp-response"
+
+	// maxRequestSize 请求的最大长度
+	maxRequestSize = 64 * 1024
+	// reloadInterval 重新读取签发机构的最小间隔, 避免未知签发机构的请求频繁读取配置目录
+	reloadInterval = time.Second
+)
+
+// Config OCSP 服务配置
+type Config struct {
+	// ConfigDir 配置目录, 签发机构以及 CA 状态都保存在该目录下
+	ConfigDir string
+	// CertFile, KeyFile 委托签名证书及其私钥, 可选
+	CertFile string
+	KeyFile  string
+	// NextUpdate 响应的有效期, 为 0 时不设置 nextUpdate
+	NextUpdate time.Duration
+	// Authority 打开签发机构的私钥, 为空时使用 authority.Load, 可以传入 ca.CA.Authority 共用已经打开的私钥
+	Authority func(name string) (*authority.Authority, error)
+}
+
+type responderIssuer struct {
+	name string
+	cert *x509.Certificate
+
+	// signer 签名响应的证书, 为签发机构本身或者委托签名证书
+	signer *x509.Certificate
+	key    crypto.Signer
+	// certs 响应中附带的证书
+	certs []*x509.Certificate
+}
+
+// Responder OCSP 服务
+type Responder struct {
+	configDir  string
+	nextUpdate time.Duration
+
+	// delegate 委托签名证书及其私钥, 可选
+	delegate    *x509.Certificate
+	delegateKey crypto.Signer
+	authority   func(name string) (*authority.Authority, error)
+
+	mu       sync.Mutex
+	issuers  []*responderIssuer
+	loadedAt time.Time
+	// failed 私钥无法打开的签发机构的证书, 证书没有变化时不再尝试
+	failed map[string][]byte
+}
+
+// NewResponder 读取所有签发机构以及委托签名证书
+func NewResponder(c Config) (*Responder, error) {
+	r := &Responder{configDir: c.ConfigDir, nextUpdate: c.NextUpdate, authority: c.Authority, failed: map[string][]byte{}}
+	if r.authority == nil {
+		r.authority = func(name string) (*authority.Authority, error) {
+			return authority.Load(r.configDir, name)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("both ocsp cert and key must be set")
+		}
+		certPEM, err := os.ReadFile(c.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		if r.delegate, err = authority.ParseCert(certPEM); err != nil {
+			return nil, errors.Wrapf(err, "ocsp cert %s", c.CertFile)
+		}
+		if !hasOCSPSigning(r.delegate) {
+			return nil, errors.Errorf("ocsp cert %s has no ocspSigning extended key usage", c.CertFile)
+		}
+		if r.delegateKey, err = keyfile.ReadFile(c.KeyFile, keyfile.LeafPassphrase()); err != nil {
+			return nil, err
+		}
+	}
+
+	issuers, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.issuers, r.loadedAt = issuers, time.Now()
+
+	if r.delegate != nil && !r.hasSigner(r.delegate) {
+		return nil, errors.Errorf("ocsp cert %s is not issued by any authority", c.CertFile)
+	}
+	if len(r.issuers) == 0 {
+		return nil, errors.New("no authority can sign ocsp responses")
+	}
+	return r, nil
+}
+
+// load 读取配置目录中所有可以签名响应的签发机构, 证书没有变化的签发机构沿用 r.issuers 中已经打开的私钥
+func (r *Responder) load() ([]*responderIssuer, error) {
+	names, err := authority.List(r.configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var issuers []*responderIssuer
+	for _, name := range names {
+		cert, err := authority.LoadCert(r.configDir, name)
+		if err != nil {
+			// 证书无法读取时记录为空, 同样只输出一次
+			r.skip(name, nil, err)
+			continue
+		}
+		if v := r.loaded(name, cert); v != nil {
+			issuers = append(issuers, v)
+			continue
+		}
+		if raw, ok := r.failed[name]; ok && bytes.Equal(raw, cert.Raw) {
+			continue
+		}
+		issuer := &responderIssuer{name: name, cert: cert}
+
+		if r.delegate != nil && r.delegate.CheckSignatureFrom(cert) == nil {
+			issuer.signer, issuer.key, issuer.certs = r.delegate, r.delegateKey, []*x509.Certificate{r.delegate}
+		} else {
+			a, err := r.authority(name)
+			if err != nil {
+				// 私钥离线保存的签发机构
+				if !os.IsNotExist(errors.Cause(err)) {
+					r.skip(name, cert.Raw, err)
+				}
+				continue
+			}
+			issuer.signer, issuer.key = a.Cert, a.Key
+		}
+		delete(r.failed, name)
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+// loaded 返回证书没有变化的已经读取的签发机构
+func (r *Responder) loaded(name string, cert *x509.Certificate) *responderIssuer {
+	for _, v := range r.issuers {
+		if v.name == name && bytes.Equal(v.cert.Raw, cert.Raw) {
+			return v
+		}
+	}
+	return nil
+}
+
+// skip 跳过无法使用的签发机构, 记录证书以免每次重新读取时再次打开私钥
+func (r *Responder) skip(name string, raw []byte, err error) {
+	if v, ok := r.failed[name]; ok && bytes.Equal(v, raw) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ocsp: skip authority %s: %v\n", authority.DisplayName(name), err)
+	r.failed[name] = raw
+}
+
+// issuer 查找签发了请求中证书的签发机构, 没有匹配时重新读取签发机构后再查找一次
+func (r *Responder) issuer(item RequestItem) *responderIssuer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v := matchIssuer(r.issuers, item); v != nil || time.Since(r.loadedAt) < reloadInterval {
+		return v
+	}
+	issuers, err := r.load()
+	r.loadedAt = time.Now()
+	if err != nil {
+		return nil
+	}
+	r.issuers = issuers
+	return matchIssuer(r.issuers, item)
+}
+
+func matchIssuer(issuers []*responderIssuer, item RequestItem) *responderIssuer {
+	for _, v := range issuers {
+		if item.MatchIssuer(v.cert) {
+			return v
+		}
+	}
+	return nil
+}
+
+func (r *Responder) hasSigner(cert *x509.Certificate) bool {
+	for _, v := range r.issuers {
+		if v.signer == cert {
+			return true
+		}
+	}
+	return false
+}
+
+func hasOCSPSigning(cert *x509.Certificate) bool {
+	for _, v := range cert.ExtKeyUsage {
+		if v == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+// Respond 根据 der 格式的请求生成 der 格式的响应, 出错时返回对应状态的错误响应
+func (r *Responder) Respond(der []byte) []byte {
+	req, err := ParseRequest(der)
+	if err != nil {
+		return ErrorResponse(MalformedRequest)
+	}
+
+	// 同一个响应只能由一个签名者签名, 所有查询的证书必须由同一个签发机构签发
+	var issuer *responderIssuer
+	for _, item := range req.Items {
+		matched := r.issuer(item)
+		if matched == nil || (issuer != nil && matched.name != issuer.name) {
+			return ErrorResponse(Unauthorized)
+		}
+		issuer = matched
+	}
+
+	// 每次请求重新读取 CA 状态, 以便及时响应通过命令行吊销的证书
+	s, err := store.Open(r.configDir)
+	if err != nil {
+		return ErrorResponse(InternalError)
+	}
+
+	now := time.Now()
+	var responses []SingleResponse
+	for _, item := range req.Items {
+		single := SingleResponse{Item: item, Status: Unknown, ThisUpdate: now}
+		if r.nextUpdate > 0 {
+			single.NextUpdate = now.Add(r.nextUpdate)
+		}
+
+		if rv := s.Revocation(issuer.name, item.SerialNumber()); rv != nil {
+			single.Status = Revoked
+			single.RevokedAt = rv.RevokedAt
+			single.RevocationReason = rv.Reason
+		} else if rec, err := s.Get(item.SerialNumber()); err == nil && rec.Issuer == issuer.name {
+			single.Status = Good
+		}
+		responses = append(responses, single)
+	}
+
+	resp, err := CreateResponse(issuer.signer, issuer.key, responses, req.Nonce, issuer.certs)
+	if err != nil {
+		return ErrorResponse(InternalError)
+	}
+	return resp
+}
+
+// ServeHTTP 处理 GET 以及 POST 请求, GET 请求的路径为 /<base64 编码的请求>, 挂载在其他路径下时需要 http.StripPrefix
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	switch req.Method {
+	case http.MethodGet:
+		s := strings.TrimPrefix(req.URL.Path, "/")
+		// 部分客户端会对 base64 中的字符再次进行 url 编码
+		if v, err := url.PathUnescape(s); err == nil {
+			s = v
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			http.Error(w, "invalid base64 ocsp request", http.StatusBadRequest)
+			return
+		}
+		der = b
+	case http.MethodPost:
+		if ct := req.Header.Get("Content-Type"); ct != "" && ct != RequestContentType {
+			http.Error(w, "content type must be "+RequestContentType, http.StatusUnsupportedMediaType)
+			return
+		}
+		b, err := io.ReadAll(io.LimitReader(req.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		der = b
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", ResponseContentType)
+	_, _ = w.Write(r.Respond(der))
+}
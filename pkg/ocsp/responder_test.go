@@ -0,0 +1,181 @@
+package ocsp
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTestCert 生成由 parent 签发的证书, parent 为 nil 时自签名
+func newTestCert(t *testing.T, serial int64, cn string, isCA bool, parent *testcert.Cert) *testcert.Cert {
+	t.Helper()
+	template := &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: cn}}
+	if isCA {
+		template = testcert.CATemplate(cn, -1)
+		template.SerialNumber = big.NewInt(serial)
+	}
+	return testcert.New(t, template, parent)
+}
+
+// parseTestResponse 解析签名的响应并使用 signer 的公钥校验签名
+func parseTestResponse(t *testing.T, der []byte, signer *x509.Certificate) (ResponseStatus, *responseData) {
+	t.Helper()
+	var resp responseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if ResponseStatus(resp.Status) != Successful {
+		return ResponseStatus(resp.Status), nil
+	}
+	if !resp.Response.ResponseType.Equal(oidResponseTypeBasic) {
+		t.Fatalf("response type = %v, want basic", resp.Response.ResponseType)
+	}
+
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.CheckSignature(x509.SM2WithSM3, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		t.Fatalf("check response signature: %v", err)
+	}
+	var data responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	return Successful, &data
+}
+
+// 启动后新增的中间 CA 签发的证书也能得到响应
+func TestResponderReload(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	dir := t.TempDir()
+	root := newTestCert(t, 1, "test root", true, nil)
+	testcert.WriteAuthority(t, dir, "", root)
+
+	r, err := NewResponder(Config{ConfigDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ops := newTestCert(t, 2, "ops ca", true, root)
+	testcert.WriteAuthority(t, dir, "ops", ops)
+	leaf := newTestCert(t, 3, "node1", false, ops)
+	revoked := newTestCert(t, 4, "node2", false, ops)
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []*testcert.Cert{leaf, revoked} {
+		if err = s.AddCertificate(store.Record{Serial: store.SerialHex(v.Cert.SerialNumber), Issuer: "ops", NotAfter: v.Cert.NotAfter}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Revoke("ops", revoked.Cert.SerialNumber, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		issuer *x509.Certificate
+		want   CertStatus
+	}{
+		{name: "good", cert: leaf.Cert, issuer: ops.Cert, want: Good},
+		{name: "revoked", cert: revoked.Cert, issuer: ops.Cert, want: Revoked},
+		{name: "unknown", cert: newTestCert(t, 5, "node3", false, ops).Cert, issuer: ops.Cert, want: Unknown},
+		{name: "root", cert: ops.Cert, issuer: root.Cert, want: Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 跳过重新读取的间隔
+			r.loadedAt = time.Time{}
+
+			req, err := CreateRequest(tt.cert, tt.issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, data := parseTestResponse(t, r.Respond(req), tt.issuer)
+			if status != Successful {
+				t.Fatalf("response status = %d, want successful", status)
+			}
+			if len(data.Responses) != 1 {
+				t.Fatalf("responses = %d, want 1", len(data.Responses))
+			}
+			single := data.Responses[0]
+			got := Unknown
+			switch {
+			case bool(single.Good):
+				got = Good
+			case !single.Revoked.RevocationTime.IsZero():
+				got = Revoked
+			}
+			if got != tt.want {
+				t.Errorf("cert status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// 私钥无法打开的签发机构被跳过, 重新读取时只打开新的签发机构的私钥
+func TestResponderSkipBrokenKey(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	dir := t.TempDir()
+	root := newTestCert(t, 1, "test root", true, nil)
+	testcert.WriteAuthority(t, dir, "", root)
+	// 私钥与证书不匹配
+	broken := newTestCert(t, 2, "broken ca", true, root)
+	testcert.WriteAuthority(t, dir, "broken", &testcert.Cert{Cert: broken.Cert, Key: newTestCert(t, 3, "other", false, nil).Key})
+
+	opened := map[string]int{}
+	r, err := NewResponder(Config{ConfigDir: dir, Authority: func(name string) (*authority.Authority, error) {
+		opened[name]++
+		return authority.Load(dir, name)
+	}})
+	if err != nil {
+		t.Fatalf("NewResponder() error = %v, want broken authority skipped", err)
+	}
+
+	ops := newTestCert(t, 4, "ops ca", true, root)
+	testcert.WriteAuthority(t, dir, "ops", ops)
+
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		issuer *x509.Certificate
+		want   ResponseStatus
+	}{
+		{name: "root", cert: ops.Cert, issuer: root.Cert, want: Successful},
+		{name: "broken", cert: newTestCert(t, 5, "node1", false, broken).Cert, issuer: broken.Cert, want: Unauthorized},
+		{name: "new authority", cert: newTestCert(t, 6, "node2", false, ops).Cert, issuer: ops.Cert, want: Successful},
+		{name: "broken again", cert: newTestCert(t, 7, "node3", false, broken).Cert, issuer: broken.Cert, want: Unauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.loadedAt = time.Time{}
+			req, err := CreateRequest(tt.cert, tt.issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status, _ := parseTestResponse(t, r.Respond(req), tt.issuer); status != tt.want {
+				t.Errorf("response status = %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	if want := map[string]int{"": 1, "broken": 1, "ops": 1}; !reflect.DeepEqual(opened, want) {
+		t.Errorf("opened keys = %v, want %v", opened, want)
+	}
+}
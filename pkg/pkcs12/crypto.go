@@ -0,0 +1,289 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+	"unicode/utf16"
+
+	"github.com/emmansun/gmsm/pkcs"
+	"github.com/emmansun/gmsm/sm3"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	oidPBES2                      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+
+	oidSHA1           = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSM3            = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 401}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSM3    = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 401, 2}
+)
+
+// hashes 摘要算法以及对应的 PBKDF2 PRF
+var hashes = []struct {
+	oid    asn1.ObjectIdentifier
+	prf    asn1.ObjectIdentifier
+	hash   func() hash.Hash
+	cipher pkcs.Cipher
+}{
+	{oidSM3, oidHMACWithSM3, sm3.New, pkcs.SM4CBC},
+	{oidSHA256, oidHMACWithSHA256, sha256.New, pkcs.AES256CBC},
+	{oidSHA1, oidHMACWithSHA1, sha1.New, nil},
+}
+
+func hashByOID(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	for _, v := range hashes {
+		if v.oid.Equal(oid) {
+			return v.hash, nil
+		}
+	}
+	return nil, errors.Errorf("not support digest algorithm %s", oid)
+}
+
+func hashByPRF(oid asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	// PRF 缺省为 hmacWithSHA1
+	if len(oid) == 0 {
+		return sha1.New, nil
+	}
+	for _, v := range hashes {
+		if v.prf.Equal(oid) {
+			return v.hash, nil
+		}
+	}
+	return nil, errors.Errorf("not support prf %s", oid)
+}
+
+// pbes2Params RFC 8018 PBES2-params
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params RFC 8018 PBKDF2-params
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// pbeParams RFC 7292 pkcs-12PbeParams
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// encrypt 使用口令加密, digest 决定 PBKDF2 的 PRF 以及加密算法, SHA-1 时使用 pbeWithSHAAnd3-KeyTripleDES-CBC
+func encrypt(digest asn1.ObjectIdentifier, password, plaintext []byte) (*pkix.AlgorithmIdentifier, []byte, error) {
+	for _, v := range hashes {
+		if !v.oid.Equal(digest) {
+			continue
+		}
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		if v.cipher == nil {
+			return encryptTripleDES(password, salt, plaintext)
+		}
+
+		key := pbkdf2.Key(password, salt, iterations, v.cipher.KeySize(), v.hash)
+		scheme, ciphertext, err := v.cipher.Encrypt(key, plaintext)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		kdf, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: iterations,
+			KeyLength:      v.cipher.KeySize(),
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: v.prf, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		params, err := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+			EncryptionScheme:  *scheme,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return &pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}, ciphertext, nil
+	}
+	return nil, nil, errors.Errorf("not support encryption with digest %s", digest)
+}
+
+func encryptTripleDES(password, salt, plaintext []byte) (*pkix.AlgorithmIdentifier, []byte, error) {
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: iterations})
+	if err != nil {
+		return nil, nil, err
+	}
+	block, iv, err := tripleDES(password, salt, iterations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// PKCS#7 填充
+	n := des.BlockSize - len(plaintext)%des.BlockSize
+	ciphertext := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(n)}, n)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	return &pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDES, Parameters: asn1.RawValue{FullBytes: params}}, ciphertext, nil
+}
+
+// tripleDES 由 PKCS#12 KDF 生成 3DES 的密钥以及 IV
+func tripleDES(password, salt []byte, iterations int) (cipher.Block, []byte, error) {
+	pwd := bmpString(password)
+	key := pkcs12KDF(sha1.New, 1, pwd, salt, iterations, 24)
+	iv := pkcs12KDF(sha1.New, 2, pwd, salt, iterations, des.BlockSize)
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, iv, nil
+}
+
+// decrypt 支持 PBES2 以及 pbeWithSHAAnd3-KeyTripleDES-CBC
+func decrypt(alg pkix.AlgorithmIdentifier, password, ciphertext []byte) ([]byte, error) {
+	switch {
+	case alg.Algorithm.Equal(oidPBES2):
+		var params pbes2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return nil, errors.Errorf("not support kdf %s", params.KeyDerivationFunc.Algorithm)
+		}
+		var kdf pbkdf2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+			return nil, err
+		}
+		h, err := hashByPRF(kdf.PRF.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		c, err := pkcs.GetCipher(params.EncryptionScheme.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		keyLen := kdf.KeyLength
+		if keyLen == 0 {
+			keyLen = c.KeySize()
+		}
+		key := pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLen, h)
+		return c.Decrypt(key, &params.EncryptionScheme.Parameters, ciphertext)
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDES):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		block, iv, err := tripleDES(password, params.Salt, params.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) == 0 || len(ciphertext)%des.BlockSize != 0 {
+			return nil, ErrIncorrectPassword
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		return unpad(plaintext, des.BlockSize)
+	}
+	return nil, errors.Errorf("not support encryption algorithm %s", alg.Algorithm)
+}
+
+// computeMac 计算 MAC, 密钥由 PKCS#12 KDF 生成
+func computeMac(h func() hash.Hash, password, salt []byte, iterations int, message []byte) []byte {
+	key := pkcs12KDF(h, 3, bmpString(password), salt, iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// pkcs12KDF RFC 7292 附录 B.2, id 为 1 时生成密钥, 2 时生成 IV, 3 时生成 MAC 密钥
+func pkcs12KDF(h func() hash.Hash, id byte, password, salt []byte, iterations, size int) []byte {
+	hh := h()
+	v := hh.BlockSize()
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	i := append(fill(salt, v), fill(password, v)...)
+
+	var out []byte
+	for len(out) < size {
+		hh.Reset()
+		hh.Write(d)
+		hh.Write(i)
+		a := hh.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			hh.Reset()
+			hh.Write(a)
+			a = hh.Sum(a[:0])
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+
+		// I_j = (I_j + B + 1) mod 2^(v*8)
+		b := fill(a, v)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				carry += int(i[j+k]) + int(b[k])
+				i[j+k] = byte(carry)
+				carry >>= 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// fill 重复 b 直到长度为 v 的整数倍
+func fill(b []byte, v int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	out := make([]byte, v*((len(b)+v-1)/v))
+	for i := range out {
+		out[i] = b[i%len(b)]
+	}
+	return out
+}
+
+// bmpString 将口令编码为以两个 0 结尾的 BMPString
+func bmpString(s []byte) []byte {
+	u := utf16.Encode([]rune(string(s)))
+	b := make([]byte, 0, 2*len(u)+2)
+	for _, v := range u {
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return append(b, 0, 0)
+}
+
+func unpad(b []byte, blockSize int) ([]byte, error) {
+	n := int(b[len(b)-1])
+	if n == 0 || n > blockSize || n > len(b) {
+		return nil, ErrIncorrectPassword
+	}
+	for _, v := range b[len(b)-n:] {
+		if int(v) != n {
+			return nil, ErrIncorrectPassword
+		}
+	}
+	return b[:len(b)-n], nil
+}
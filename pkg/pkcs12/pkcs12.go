@@ -0,0 +1,387 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+/*
+	PKCS#12 (RFC 7292) 的编码和解码, 支持 SM2 私钥以及证书链.
+
+	私钥以及证书均以 der 格式传入和返回, 私钥为未加密的 PKCS#8, 与 SM2 的实现无关.
+
+	编码时私钥使用 PKCS8ShroudedKeyBag, 证书使用加密的 SafeContents, 算法由 Cipher 决定:
+	1. sm4: PBES2 (PBKDF2 with HMAC-SM3, SM4-CBC), MAC 使用 HMAC-SM3, 默认
+	2. aes: PBES2 (PBKDF2 with HMAC-SHA256, AES-256-CBC), MAC 使用 HMAC-SHA256, 与 OpenSSL 3 的默认算法相同
+	3. 3des: pbeWithSHAAnd3-KeyTripleDES-CBC, MAC 使用 HMAC-SHA1, 兼容旧版本的 Java 以及 Windows
+
+	解码时不支持 RC2.
+*/
+
+const (
+	CipherSM4 = "sm4"
+	CipherAES = "aes"
+	// CipherTripleDES 兼容模式
+	CipherTripleDES = "3des"
+
+	iterations = 10000
+	saltSize   = 16
+)
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+// ErrIncorrectPassword 口令错误或者 MAC 校验失败
+var ErrIncorrectPassword = errors.New("pkcs12: decryption password incorrect")
+
+// Options 编码选项
+type Options struct {
+	// Cipher 为空时使用 sm4
+	Cipher string
+	// FriendlyName 私钥以及证书的名称, 可以为空
+	FriendlyName string
+}
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// Encode 将私钥, 证书以及证书链编码为 PKCS#12, certs 的第一个为私钥对应的证书
+func Encode(keyDER []byte, certs [][]byte, password []byte, opts Options) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("pkcs12: cert is required")
+	}
+	digest, err := cipherDigest(opts.Cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	// localKeyId 关联私钥和证书, 使用证书的 SHA-1 摘要
+	localKeyID := sha1.Sum(certs[0])
+	attrs, err := bagAttributes(localKeyID[:], opts.FriendlyName)
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, v := range certs {
+		b, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: v})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicit(b)}
+		if i == 0 {
+			bag.Attributes = attrs
+		}
+		certBags = append(certBags, bag)
+	}
+	certContents, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	alg, ciphertext, err := encrypt(digest, password, certContents)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := asn1.Marshal(encryptedData{
+		Version: 0,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidDataContentType,
+			ContentEncryptionAlgorithm: *alg,
+			EncryptedContent:           ciphertext,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alg, ciphertext, err = encrypt(digest, password, keyDER)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: *alg, EncryptedData: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	keyContents, err := asn1.Marshal([]safeBag{{ID: oidPKCS8ShroudedKeyBag, Value: explicit(shrouded), Attributes: attrs}})
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := asn1.Marshal(keyContents)
+	if err != nil {
+		return nil, err
+	}
+
+	authSafe, err := asn1.Marshal([]contentInfo{
+		{ContentType: oidEncryptedDataContentType, Content: explicit(encrypted)},
+		{ContentType: oidDataContentType, Content: explicit(keyData)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	authSafeData, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := hashByOID(digest)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicit(authSafeData)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: digest, Parameters: asn1.NullRawValue},
+				Digest:    computeMac(h, password, salt, iterations, authSafe),
+			},
+			MacSalt:    salt,
+			Iterations: iterations,
+		},
+	})
+}
+
+// Detect 不使用口令判断 data 是否为 PKCS#12
+func Detect(data []byte) bool {
+	var pfx pfxPdu
+	rest, err := asn1.Unmarshal(data, &pfx)
+	return err == nil && len(rest) == 0 && pfx.Version == 3
+}
+
+// Decode 解码 PKCS#12, 返回未加密的 PKCS#8 私钥以及证书, 第一个证书为私钥对应的证书
+func Decode(data []byte, password []byte) (keyDER []byte, certs [][]byte, err error) {
+	var pfx pfxPdu
+	rest, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "pkcs12")
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("pkcs12: trailing data found")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, errors.New("pkcs12: only version 3 is supported")
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, nil, errors.New("pkcs12: only password-protected PFX is supported")
+	}
+
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, errors.Wrap(err, "pkcs12")
+	}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		h, err := hashByOID(pfx.MacData.Mac.Algorithm.Algorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+		mac := computeMac(h, password, pfx.MacData.MacSalt, pfx.MacData.Iterations, authSafe)
+		if !hmac.Equal(mac, pfx.MacData.Mac.Digest) {
+			return nil, nil, ErrIncorrectPassword
+		}
+	}
+
+	var contents []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, nil, errors.Wrap(err, "pkcs12")
+	}
+
+	var (
+		keyID   []byte
+		certIDs [][]byte
+	)
+	for _, ci := range contents {
+		bags, err := safeBags(ci, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, errors.Wrap(err, "pkcs12")
+				}
+				if !cb.ID.Equal(oidCertTypeX509) {
+					continue
+				}
+				certs = append(certs, cb.Data)
+				certIDs = append(certIDs, localKeyIDOf(bag))
+			case bag.ID.Equal(oidKeyBag):
+				keyDER, keyID = bag.Value.Bytes, localKeyIDOf(bag)
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				var info encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &info); err != nil {
+					return nil, nil, errors.Wrap(err, "pkcs12")
+				}
+				if keyDER, err = decrypt(info.Algorithm, password, info.EncryptedData); err != nil {
+					return nil, nil, err
+				}
+				keyID = localKeyIDOf(bag)
+			}
+		}
+	}
+
+	// 私钥对应的证书放在第一个
+	for i := range certs {
+		if keyID != nil && bytes.Equal(certIDs[i], keyID) {
+			certs[0], certs[i] = certs[i], certs[0]
+			break
+		}
+	}
+	return keyDER, certs, nil
+}
+
+// safeBags 解析 SafeContents, 加密时使用口令解密
+func safeBags(ci contentInfo, password []byte) ([]safeBag, error) {
+	var data []byte
+	switch {
+	case ci.ContentType.Equal(oidDataContentType):
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &data); err != nil {
+			return nil, errors.Wrap(err, "pkcs12")
+		}
+	case ci.ContentType.Equal(oidEncryptedDataContentType):
+		var ed encryptedData
+		if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+			return nil, errors.Wrap(err, "pkcs12")
+		}
+		var err error
+		data, err = decrypt(ed.EncryptedContentInfo.ContentEncryptionAlgorithm, password, ed.EncryptedContentInfo.EncryptedContent)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("pkcs12: not support content type %s", ci.ContentType)
+	}
+
+	var bags []safeBag
+	if _, err := asn1.Unmarshal(data, &bags); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	return bags, nil
+}
+
+func cipherDigest(cipher string) (asn1.ObjectIdentifier, error) {
+	switch cipher {
+	case "", CipherSM4:
+		return oidSM3, nil
+	case CipherAES:
+		return oidSHA256, nil
+	case CipherTripleDES:
+		return oidSHA1, nil
+	}
+	return nil, errors.Errorf("not support pkcs12 cipher %s, support sm4, aes and 3des", cipher)
+}
+
+func bagAttributes(localKeyID []byte, friendlyName string) ([]pkcs12Attribute, error) {
+	id, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []pkcs12Attribute{{ID: oidLocalKeyID, Value: set(id)}}
+	if friendlyName != "" {
+		var b []byte
+		for _, v := range utf16.Encode([]rune(friendlyName)) {
+			b = append(b, byte(v>>8), byte(v))
+		}
+		name, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: b})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, pkcs12Attribute{ID: oidFriendlyName, Value: set(name)})
+	}
+	return attrs, nil
+}
+
+func localKeyIDOf(bag safeBag) []byte {
+	for _, v := range bag.Attributes {
+		if !v.ID.Equal(oidLocalKeyID) {
+			continue
+		}
+		var id []byte
+		if _, err := asn1.Unmarshal(v.Value.Bytes, &id); err == nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// explicit 包装为 [0] EXPLICIT
+func explicit(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+func set(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: b}
+}
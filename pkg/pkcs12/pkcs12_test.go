@@ -0,0 +1,107 @@
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTestLeaf 返回测试根 CA 以及其签发的 node1 证书
+func newTestLeaf(t *testing.T) (root, leaf *testcert.Cert) {
+	t.Helper()
+	root = testcert.New(t, testcert.CATemplate("test root", -1), nil)
+	return root, testcert.New(t, &x509.Certificate{Subject: pkix.Name{CommonName: "node1"}}, root)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	root, node1 := newTestLeaf(t)
+	leaf, ca := node1.Cert.Raw, root.Cert.Raw
+	keyDER, err := x509.MarshalSm2PrivateKey(node1.Key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := []byte("p12 passphrase")
+
+	tests := []struct {
+		name     string
+		cipher   string
+		certs    [][]byte
+		password []byte
+		wantErr  error
+	}{
+		{name: "default", certs: [][]byte{leaf, ca}, password: password},
+		{name: "sm4", cipher: CipherSM4, certs: [][]byte{leaf, ca}, password: password},
+		{name: "aes", cipher: CipherAES, certs: [][]byte{leaf}, password: password},
+		{name: "3des", cipher: CipherTripleDES, certs: [][]byte{leaf, ca}, password: password},
+		{name: "wrong password", cipher: CipherSM4, certs: [][]byte{leaf}, password: []byte("wrong"), wantErr: ErrIncorrectPassword},
+		{name: "wrong password 3des", cipher: CipherTripleDES, certs: [][]byte{leaf}, password: []byte("wrong"), wantErr: ErrIncorrectPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pfx, err := Encode(keyDER, tt.certs, password, Options{Cipher: tt.cipher, FriendlyName: "node1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Detect(pfx) {
+				t.Error("Detect() = false, want true")
+			}
+
+			gotKey, gotCerts, err := Decode(pfx, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(gotKey, keyDER) {
+				t.Error("Decode() returns a different private key")
+			}
+			if len(gotCerts) != len(tt.certs) {
+				t.Fatalf("Decode() certs = %d, want %d", len(gotCerts), len(tt.certs))
+			}
+			for i := range tt.certs {
+				if !bytes.Equal(gotCerts[i], tt.certs[i]) {
+					t.Errorf("Decode() cert %d does not match", i)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	_, node1 := newTestLeaf(t)
+	leaf := node1.Cert.Raw
+	keyDER, err := x509.MarshalSm2PrivateKey(node1.Key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		certs [][]byte
+		opts  Options
+	}{
+		{name: "no cert"},
+		{name: "unknown cipher", certs: [][]byte{leaf}, opts: Options{Cipher: "rc2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(keyDER, tt.certs, []byte("p12 passphrase"), tt.opts); err == nil {
+				t.Error("Encode() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	_, node1 := newTestLeaf(t)
+	for name, data := range map[string][]byte{"cert": node1.Cert.Raw, "garbage": []byte("pkcs12"), "empty": nil} {
+		if Detect(data) {
+			t.Errorf("Detect(%s) = true, want false", name)
+		}
+	}
+}
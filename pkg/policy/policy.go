@@ -0,0 +1,294 @@
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	签发策略, 在签发前检查 csr, 命令行和 server 共用. 依次检查:
+
+	1. csr 的签名
+	2. 公钥算法以及曲线
+	3. 模板以及策略要求的主题字段, 禁止出现的主题字段
+	4. 模板允许的 SAN 类型, 以及按组织 (csr 中的 O) 允许的 DNS, IP, email, URI
+	5. 证书的最长有效期
+
+	所有不满足的项会一起返回, 任意一项不满足时拒绝签发:
+
+	[policy]
+	keyAlgorithms = ["sm2", "ecdsa-p256"]   # 允许的公钥算法, 默认为 sm2, ecdsa-p256
+	requiredSubject = ["O"]                 # 所有模板都必须包含的主题字段
+	forbiddenSubject = ["OU"]               # 不允许出现的主题字段
+	maxExpiration = [10, 0, 0]              # 最长有效期, 格式为 [year, month, day], 为空时不限制
+
+	[[policy.organizations]]
+	name = "org1"                           # * 匹配未单独配置的组织以及没有 O 的 csr
+	dns = ["*.org1.example.com"]            # 支持通配符 (path.Match)
+	ip = ["10.1.0.0/16", "192.168.1.1"]     # 支持 CIDR
+	email = ["*@org1.example.com"]
+	uri = ["spiffe://org1.example.com/*"]
+
+	配置了 organizations 时, csr 中的每个 SAN 都必须被其中一个组织允许.
+*/
+
+// DefaultKeyAlgorithms 未配置 policy.keyAlgorithms 时允许的公钥算法
+var DefaultKeyAlgorithms = []string{"sm2", "ecdsa-p256"}
+
+// ErrRejected csr 不满足模板或策略的要求
+var ErrRejected = errors.New("csr rejected")
+
+// Organization 组织允许的 SAN
+type Organization struct {
+	Name  string   `mapstructure:"name"`
+	DNS   []string `mapstructure:"dns"`
+	IP    []string `mapstructure:"ip"`
+	Email []string `mapstructure:"email"`
+	URI   []string `mapstructure:"uri"`
+}
+
+// Policy 签发策略
+type Policy struct {
+	KeyAlgorithms    []string       `mapstructure:"keyAlgorithms"`
+	RequiredSubject  []string       `mapstructure:"requiredSubject"`
+	ForbiddenSubject []string       `mapstructure:"forbiddenSubject"`
+	MaxExpiration    []int          `mapstructure:"maxExpiration"`
+	Organizations    []Organization `mapstructure:"organizations"`
+}
+
+// Report 检查结果, 包含所有不满足的项
+type Report struct {
+	Profile    string
+	Violations []string
+}
+
+// Error 每项一行, 便于命令行阅读
+func (r *Report) Error() string {
+	return fmt.Sprintf("csr rejected by profile %s and policy:\n  - %s", r.Profile, strings.Join(r.Violations, "\n  - "))
+}
+
+// Is 使 errors.Is(err, ErrRejected) 成立
+func (r *Report) Is(target error) bool {
+	return target == ErrRejected
+}
+
+func (r *Report) add(format string, args ...interface{}) {
+	r.Violations = append(r.Violations, fmt.Sprintf(format, args...))
+}
+
+// Load 读取配置项 policy
+func Load() (*Policy, error) {
+	p := &Policy{}
+	if err := viper.UnmarshalKey("policy", p); err != nil {
+		return nil, errors.Wrap(err, "policy")
+	}
+	if len(p.KeyAlgorithms) == 0 {
+		p.KeyAlgorithms = DefaultKeyAlgorithms
+	}
+	if len(p.MaxExpiration) != 0 && len(p.MaxExpiration) != 3 {
+		return nil, errors.New("policy.maxExpiration: format is [year, month, day]")
+	}
+	for _, v := range append(p.RequiredSubject, p.ForbiddenSubject...) {
+		if !profile.IsSubjectField(v) {
+			return nil, errors.Errorf("policy: not support subject field %s", v)
+		}
+	}
+	for _, o := range p.Organizations {
+		if o.Name == "" {
+			return nil, errors.New("policy.organizations: name must be set")
+		}
+		if err := ValidatePatterns(append(append(o.DNS, o.IP...), o.Email...)); err != nil {
+			return nil, errors.Wrapf(err, "policy.organizations %s", o.Name)
+		}
+		for _, v := range o.URI {
+			if _, err := path.Match(v, ""); err != nil {
+				return nil, errors.Wrapf(err, "policy.organizations %s: uri pattern %s", o.Name, v)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Check 检查是否可以使用模板 p 签发有效期为 notBefore 到 notAfter 的证书, 不满足时返回 *Report
+func (pl *Policy) Check(csr *x509.CertificateRequest, p *profile.Profile, notBefore time.Time, notAfter time.Time) error {
+	r := &Report{Profile: p.Name}
+	if err := csr.CheckSignature(); err != nil {
+		r.add("invalid csr signature: %v", err)
+	}
+	return pl.check(r, csr, p, notBefore, notAfter)
+}
+
+// CheckUnsigned 与 Check 相同, 但是不检查 csr 的签名, 用于私钥由本机构生成的中间 CA, 此时 csr 只需要包含主题以及公钥
+func (pl *Policy) CheckUnsigned(csr *x509.CertificateRequest, p *profile.Profile, notBefore time.Time, notAfter time.Time) error {
+	return pl.check(&Report{Profile: p.Name}, csr, p, notBefore, notAfter)
+}
+
+func (pl *Policy) check(r *Report, csr *x509.CertificateRequest, p *profile.Profile, notBefore time.Time, notAfter time.Time) error {
+	if alg := KeyAlgorithm(csr.PublicKey); !contains(pl.KeyAlgorithms, alg) {
+		r.add("key algorithm %s is not allowed, allowed: %s", alg, strings.Join(pl.KeyAlgorithms, ", "))
+	}
+
+	r.Violations = append(r.Violations, p.Violations(csr)...)
+	for _, v := range pl.RequiredSubject {
+		if !profile.HasSubject(csr.Subject, v) {
+			r.add("subject %s is required by policy", v)
+		}
+	}
+	for _, v := range pl.ForbiddenSubject {
+		if profile.HasSubject(csr.Subject, v) {
+			r.add("subject %s is forbidden by policy", v)
+		}
+	}
+
+	pl.checkSANs(r, csr)
+
+	if i := pl.MaxExpiration; len(i) == 3 {
+		if max := notBefore.AddDate(i[0], i[1], i[2]); notAfter.After(max) {
+			r.add("validity until %s exceeds max expiration %d years %d months %d days", notAfter.Format(time.RFC3339), i[0], i[1], i[2])
+		}
+	}
+
+	if len(r.Violations) > 0 {
+		return r
+	}
+	return nil
+}
+
+// checkSANs 每个 SAN 都必须被 csr 中的某个组织允许
+func (pl *Policy) checkSANs(r *Report, csr *x509.CertificateRequest) {
+	if len(pl.Organizations) == 0 {
+		return
+	}
+
+	var rules []Organization
+	for _, o := range pl.Organizations {
+		if contains(csr.Subject.Organization, o.Name) {
+			rules = append(rules, o)
+		}
+	}
+	if len(rules) == 0 {
+		for _, o := range pl.Organizations {
+			if o.Name == "*" {
+				rules = append(rules, o)
+			}
+		}
+	}
+	orgs := strings.Join(csr.Subject.Organization, ", ")
+	if orgs == "" {
+		orgs = "(none)"
+	}
+
+	for _, v := range csr.DNSNames {
+		if !allowed(rules, func(o Organization) bool { return MatchSAN(o.DNS, v, nil) }) {
+			r.add("dns %s is not allowed for organization %s", v, orgs)
+		}
+	}
+	for _, v := range csr.IPAddresses {
+		if !allowed(rules, func(o Organization) bool { return MatchSAN(o.IP, v.String(), v) }) {
+			r.add("ip %s is not allowed for organization %s", v, orgs)
+		}
+	}
+	for _, v := range csr.EmailAddresses {
+		if !allowed(rules, func(o Organization) bool { return MatchSAN(o.Email, v, nil) }) {
+			r.add("email %s is not allowed for organization %s", v, orgs)
+		}
+	}
+	uris, _ := san.URIs(csr.Extensions)
+	for _, v := range uris {
+		if !allowed(rules, func(o Organization) bool { return MatchURI(o.URI, v.String()) }) {
+			r.add("uri %s is not allowed for organization %s", v, orgs)
+		}
+	}
+}
+
+func allowed(rules []Organization, match func(o Organization) bool) bool {
+	for _, o := range rules {
+		if match(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyAlgorithm 返回公钥算法名称, 如 sm2, ecdsa-p256, rsa-2048
+func KeyAlgorithm(pub interface{}) string {
+	switch k := pub.(type) {
+	case *sm2.PublicKey:
+		return "sm2"
+	case *ecdsa.PublicKey:
+		if k.Curve == sm2.P256Sm2() {
+			return "sm2"
+		}
+		return "ecdsa-" + strings.ToLower(strings.ReplaceAll(k.Curve.Params().Name, "-", ""))
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa-%d", k.N.BitLen())
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// ValidatePatterns 检查 SAN 匹配规则, 包含 / 且不是 URI 的规则必须是合法的 CIDR
+func ValidatePatterns(patterns []string) error {
+	for _, v := range patterns {
+		if isCIDR(v) {
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				return errors.Wrapf(err, "san pattern %s", v)
+			}
+			continue
+		}
+		if _, err := path.Match(v, ""); err != nil {
+			return errors.Wrapf(err, "san pattern %s", v)
+		}
+	}
+	return nil
+}
+
+// MatchSAN 检查 SAN 是否匹配任意一条规则, 规则支持通配符 (path.Match, 不区分大小写) 以及 CIDR, ip 不为空时才匹配 CIDR
+func MatchSAN(patterns []string, name string, ip net.IP) bool {
+	for _, pattern := range patterns {
+		if isCIDR(pattern) {
+			if _, ipNet, err := net.ParseCIDR(pattern); err == nil && ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isCIDR(pattern string) bool {
+	return strings.Contains(pattern, "/") && !strings.Contains(pattern, "://")
+}
+
+// MatchURI 检查 URI 是否匹配任意一条规则, 规则支持通配符 (path.Match), * 可以匹配 URI 路径中的一段
+func MatchURI(patterns []string, uri string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, uri); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
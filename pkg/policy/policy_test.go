@@ -0,0 +1,129 @@
+package policy_test
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/spf13/viper"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "organizations", config: map[string]interface{}{"organizations": []map[string]interface{}{{"name": "org1", "dns": []string{"*.org1.example.com"}, "ip": []string{"10.0.0.0/8"}}}}},
+		{name: "max expiration", config: map[string]interface{}{"maxExpiration": []int{1, 0}}, wantErr: true},
+		{name: "subject field", config: map[string]interface{}{"requiredSubject": []string{"CommonName"}}, wantErr: true},
+		{name: "organization name", config: map[string]interface{}{"organizations": []map[string]interface{}{{"dns": []string{"*"}}}}, wantErr: true},
+		{name: "cidr", config: map[string]interface{}{"organizations": []map[string]interface{}{{"name": "org1", "ip": []string{"10.0.0.0/33"}}}}, wantErr: true},
+		{name: "pattern", config: map[string]interface{}{"organizations": []map[string]interface{}{{"name": "org1", "dns": []string{"[a"}}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			if tt.config != nil {
+				viper.Set("policy", tt.config)
+			}
+
+			p, err := policy.Load()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(p.KeyAlgorithms) == 0 {
+				t.Error("Load() does not set default key algorithms")
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	pl := &policy.Policy{
+		KeyAlgorithms:    []string{"sm2"},
+		RequiredSubject:  []string{"O"},
+		ForbiddenSubject: []string{"OU"},
+		MaxExpiration:    []int{1, 0, 0},
+		Organizations: []policy.Organization{
+			{Name: "org1", DNS: []string{"*.org1.example.com"}, IP: []string{"10.1.0.0/16"}, Email: []string{"*@org1.example.com"}, URI: []string{"spiffe://org1.example.com/*"}},
+			{Name: "*", DNS: []string{"*.public.example.com"}},
+		},
+	}
+	server, err := profile.Get("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	org1 := []string{"org1"}
+	spiffe, _ := url.Parse("spiffe://org1.example.com/node1")
+	evil, _ := url.Parse("spiffe://evil.org/node1")
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		algorithm string
+		opts      ca.CSROptions
+		notAfter  time.Time
+		// violation 不为空时应该被拒绝, 且原因包含 violation
+		violation string
+	}{
+		{name: "allowed", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}, DNSNames: []string{"node1.org1.example.com"}}},
+		{name: "all sans", opts: ca.CSROptions{
+			Subject:        pkix.Name{CommonName: "node1", Organization: org1},
+			DNSNames:       []string{"NODE1.org1.example.com"},
+			IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+			EmailAddresses: []string{"admin@org1.example.com"},
+			URIs:           []*url.URL{spiffe},
+		}},
+		{name: "wildcard organization", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "www", Organization: []string{"org2"}}, DNSNames: []string{"www.public.example.com"}}},
+		{name: "key algorithm", algorithm: ca.KeyECDSAP256, opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}}, violation: "key algorithm ecdsa-p256"},
+		{name: "required subject", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1"}}, violation: "subject O is required"},
+		{name: "forbidden subject", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1, OrganizationalUnit: []string{"peer"}}}, violation: "subject OU is forbidden"},
+		{name: "profile subject", opts: ca.CSROptions{Subject: pkix.Name{Organization: org1}}, violation: "CN"},
+		{name: "dns", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}, DNSNames: []string{"node1.org2.example.com"}}, violation: "dns node1.org2.example.com"},
+		{name: "dns of other organization", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: []string{"org2"}}, DNSNames: []string{"node1.org1.example.com"}}, violation: "dns node1.org1.example.com"},
+		{name: "ip", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}, IPAddresses: []net.IP{net.ParseIP("10.2.0.1")}}, violation: "ip 10.2.0.1"},
+		{name: "email", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}, EmailAddresses: []string{"admin@evil.org"}}, violation: "email admin@evil.org"},
+		{name: "uri", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}, URIs: []*url.URL{evil}}, violation: "uri spiffe://evil.org/node1"},
+		{name: "max expiration", opts: ca.CSROptions{Subject: pkix.Name{CommonName: "node1", Organization: org1}}, notAfter: now.AddDate(2, 0, 0), violation: "exceeds max expiration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ca.NewKey(ca.KeyOptions{Algorithm: tt.algorithm})
+			if err != nil {
+				t.Fatal(err)
+			}
+			csr, err := ca.NewCSR(key, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			notAfter := tt.notAfter
+			if notAfter.IsZero() {
+				notAfter = now.AddDate(1, 0, 0)
+			}
+
+			err = pl.Check(csr, server, now, notAfter)
+			if tt.violation == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, policy.ErrRejected) {
+				t.Fatalf("Check() error = %v, want ErrRejected", err)
+			}
+			if !strings.Contains(err.Error(), tt.violation) {
+				t.Errorf("Check() error = %v, want violation %q", err, tt.violation)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package profile
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	证书模板 (profile), 决定签发证书的 key usage, extended key usage, 有效期,
+	允许的 SAN 类型以及 csr 中必须包含的主题字段.
+
+	内置了 default, server, client, codesigning, ca, ocsp, tlcp-sign, tlcp-enc, legacy 几种模板,
+	可以在配置文件中覆盖或者新增. 未指定模板时使用 default, 只允许 serverAuth 和 clientAuth;
+	legacy 允许 clientAuth, serverAuth, codeSigning 以及 emailProtection, 只在明确指定时使用:
+
+	defaultProfile = "server"
+
+	[profiles.server]
+	keyUsage = ["digitalSignature", "keyEncipherment"]
+	extKeyUsage = ["serverAuth"]
+	expiration = [1, 0, 0]
+	allowedSANs = ["dns", "ip"]
+	requiredSubject = ["CN", "O"]
+*/
+
+const (
+	DefaultName = "default"
+	LegacyName  = "legacy"
+)
+
+// Profile 证书模板
+type Profile struct {
+	Name string `mapstructure:"-"`
+
+	// KeyUsage 如 digitalSignature, keyEncipherment, certSign 等
+	KeyUsage []string `mapstructure:"keyUsage"`
+	// ExtKeyUsage 如 serverAuth, clientAuth, codeSigning 等
+	ExtKeyUsage []string `mapstructure:"extKeyUsage"`
+	// Expiration 有效期, 格式为 [year, month, day], 为空时使用全局配置 expiration, 默认 100 年
+	Expiration []int `mapstructure:"expiration"`
+	// AllowedSANs 允许的 SAN 类型, 支持 dns, ip, email, uri
+	AllowedSANs []string `mapstructure:"allowedSANs"`
+	// RequiredSubject csr 中必须包含的主题字段, 支持 CN, O, OU, C, ST, L, street, postalCode, serialNumber
+	RequiredSubject []string `mapstructure:"requiredSubject"`
+
+	// IsCA 签发的证书是否为 CA 证书
+	IsCA bool `mapstructure:"isCA"`
+	// PathLen CA 证书的路径长度限制, 小于 0 表示不限制
+	PathLen int `mapstructure:"pathLen"`
+
+	// OCSPNoCheck 添加 id-pkix-ocsp-nocheck 扩展, 用于 OCSP 委托签名证书
+	OCSPNoCheck bool `mapstructure:"ocspNoCheck"`
+}
+
+var oidExtensionOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+var builtin = map[string]Profile{
+	// 未指定模板时使用, 与 server 以及 client 相同, 用于节点之间的双向 TLS, 不能用于代码签名以及邮件
+	DefaultName: {
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage: []string{"serverAuth", "clientAuth"},
+		AllowedSANs: []string{"dns", "ip", "email", "uri"},
+	},
+	// 之前 default 的行为, 允许所有用途, 需要通过 --profile legacy 明确指定
+	LegacyName: {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"clientAuth", "serverAuth", "codeSigning", "emailProtection"},
+		AllowedSANs: []string{"dns", "ip", "email", "uri"},
+	},
+	"server": {
+		KeyUsage:        []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage:     []string{"serverAuth"},
+		AllowedSANs:     []string{"dns", "ip", "email", "uri"},
+		RequiredSubject: []string{"CN"},
+	},
+	"client": {
+		KeyUsage:        []string{"digitalSignature"},
+		ExtKeyUsage:     []string{"clientAuth"},
+		AllowedSANs:     []string{"dns", "ip", "email", "uri"},
+		RequiredSubject: []string{"CN"},
+	},
+	"codesigning": {
+		KeyUsage:        []string{"digitalSignature"},
+		ExtKeyUsage:     []string{"codeSigning"},
+		RequiredSubject: []string{"CN", "O"},
+	},
+	"ca": {
+		KeyUsage:        []string{"certSign", "crlSign"},
+		RequiredSubject: []string{"CN"},
+		IsCA:            true,
+		PathLen:         -1,
+	},
+	// OCSP 委托签名证书
+	"ocsp": {
+		KeyUsage:        []string{"digitalSignature"},
+		ExtKeyUsage:     []string{"ocspSigning"},
+		RequiredSubject: []string{"CN"},
+		OCSPNoCheck:     true,
+	},
+	// GB/T 38636 TLCP 签名证书
+	"tlcp-sign": {
+		KeyUsage:        []string{"digitalSignature", "contentCommitment"},
+		ExtKeyUsage:     []string{"serverAuth", "clientAuth"},
+		AllowedSANs:     []string{"dns", "ip"},
+		RequiredSubject: []string{"CN"},
+	},
+	// GB/T 38636 TLCP 加密证书
+	"tlcp-enc": {
+		KeyUsage:        []string{"keyEncipherment", "dataEncipherment", "keyAgreement"},
+		ExtKeyUsage:     []string{"serverAuth", "clientAuth"},
+		AllowedSANs:     []string{"dns", "ip"},
+		RequiredSubject: []string{"CN"},
+	},
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// ErrNotFound 模板不存在
+var ErrNotFound = errors.New("profile not found")
+
+// Get 获取模板, 配置文件中的模板优先于内置模板. name 为空时使用配置项 defaultProfile, 默认为 default
+func Get(name string) (*Profile, error) {
+	if name == "" {
+		name = viper.GetString("defaultProfile")
+	}
+	if name == "" {
+		name = DefaultName
+	}
+
+	p, ok := builtin[name]
+	key := "profiles." + name
+	if viper.IsSet(key) {
+		// 配置文件中未指定 pathLen 时不限制路径长度, 与内置的 ca 模板一致
+		p = Profile{PathLen: -1}
+		if err := viper.UnmarshalKey(key, &p); err != nil {
+			return nil, errors.Wrapf(err, "profile %s", name)
+		}
+	} else if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "profile %s", name)
+	}
+	p.Name = name
+
+	// 提前检查配置是否正确
+	if _, err := ParseKeyUsage(p.KeyUsage); err != nil {
+		return nil, errors.Wrapf(err, "profile %s", name)
+	}
+	if _, err := ParseExtKeyUsage(p.ExtKeyUsage); err != nil {
+		return nil, errors.Wrapf(err, "profile %s", name)
+	}
+	return &p, nil
+}
+
+// Names 返回所有可用的模板名称
+func Names() []string {
+	set := make(map[string]struct{})
+	for k := range builtin {
+		set[k] = struct{}{}
+	}
+	for k := range viper.GetStringMap("profiles") {
+		set[k] = struct{}{}
+	}
+	names := make([]string, 0, len(set))
+	for k := range set {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseKeyUsage 将 key usage 名称转化为 x509.KeyUsage
+func ParseKeyUsage(names []string) (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+	for _, v := range names {
+		u, ok := keyUsages[v]
+		if !ok {
+			return 0, errors.Errorf("not support key usage %s", v)
+		}
+		ku |= u
+	}
+	return ku, nil
+}
+
+// ParseExtKeyUsage 将 extended key usage 名称转化为 x509.ExtKeyUsage
+func ParseExtKeyUsage(names []string) ([]x509.ExtKeyUsage, error) {
+	ekus := make([]x509.ExtKeyUsage, 0, len(names))
+	for _, v := range names {
+		u, ok := extKeyUsages[v]
+		if !ok {
+			return nil, errors.Errorf("not support extended key usage %s", v)
+		}
+		ekus = append(ekus, u)
+	}
+	return ekus, nil
+}
+
+// KeyUsageNames 将 x509.KeyUsage 转化为名称, 按位从低到高排列
+func KeyUsageNames(ku x509.KeyUsage) []string {
+	var names []string
+	for bit := x509.KeyUsageDigitalSignature; bit <= x509.KeyUsageDecipherOnly; bit <<= 1 {
+		if ku&bit == 0 {
+			continue
+		}
+		for k, v := range keyUsages {
+			if v == bit {
+				names = append(names, k)
+			}
+		}
+	}
+	return names
+}
+
+// ExtKeyUsageNames 将 x509.ExtKeyUsage 转化为名称, 不支持的用法以及未知的 OID 使用数字表示
+func ExtKeyUsageNames(ekus []x509.ExtKeyUsage, unknown []asn1.ObjectIdentifier) []string {
+	names := make([]string, 0, len(ekus)+len(unknown))
+	for _, u := range ekus {
+		name := fmt.Sprintf("%d", u)
+		for k, v := range extKeyUsages {
+			if v == u {
+				name = k
+			}
+		}
+		names = append(names, name)
+	}
+	for _, v := range unknown {
+		names = append(names, v.String())
+	}
+	return names
+}
+
+// NotAfter 根据模板的有效期计算证书的过期时间
+func (p *Profile) NotAfter(notBefore time.Time) time.Time {
+	i := p.Expiration
+	if len(i) != 3 {
+		i = viper.GetIntSlice("expiration")
+	}
+	if len(i) != 3 {
+		return notBefore.AddDate(100, 0, 0)
+	}
+	return notBefore.AddDate(i[0], i[1], i[2])
+}
+
+// Violations 返回 csr 不满足模板要求的所有项
+func (p *Profile) Violations(csr *x509.CertificateRequest) []string {
+	var violations []string
+
+	for _, v := range p.RequiredSubject {
+		if !HasSubject(csr.Subject, v) {
+			violations = append(violations, "subject "+v+" is required by profile "+p.Name)
+		}
+	}
+
+	// tjfoc/gmsm 不解析 URI
+	uris, err := san.URIs(csr.Extensions)
+	if err != nil {
+		violations = append(violations, err.Error())
+	}
+	sans := map[string]int{
+		"dns":   len(csr.DNSNames),
+		"ip":    len(csr.IPAddresses),
+		"email": len(csr.EmailAddresses),
+		"uri":   len(uris),
+	}
+	for _, t := range []string{"dns", "ip", "email", "uri"} {
+		if sans[t] > 0 && !p.AllowSAN(t) {
+			violations = append(violations, t+" san is not allowed by profile "+p.Name)
+		}
+	}
+	return violations
+}
+
+// Apply 将模板中的 key usage, extended key usage, 有效期以及 basic constraints 应用到证书模板
+func (p *Profile) Apply(template *x509.Certificate) error {
+	ku, err := ParseKeyUsage(p.KeyUsage)
+	if err != nil {
+		return err
+	}
+	ekus, err := ParseExtKeyUsage(p.ExtKeyUsage)
+	if err != nil {
+		return err
+	}
+
+	template.KeyUsage = ku
+	template.ExtKeyUsage = ekus
+	template.NotAfter = p.NotAfter(template.NotBefore)
+
+	if p.IsCA {
+		template.BasicConstraintsValid = true
+		template.IsCA = true
+		template.MaxPathLen = p.PathLen
+		template.MaxPathLenZero = p.PathLen == 0
+		if p.PathLen < 0 {
+			template.MaxPathLen = -1
+		}
+	}
+
+	if p.OCSPNoCheck {
+		// 扩展值为 NULL
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidExtensionOCSPNoCheck, Value: asn1.NullBytes})
+	}
+
+	// 模板不允许的 SAN 类型不会带入证书, URI 由调用方根据 AllowSAN 处理
+	if !p.AllowSAN("dns") {
+		template.DNSNames = nil
+	}
+	if !p.AllowSAN("ip") {
+		template.IPAddresses = nil
+	}
+	if !p.AllowSAN("email") {
+		template.EmailAddresses = nil
+	}
+	return nil
+}
+
+// AllowSAN 模板是否允许 t 类型的 SAN, 类型为 dns, ip, email, uri
+func (p *Profile) AllowSAN(t string) bool {
+	for _, v := range p.AllowedSANs {
+		if strings.EqualFold(v, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectFields 支持的主题字段
+var subjectFields = []string{"CN", "O", "OU", "C", "ST", "L", "street", "postalCode", "serialNumber"}
+
+// IsSubjectField 是否为支持的主题字段
+func IsSubjectField(field string) bool {
+	for _, v := range subjectFields {
+		if strings.EqualFold(v, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSubject 主题中是否包含字段 field
+func HasSubject(name pkix.Name, field string) bool {
+	switch strings.ToUpper(field) {
+	case "CN":
+		return name.CommonName != ""
+	case "O":
+		return len(name.Organization) > 0
+	case "OU":
+		return len(name.OrganizationalUnit) > 0
+	case "C":
+		return len(name.Country) > 0
+	case "ST":
+		return len(name.Province) > 0
+	case "L":
+		return len(name.Locality) > 0
+	case "STREET":
+		return len(name.StreetAddress) > 0
+	case "POSTALCODE":
+		return len(name.PostalCode) > 0
+	case "SERIALNUMBER":
+		return name.SerialNumber != ""
+	}
+	return false
+}
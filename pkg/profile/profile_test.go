@@ -0,0 +1,150 @@
+package profile
+
+import (
+	"crypto/x509/pkix"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func TestGet(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("profiles.subca", map[string]interface{}{"keyUsage": []string{"certSign"}, "isCA": true})
+	viper.Set("profiles.bad", map[string]interface{}{"keyUsage": []string{"sign"}})
+
+	tests := []struct {
+		name    string
+		profile string
+		sans    []string
+		pathLen int
+		wantErr bool
+	}{
+		{name: "default", profile: "", sans: []string{"dns", "ip", "email", "uri"}},
+		{name: "server", profile: "server", sans: []string{"dns", "ip", "email", "uri"}},
+		{name: "client", profile: "client", sans: []string{"dns", "ip", "email", "uri"}},
+		{name: "builtin ca", profile: "ca", pathLen: -1},
+		{name: "config ca", profile: "subca", pathLen: -1},
+		{name: "unknown", profile: "unknown", wantErr: true},
+		{name: "bad key usage", profile: "bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Get(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, v := range tt.sans {
+				if !p.AllowSAN(v) {
+					t.Errorf("profile %s does not allow %s san", p.Name, v)
+				}
+			}
+			if p.IsCA && p.PathLen != tt.pathLen {
+				t.Errorf("profile %s path length = %d, want %d", p.Name, p.PathLen, tt.pathLen)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("profiles.short", map[string]interface{}{"keyUsage": []string{"digitalSignature"}, "expiration": []int{0, 1, 0}})
+	viper.Set("profiles.subca", map[string]interface{}{"keyUsage": []string{"certSign"}, "isCA": true, "pathLen": 0})
+
+	notBefore := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		profile     string
+		keyUsage    x509.KeyUsage
+		extKeyUsage []x509.ExtKeyUsage
+		notAfter    time.Time
+		isCA        bool
+		pathLen     int
+		noCheck     bool
+		dns         bool
+	}{
+		{profile: "default", keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, notAfter: notBefore.AddDate(100, 0, 0), dns: true},
+		{profile: "legacy", keyUsage: x509.KeyUsageDigitalSignature, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection}, notAfter: notBefore.AddDate(100, 0, 0), dns: true},
+		{profile: "server", keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, notAfter: notBefore.AddDate(100, 0, 0), dns: true},
+		{profile: "codesigning", keyUsage: x509.KeyUsageDigitalSignature, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}, notAfter: notBefore.AddDate(100, 0, 0)},
+		{profile: "ocsp", keyUsage: x509.KeyUsageDigitalSignature, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, notAfter: notBefore.AddDate(100, 0, 0), noCheck: true},
+		{profile: "tlcp-sign", keyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, notAfter: notBefore.AddDate(100, 0, 0), dns: true},
+		{profile: "tlcp-enc", keyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement, extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, notAfter: notBefore.AddDate(100, 0, 0), dns: true},
+		{profile: "ca", keyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign, notAfter: notBefore.AddDate(100, 0, 0), isCA: true, pathLen: -1},
+		{profile: "subca", keyUsage: x509.KeyUsageCertSign, notAfter: notBefore.AddDate(100, 0, 0), isCA: true},
+		{profile: "short", keyUsage: x509.KeyUsageDigitalSignature, notAfter: notBefore.AddDate(0, 1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			p, err := Get(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template := &x509.Certificate{NotBefore: notBefore, DNSNames: []string{"node1.example.com"}}
+			if err = p.Apply(template); err != nil {
+				t.Fatal(err)
+			}
+
+			if template.KeyUsage != tt.keyUsage {
+				t.Errorf("key usage = %v, want %v", KeyUsageNames(template.KeyUsage), KeyUsageNames(tt.keyUsage))
+			}
+			if len(template.ExtKeyUsage) != len(tt.extKeyUsage) || len(tt.extKeyUsage) > 0 && !reflect.DeepEqual(template.ExtKeyUsage, tt.extKeyUsage) {
+				t.Errorf("ext key usage = %v, want %v", template.ExtKeyUsage, tt.extKeyUsage)
+			}
+			if !template.NotAfter.Equal(tt.notAfter) {
+				t.Errorf("not after = %s, want %s", template.NotAfter, tt.notAfter)
+			}
+			if template.IsCA != tt.isCA || template.IsCA && (template.MaxPathLen != tt.pathLen || template.MaxPathLenZero != (tt.pathLen == 0)) {
+				t.Errorf("ca = %v, path len = %d, want %v, %d", template.IsCA, template.MaxPathLen, tt.isCA, tt.pathLen)
+			}
+			if noCheck := len(template.ExtraExtensions) == 1 && template.ExtraExtensions[0].Id.Equal(oidExtensionOCSPNoCheck); noCheck != tt.noCheck {
+				t.Errorf("ocsp nocheck = %v, want %v", noCheck, tt.noCheck)
+			}
+			if dns := len(template.DNSNames) > 0; dns != tt.dns {
+				t.Errorf("dns kept = %v, want %v", dns, tt.dns)
+			}
+		})
+	}
+}
+
+func TestViolations(t *testing.T) {
+	server, err := Get("server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codesigning, err := Get("codesigning")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		profile    *Profile
+		csr        *x509.CertificateRequest
+		violations int
+	}{
+		{name: "server", profile: server, csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "node1"}, DNSNames: []string{"node1"}}},
+		{name: "server without cn", profile: server, csr: &x509.CertificateRequest{DNSNames: []string{"node1"}}, violations: 1},
+		{name: "codesigning", profile: codesigning, csr: &x509.CertificateRequest{Subject: pkix.Name{CommonName: "signer", Organization: []string{"org1"}}}},
+		{name: "codesigning sans", profile: codesigning, csr: &x509.CertificateRequest{
+			Subject:        pkix.Name{CommonName: "signer"},
+			DNSNames:       []string{"node1"},
+			IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+			EmailAddresses: []string{"signer@example.com"},
+		}, violations: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := tt.profile.Violations(tt.csr); len(v) != tt.violations {
+				t.Errorf("Violations() = %q, want %d violations", v, tt.violations)
+			}
+		})
+	}
+}
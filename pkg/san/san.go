@@ -0,0 +1,151 @@
+package san
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+	subject alternative name 扩展的编码和解析.
+
+	tjfoc/gmsm 的 x509 不支持 URI 类型的 SAN, 包含 URI 时由本包生成完整的 SAN 扩展,
+	放入 ExtraExtensions 后 tjfoc/gmsm 不会再生成自己的 SAN 扩展.
+*/
+
+// OID subjectAltName 扩展
+var OID = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// GeneralName 中的 tag
+const (
+	tagEmail = 1
+	tagDNS   = 2
+	tagURI   = 6
+	tagIP    = 7
+)
+
+// Names SAN 中的各类名称
+type Names struct {
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// Split 根据格式将名称分为 DNS, IP, email 以及 URI: 可以解析为 IP 的为 IP, 包含 :// 的为 URI, 包含 @ 的为 email, 其余为 DNS
+func Split(values []string) (Names, error) {
+	var n Names
+	for _, v := range values {
+		if err := n.Add(v); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Add 根据格式添加一个名称, 规则与 Split 相同
+func (n *Names) Add(v string) error {
+	switch {
+	case net.ParseIP(v) != nil:
+		n.IPAddresses = append(n.IPAddresses, net.ParseIP(v))
+	case strings.Contains(v, "://"):
+		u, err := ParseURI(v)
+		if err != nil {
+			return err
+		}
+		n.URIs = append(n.URIs, u)
+	case strings.Contains(v, "@"):
+		n.EmailAddresses = append(n.EmailAddresses, v)
+	default:
+		n.DNSNames = append(n.DNSNames, v)
+	}
+	return nil
+}
+
+// ParseURI 解析 URI, 必须包含 scheme
+func ParseURI(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "uri %s", v)
+	}
+	if u.Scheme == "" {
+		return nil, errors.Errorf("uri %s: scheme is required", v)
+	}
+	return u, nil
+}
+
+// Strings 所有名称的字符串形式, 顺序为 DNS, IP, email, URI
+func (n Names) Strings() []string {
+	list := append([]string{}, n.DNSNames...)
+	for _, v := range n.IPAddresses {
+		list = append(list, v.String())
+	}
+	list = append(list, n.EmailAddresses...)
+	for _, v := range n.URIs {
+		list = append(list, v.String())
+	}
+	return list
+}
+
+// Extension 生成包含所有名称的 SAN 扩展
+func (n Names) Extension() (pkix.Extension, error) {
+	var raw []asn1.RawValue
+	for _, v := range n.DNSNames {
+		raw = append(raw, asn1.RawValue{Tag: tagDNS, Class: asn1.ClassContextSpecific, Bytes: []byte(v)})
+	}
+	for _, v := range n.EmailAddresses {
+		raw = append(raw, asn1.RawValue{Tag: tagEmail, Class: asn1.ClassContextSpecific, Bytes: []byte(v)})
+	}
+	for _, v := range n.IPAddresses {
+		ip := v.To4()
+		if ip == nil {
+			ip = v
+		}
+		raw = append(raw, asn1.RawValue{Tag: tagIP, Class: asn1.ClassContextSpecific, Bytes: ip})
+	}
+	for _, v := range n.URIs {
+		raw = append(raw, asn1.RawValue{Tag: tagURI, Class: asn1.ClassContextSpecific, Bytes: []byte(v.String())})
+	}
+	b, err := asn1.Marshal(raw)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: OID, Value: b}, nil
+}
+
+// URIs 从证书或 csr 的扩展中解析 URI 类型的 SAN
+func URIs(extensions []pkix.Extension) ([]*url.URL, error) {
+	var uris []*url.URL
+	for _, e := range extensions {
+		if !e.Id.Equal(OID) {
+			continue
+		}
+		var seq asn1.RawValue
+		rest, err := asn1.Unmarshal(e.Value, &seq)
+		if err != nil {
+			return nil, errors.Wrap(err, "subject alternative name")
+		}
+		if len(rest) != 0 || !seq.IsCompound || seq.Tag != asn1.TagSequence || seq.Class != asn1.ClassUniversal {
+			return nil, errors.New("subject alternative name: bad sequence")
+		}
+		for b := seq.Bytes; len(b) > 0; {
+			var v asn1.RawValue
+			if b, err = asn1.Unmarshal(b, &v); err != nil {
+				return nil, errors.Wrap(err, "subject alternative name")
+			}
+			if v.Class != asn1.ClassContextSpecific || v.Tag != tagURI {
+				continue
+			}
+			u, err := ParseURI(string(v.Bytes))
+			if err != nil {
+				return nil, err
+			}
+			uris = append(uris, u)
+		}
+	}
+	return uris, nil
+}
@@ -0,0 +1,210 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"encoding/asn1"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+/*
+	PKCS#11 token 中的 SM2 私钥, 需要 cgo.
+
+	PKCS#11 标准没有定义 SM2, mechanism 为厂商定义的 SM2 签名机制. hash 决定传给 token 的数据:
+	1. local: 默认, 在本地计算 SM3(Z||M), token 只对 32 字节的摘要签名
+	2. token: 传入原文, 由 token 计算 Z 以及 SM3
+
+	私钥和公钥对象使用 keyLabel 或 keyId 查找, 公钥从公钥对象的 CKA_EC_POINT 读取.
+	PIN 按 pinFile -> 环境变量 JCERT_GM_PKCS11_PIN -> 终端提示 的顺序获取.
+
+	上游 SoftHSM 不支持 SM2, 没有可以在本地运行的 token, 单元测试只覆盖签名格式的转换以及校验 (参考 signer_test.go),
+	与 token 的交互需要使用厂商的 PKCS#11 模块验证.
+*/
+
+type pkcs11Signer struct {
+	mu        sync.Mutex
+	ctx       *pkcs11.Ctx
+	session   pkcs11.SessionHandle
+	key       pkcs11.ObjectHandle
+	pub       *sm2.PublicKey
+	mechanism uint
+	hash      string
+}
+
+// NewPKCS11 登录 token 并查找私钥, 会话在进程内保持
+func NewPKCS11(c PKCS11Config) (crypto.Signer, error) {
+	if c.Module == "" {
+		return nil, errors.New("pkcs11 module is required")
+	}
+	if c.KeyLabel == "" && c.KeyID == "" {
+		return nil, errors.New("pkcs11 keyLabel or keyId is required")
+	}
+	if c.Mechanism == "" {
+		return nil, errors.New("pkcs11 mechanism is required, set the sm2 sign mechanism defined by the token vendor")
+	}
+	mechanism, err := strconv.ParseUint(c.Mechanism, 0, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse pkcs11 mechanism %s", c.Mechanism)
+	}
+	if c.Hash == "" {
+		c.Hash = HashLocal
+	}
+	if c.Hash != HashLocal && c.Hash != HashToken {
+		return nil, errors.Errorf("not support pkcs11 hash %s, support local and token", c.Hash)
+	}
+
+	ctx := pkcs11.New(c.Module)
+	if ctx == nil {
+		return nil, errors.Errorf("load pkcs11 module %s", c.Module)
+	}
+	if err := ctx.Initialize(); err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, errors.Wrap(err, "initialize pkcs11 module")
+	}
+
+	slot, err := findSlot(ctx, c.TokenLabel)
+	if err != nil {
+		return nil, err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, errors.Wrap(err, "open pkcs11 session")
+	}
+
+	pin := &keyfile.Passphrase{File: c.PinFile, Env: PKCS11PINEnv, Name: "pkcs11 token"}
+	pwd, err := pin.Get()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Login(session, pkcs11.CKU_USER, string(pwd)); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return nil, errors.Wrap(err, "login pkcs11 token")
+	}
+
+	s := &pkcs11Signer{ctx: ctx, session: session, mechanism: uint(mechanism), hash: c.Hash}
+	if s.key, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, c); err != nil {
+		return nil, err
+	}
+	pubKey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, c)
+	if err != nil {
+		return nil, err
+	}
+	if s.pub, err = s.readPublicKey(pubKey); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func findSlot(ctx *pkcs11.Ctx, label string) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "list pkcs11 slots")
+	}
+	for _, slot := range slots {
+		if label == "" {
+			return slot, nil
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, errors.Wrap(err, "read pkcs11 token info")
+		}
+		if info.Label == label {
+			return slot, nil
+		}
+	}
+	if label == "" {
+		return 0, errors.New("no pkcs11 token found")
+	}
+	return 0, errors.Errorf("pkcs11 token %s not found", label)
+}
+
+func (s *pkcs11Signer) findObject(class uint, c PKCS11Config) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if c.KeyLabel != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, c.KeyLabel))
+	}
+	if c.KeyID != "" {
+		id, err := hex.DecodeString(c.KeyID)
+		if err != nil {
+			return 0, errors.Wrap(err, "parse pkcs11 keyId")
+		}
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, errors.Wrap(err, "find pkcs11 key")
+	}
+	objects, _, err := s.ctx.FindObjects(s.session, 1)
+	if ferr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "find pkcs11 key")
+	}
+	if len(objects) == 0 {
+		name := "private key"
+		if class == pkcs11.CKO_PUBLIC_KEY {
+			name = "public key"
+		}
+		return 0, errors.Errorf("pkcs11 %s label %q id %q not found", name, c.KeyLabel, c.KeyID)
+	}
+	return objects[0], nil
+}
+
+// readPublicKey 读取 CKA_EC_POINT, 通常为 OCTET STRING 包装的非压缩格式的点
+func (s *pkcs11Signer) readPublicKey(obj pkcs11.ObjectHandle) (*sm2.PublicKey, error) {
+	attrs, err := s.ctx.GetAttributeValue(s.session, obj, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+	if err != nil {
+		return nil, errors.Wrap(err, "read pkcs11 public key")
+	}
+	point := attrs[0].Value
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+
+	curve := sm2.P256Sm2()
+	if len(point) != 65 || point[0] != 4 {
+		return nil, errors.New("pkcs11 public key is not an uncompressed sm2 point")
+	}
+	pub := &sm2.PublicKey{Curve: curve}
+	pub.X, pub.Y = new(big.Int).SetBytes(point[1:33]), new(big.Int).SetBytes(point[33:])
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("pkcs11 public key is not on sm2 curve")
+	}
+	return pub, nil
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, message []byte, _ crypto.SignerOpts) ([]byte, error) {
+	data := message
+	if s.hash == HashLocal {
+		var err error
+		if data, err = Digest(s.pub, message); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(s.mechanism, nil)}, s.key); err != nil {
+		return nil, errors.Wrap(err, "pkcs11 sign")
+	}
+	signature, err := s.ctx.Sign(s.session, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "pkcs11 sign")
+	}
+	signature, err = verify(s.pub, message, signature)
+	return signature, errors.Wrap(err, "pkcs11 sign")
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+
+	"github.com/pkg/errors"
+)
+
+// NewPKCS11 没有 cgo 时不支持 PKCS#11
+func NewPKCS11(c PKCS11Config) (crypto.Signer, error) {
+	return nil, errors.New("pkcs11 signer requires cgo, rebuild with CGO_ENABLED=1")
+}
@@ -0,0 +1,208 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	stdx509 "crypto/x509"
+
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	通过 HTTP 远程签名, 用于 KMS 或者独立的签名服务, 私钥不离开远端. 目前只支持 HTTP JSON 协议, 不支持 gRPC,
+	gRPC 接口的服务需要通过网关转换为以下接口.
+
+	协议为 JSON, 使用 Bearer token 认证, token 按 tokenFile -> 环境变量 JCERT_GM_SIGNER_TOKEN 的顺序获取, 都没有时不认证:
+
+	GET <url>/public-key?keyId=<keyId>
+	响应: {"publicKey": "-----BEGIN PUBLIC KEY-----..."}
+
+	POST <url>/sign
+	请求: {"keyId": "root", "algorithm": "SM2-SM3", "message": "<base64 原文>", "digest": "<hex SM3(Z||M)>"}
+	响应: {"signature": "<base64 ASN.1 或 r||s 签名>"}
+
+	只支持对摘要签名的 KMS 使用 digest, 其他使用 message. 失败时返回非 2xx 状态码以及 {"error": "..."}.
+	caFile 用于校验服务端证书, certFile 和 keyFile 用于双向 TLS, 均为 PEM 格式的 ECDSA 或 RSA 证书.
+*/
+
+const (
+	SignerTokenEnv = "JCERT_GM_SIGNER_TOKEN"
+
+	// remoteAlgorithm 远程签名的算法, 使用 SM3 以及默认的 uid
+	remoteAlgorithm = "SM2-SM3"
+	remoteTimeout   = 10 * time.Second
+)
+
+// RemoteConfig 远程签名的配置
+type RemoteConfig struct {
+	URL       string        `mapstructure:"url"`
+	KeyID     string        `mapstructure:"keyId"`
+	TokenFile string        `mapstructure:"tokenFile"`
+	CAFile    string        `mapstructure:"caFile"`
+	CertFile  string        `mapstructure:"certFile"`
+	KeyFile   string        `mapstructure:"keyFile"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+type remoteSigner struct {
+	url    string
+	keyID  string
+	token  string
+	client *http.Client
+	pub    *sm2.PublicKey
+}
+
+type signRequest struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Message   string `json:"message"`
+	Digest    string `json:"digest"`
+}
+
+type signResponse struct {
+	Signature string `json:"signature"`
+	PublicKey string `json:"publicKey"`
+	Error     string `json:"error"`
+}
+
+// NewRemote 连接远程签名服务并读取公钥
+func NewRemote(c RemoteConfig) (crypto.Signer, error) {
+	if c.URL == "" {
+		return nil, errors.New("remote signer url is required")
+	}
+	s := &remoteSigner{url: strings.TrimRight(c.URL, "/"), keyID: c.KeyID}
+
+	switch {
+	case c.TokenFile != "":
+		b, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read remote signer token file")
+		}
+		s.token = strings.TrimSpace(string(b))
+	case os.Getenv(SignerTokenEnv) != "":
+		s.token = os.Getenv(SignerTokenEnv)
+	}
+
+	config := &tls.Config{}
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read remote signer ca file")
+		}
+		config.RootCAs = stdx509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no cert found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load remote signer client cert")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = remoteTimeout
+	}
+	s.client = &http.Client{Timeout: timeout, Transport: &http.Transport{TLSClientConfig: config}}
+
+	var resp signResponse
+	if err := s.do(http.MethodGet, "/public-key?keyId="+url.QueryEscape(s.keyID), nil, &resp); err != nil {
+		return nil, errors.Wrap(err, "read remote signer public key")
+	}
+	block, _ := pem.Decode([]byte(resp.PublicKey))
+	if block == nil {
+		return nil, errors.New("remote signer public key is not PEM")
+	}
+	pub, err := x509.ParseSm2PublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote signer public key is not sm2")
+	}
+	s.pub = pub
+	return s, nil
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *remoteSigner) Sign(_ io.Reader, message []byte, _ crypto.SignerOpts) ([]byte, error) {
+	digest, err := Digest(s.pub, message)
+	if err != nil {
+		return nil, err
+	}
+	req := &signRequest{
+		KeyID:     s.keyID,
+		Algorithm: remoteAlgorithm,
+		Message:   base64.StdEncoding.EncodeToString(message),
+		Digest:    hex.EncodeToString(digest),
+	}
+	var resp signResponse
+	if err := s.do(http.MethodPost, "/sign", req, &resp); err != nil {
+		return nil, errors.Wrap(err, "remote sign")
+	}
+	signature, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote sign, decode signature")
+	}
+	signature, err = verify(s.pub, message, signature)
+	return signature, errors.Wrap(err, "remote sign")
+}
+
+func (s *remoteSigner) do(method string, path string, body interface{}, v *signResponse) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.url+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	_ = json.Unmarshal(b, v)
+	if resp.StatusCode/100 != 2 {
+		if v.Error != "" {
+			return errors.Errorf("%s: %s", resp.Status, v.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(err, "decode response")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package signer
+
+import (
+	"crypto"
+	"math/big"
+	"strings"
+
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+/*
+	签发机构私钥的后端, 签发证书, CRL 以及 OCSP 响应时只通过 crypto.Signer 使用私钥.
+
+	与 sm2.PrivateKey 相同, Sign 的参数为待签名的原文, 使用 SM3 以及默认的 uid 计算摘要, 返回 ASN.1 编码的签名.
+
+	后端在配置文件中选择, 根 CA 使用 [signer], 中间 CA 使用 [signer.intermediates.<name>], 未配置时使用 file:
+	1. file: 私钥文件, 默认为配置目录中的 ca.key, 可以用 keyFile 指定其他位置
+	2. pkcs11: PKCS#11 token 中的私钥, 参考 pkcs11.go
+	3. remote: 通过 HTTP 远程签名, 参考 remote.go
+
+	[signer]
+	type = "pkcs11"
+
+	[signer.pkcs11]
+	module = "/usr/lib/libvendor-pkcs11.so"
+	tokenLabel = "jcert"
+	keyLabel = "root"
+	mechanism = "0x80000000"
+
+	[signer.intermediates.ops]
+	type = "remote"
+
+	[signer.intermediates.ops.remote]
+	url = "https://kms.example.com/jcert"
+	keyId = "ops"
+
+	名称中包含点的中间 CA 需要加引号, 例如 [signer.intermediates."ops.v2"].
+
+	pkcs11 以及 remote 签名后使用公钥校验签名, 避免使用了错误的私钥.
+*/
+
+const (
+	TypeFile   = "file"
+	TypePKCS11 = "pkcs11"
+	TypeRemote = "remote"
+)
+
+// Config 签发机构私钥的配置
+type Config struct {
+	// Type 为空时使用 file
+	Type string `mapstructure:"type"`
+	// KeyFile file 类型的私钥文件, 为空时使用配置目录中的 ca.key
+	KeyFile string       `mapstructure:"keyFile"`
+	PKCS11  PKCS11Config `mapstructure:"pkcs11"`
+	Remote  RemoteConfig `mapstructure:"remote"`
+}
+
+const (
+	PKCS11PINEnv = "JCERT_GM_PKCS11_PIN"
+
+	HashLocal = "local"
+	HashToken = "token"
+)
+
+// PKCS11Config PKCS#11 后端的配置
+type PKCS11Config struct {
+	// Module PKCS#11 动态库路径
+	Module string `mapstructure:"module"`
+	// TokenLabel 为空时使用第一个有 token 的 slot
+	TokenLabel string `mapstructure:"tokenLabel"`
+	PinFile    string `mapstructure:"pinFile"`
+	KeyLabel   string `mapstructure:"keyLabel"`
+	// KeyID 十六进制的 CKA_ID
+	KeyID string `mapstructure:"keyId"`
+	// Mechanism 厂商定义的 SM2 签名机制, 支持十进制或 0x 开头的十六进制
+	Mechanism string `mapstructure:"mechanism"`
+	Hash      string `mapstructure:"hash"`
+}
+
+// ConfigOf 读取签发机构的私钥配置, name 为空表示根 CA.
+// 中间 CA 按名称精确查找 signer.intermediates 中的配置, 名称中可以包含点, 例如 [signer.intermediates."ops.v2"]
+func ConfigOf(name string) (Config, error) {
+	var c Config
+	if name == "" {
+		if err := viper.UnmarshalKey("signer", &c); err != nil {
+			return c, errors.Wrap(err, "read config signer")
+		}
+		return c, nil
+	}
+
+	// viper 按点拆分配置路径, 不能直接使用 signer.intermediates.<name>. viper 的配置项不区分大小写, 读取时已转为小写
+	intermediates := viper.GetStringMap("signer.intermediates")
+	v, ok := intermediates[strings.ToLower(name)]
+	if !ok {
+		return c, nil
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		Result:           &c,
+	})
+	if err != nil {
+		return c, err
+	}
+	if err = decoder.Decode(v); err != nil {
+		return c, errors.Wrapf(err, "read config signer.intermediates.%q", name)
+	}
+	return c, nil
+}
+
+// External 私钥不保存在文件中, 不能由 jcert-gm 生成
+func (c Config) External() bool {
+	return c.Type != "" && c.Type != TypeFile
+}
+
+// KeyPath 返回 file 类型的私钥文件, defaultPath 为配置目录中的 ca.key
+func (c Config) KeyPath(defaultPath string) string {
+	if c.KeyFile != "" {
+		return c.KeyFile
+	}
+	return defaultPath
+}
+
+// Open 打开签发机构的私钥, 加密的私钥文件使用 CA 私钥口令
+func Open(c Config, defaultPath string) (crypto.Signer, error) {
+	switch c.Type {
+	case "", TypeFile:
+		key, err := keyfile.ReadFile(c.KeyPath(defaultPath), keyfile.CAPassphrase())
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	case TypePKCS11:
+		return NewPKCS11(c.PKCS11)
+	case TypeRemote:
+		return NewRemote(c.Remote)
+	}
+	return nil, errors.Errorf("not support signer type %s, support file, pkcs11 and remote", c.Type)
+}
+
+// Digest 计算 SM2 签名使用的摘要 SM3(Z||M), 用于只接受摘要的后端
+func Digest(pub *sm2.PublicKey, message []byte) ([]byte, error) {
+	e, err := pub.Sm3Digest(message, nil)
+	if err != nil {
+		return nil, err
+	}
+	return leftPad(e, 32), nil
+}
+
+// verify 校验后端返回的签名, 支持 ASN.1 以及 r||s 格式, 返回 ASN.1 编码的签名
+func verify(pub *sm2.PublicKey, message, signature []byte) ([]byte, error) {
+	if len(signature) == 64 {
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if sm2.Sm2Verify(pub, message, nil, r, s) {
+			return sm2.SignDigitToSignData(r, s)
+		}
+	}
+	if !pub.Verify(message, signature) {
+		return nil, errors.New("signature does not match public key")
+	}
+	return signature, nil
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,109 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+)
+
+func TestConfigOf(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.SetConfigType("toml")
+	err := viper.ReadConfig(strings.NewReader(`
+[signer]
+keyFile = "/secure/root.key"
+
+[signer.intermediates.ops]
+type = "pkcs11"
+
+[signer.intermediates.ops.pkcs11]
+keyLabel = "ops"
+
+[signer.intermediates."ops.v2"]
+type = "remote"
+
+[signer.intermediates."ops.v2".remote]
+keyId = "ops.v2"
+timeout = "5s"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want Config
+	}{
+		{name: "", want: Config{KeyFile: "/secure/root.key"}},
+		{name: "ops", want: Config{Type: TypePKCS11, PKCS11: PKCS11Config{KeyLabel: "ops"}}},
+		{name: "ops.v2", want: Config{Type: TypeRemote, Remote: RemoteConfig{KeyID: "ops.v2", Timeout: 5 * time.Second}}},
+		{name: "OPS", want: Config{Type: TypePKCS11, PKCS11: PKCS11Config{KeyLabel: "ops"}}},
+		{name: "ops.v3", want: Config{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ConfigOf(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c != tt.want {
+				t.Errorf("ConfigOf(%q) = %+v, want %+v", tt.name, c, tt.want)
+			}
+		})
+	}
+}
+
+// pkcs11 以及 remote 后端返回的签名可能是 ASN.1 或者 r||s 格式
+func TestVerify(t *testing.T) {
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("tbs certificate")
+
+	asn1Sig, err := key.Sign(rand.Reader, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := sm2.Sm2Sign(key, message, nil, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawSig := append(leftPad(r.Bytes(), 32), leftPad(s.Bytes(), 32)...)
+	otherSig, err := other.Sign(rand.Reader, message, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "asn1", signature: asn1Sig},
+		{name: "r||s", signature: rawSig},
+		{name: "other key", signature: otherSig, wantErr: true},
+		{name: "garbage", signature: bytes.Repeat([]byte{1}, 64), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := verify(&key.PublicKey, message, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !key.PublicKey.Verify(message, sig) {
+				t.Error("verify() does not return an asn1 signature")
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	CA 的状态, 以 json 格式保存在配置目录下的 state.json 中.
+
+	记录了:
+	1. 签发过的所有证书 (证书清单), 以及已经使用过的序列号, 保证序列号不会被重复使用
+	2. 每个签发机构 (根 CA 的名称为空, 中间 CA 为其名称) 已吊销的证书以及 CRL 的序号
+
+	命令行和 server 可能同时修改状态, 每次修改都在 state.json.lock 的文件锁内重新读取状态文件, 修改后写回,
+	保证不会互相覆盖, 序列号以及 CRL 序号也不会重复. 需要多次修改时使用 Update 合并为一次写入.
+*/
+
+const FileName = "state.json"
+
+// LockFileName 修改状态时加锁的文件
+const LockFileName = FileName + ".lock"
+
+const (
+	StatusValid   = "valid"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+	// StatusSuperseded 根 CA 被重新初始化替换, 记录保留以免序列号被再次使用
+	StatusSuperseded = "superseded"
+)
+
+var (
+	// ErrAlreadyRevoked 证书已经被吊销
+	ErrAlreadyRevoked = errors.New("certificate already revoked")
+	// ErrNotFound 证书不存在
+	ErrNotFound = errors.New("certificate not found")
+	// ErrSerialUsed 序列号已经被使用过
+	ErrSerialUsed = errors.New("serial number already used")
+)
+
+// Record 签发的证书记录
+type Record struct {
+	// Serial 证书序列号, 十六进制
+	Serial  string `json:"serial"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	// SANs 证书中的 dns, ip, email 等
+	SANs      []string  `json:"sans"`
+	Profile   string    `json:"profile"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	// Fingerprint 证书 der 编码的 sm3 摘要, 十六进制
+	Fingerprint string `json:"fingerprint"`
+	Requester   string `json:"requester"`
+	Status      string `json:"status"`
+	// Certificate PEM 格式的证书
+	Certificate string `json:"certificate"`
+}
+
+// NewRecord 根据签发的证书生成证书记录
+func NewRecord(cert *x509.Certificate, issuer, profile, requester string) Record {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, v := range cert.IPAddresses {
+		sans = append(sans, v.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	// tjfoc/gmsm 不解析 URI
+	uris, _ := san.URIs(cert.Extensions)
+	for _, v := range uris {
+		sans = append(sans, v.String())
+	}
+
+	return Record{
+		Serial:      SerialHex(cert.SerialNumber),
+		Issuer:      issuer,
+		Subject:     cert.Subject.String(),
+		SANs:        sans,
+		Profile:     profile,
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Fingerprint: hex.EncodeToString(sm3.Sm3Sum(cert.Raw)),
+		Requester:   requester,
+		Status:      StatusValid,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+}
+
+// State 返回证书当前的状态, 未吊销但是已经过期的证书为 expired
+func (r *Record) State(now time.Time) string {
+	if r.Status == StatusValid && now.After(r.NotAfter) {
+		return StatusExpired
+	}
+	return r.Status
+}
+
+// Query 证书查询条件, 为空的条件不参与过滤
+type Query struct {
+	// Text 匹配序列号, 主题, SAN 以及申请人
+	Text    string
+	Issuer  *string
+	Profile string
+	Status  string
+}
+
+// Revocation 吊销记录
+type Revocation struct {
+	Issuer string `json:"issuer"`
+	// Serial 证书序列号, 十六进制
+	Serial    string    `json:"serial"`
+	Reason    int       `json:"reason"`
+	RevokedAt time.Time `json:"revokedAt"`
+}
+
+type state struct {
+	CRLNumber    map[string]int64 `json:"crlNumber"`
+	Revoked      []Revocation     `json:"revoked"`
+	Certificates []Record         `json:"certificates"`
+	// Serials 已经使用过的序列号
+	Serials []string `json:"serials"`
+}
+
+// Store CA 状态存储
+type Store struct {
+	mu   sync.Mutex
+	dir  string
+	data state
+	// batch 为 Update 中的事务, 已经持有文件锁, 修改后不立即写入
+	batch bool
+}
+
+// Open 打开 dir 目录下的状态文件, 不存在时创建一个空的状态
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) path() string {
+	return filepath.Join(s.dir, FileName)
+}
+
+// load 读取状态文件
+func (s *Store) load() error {
+	data := state{CRLNumber: make(map[string]int64)}
+	b, err := os.ReadFile(s.path())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(b, &data); err != nil {
+			return errors.Wrapf(err, "parse %s", s.path())
+		}
+		if data.CRLNumber == nil {
+			data.CRLNumber = make(map[string]int64)
+		}
+	}
+	s.data = data
+	return nil
+}
+
+// Update 在一次加锁以及写入中完成 fn 中对 tx 的所有修改, fn 返回错误时不保存任何修改
+func (s *Store) Update(fn func(tx *Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.locked(func() error {
+		tx := &Store{dir: s.dir, data: s.data, batch: true}
+		if err := fn(tx); err != nil {
+			// tx 与 s 共用 map 以及切片, 重新读取以丢弃修改
+			_ = s.load()
+			return err
+		}
+		s.data = tx.data
+		return s.save()
+	})
+}
+
+// update 在文件锁内重新读取状态, 执行 fn 修改后写回. Update 中的事务直接修改, 由 Update 写回
+func (s *Store) update(fn func() error) error {
+	if s.batch {
+		return fn()
+	}
+	return s.locked(func() error {
+		if err := fn(); err != nil {
+			return err
+		}
+		return s.save()
+	})
+}
+
+// locked 获得文件锁并重新读取状态后执行 fn
+func (s *Store) locked(fn func() error) error {
+	f, err := os.OpenFile(filepath.Join(s.dir, LockFileName), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = lockFile(f); err != nil {
+		return errors.Wrap(err, "lock state")
+	}
+	defer func() { _ = unlockFile(f) }()
+
+	if err = s.load(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// Revoke 记录吊销的证书
+func (s *Store) Revoke(issuer string, serial *big.Int, reason int, revokedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serialHex := SerialHex(serial)
+	return s.update(func() error {
+		for _, v := range s.data.Revoked {
+			if v.Issuer == issuer && v.Serial == serialHex {
+				return errors.Wrapf(ErrAlreadyRevoked, "serial %s", serialHex)
+			}
+		}
+
+		s.data.Revoked = append(s.data.Revoked, Revocation{
+			Issuer:    issuer,
+			Serial:    serialHex,
+			Reason:    reason,
+			RevokedAt: revokedAt.UTC(),
+		})
+		for i := range s.data.Certificates {
+			if s.data.Certificates[i].Issuer == issuer && s.data.Certificates[i].Serial == serialHex {
+				s.data.Certificates[i].Status = StatusRevoked
+			}
+		}
+		return nil
+	})
+}
+
+// Revocation 返回证书的吊销记录, 未吊销时返回 nil
+func (s *Store) Revocation(issuer string, serial *big.Int) *Revocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serialHex := SerialHex(serial)
+	for _, v := range s.data.Revoked {
+		if v.Issuer == issuer && v.Serial == serialHex {
+			r := v
+			return &r
+		}
+	}
+	return nil
+}
+
+// NewSerial 随机生成一个从未使用过的不超过 20 字节的序列号, 并记录为已使用
+func (s *Store) NewSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var serial *big.Int
+	err := s.update(func() error {
+		for {
+			v, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 159))
+			if err != nil {
+				return err
+			}
+			v.Add(v, big.NewInt(1))
+			if s.serialUsed(SerialHex(v)) {
+				continue
+			}
+			s.data.Serials = append(s.data.Serials, SerialHex(v))
+			serial = v
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// UseSerial 记录用户指定的序列号为已使用, 已经使用过时返回 ErrSerialUsed
+func (s *Store) UseSerial(serial *big.Int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serialHex := SerialHex(serial)
+	return s.update(func() error {
+		if s.serialUsed(serialHex) {
+			return errors.Wrapf(ErrSerialUsed, "serial %s", serialHex)
+		}
+		s.data.Serials = append(s.data.Serials, serialHex)
+		return nil
+	})
+}
+
+// Supersede 将使用序列号 serial 的有效证书记录标记为 superseded, 用于强制重新初始化时保留被替换的根 CA,
+// 序列号仍然是已使用的状态
+func (s *Store) Supersede(serial *big.Int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serialHex := SerialHex(serial)
+	return s.update(func() error {
+		for i := range s.data.Certificates {
+			if s.data.Certificates[i].Serial == serialHex && s.data.Certificates[i].Status == StatusValid {
+				s.data.Certificates[i].Status = StatusSuperseded
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) serialUsed(serialHex string) bool {
+	for _, v := range s.data.Serials {
+		if v == serialHex {
+			return true
+		}
+	}
+	for _, v := range s.data.Certificates {
+		if v.Serial == serialHex {
+			return true
+		}
+	}
+	return false
+}
+
+// AddCertificate 记录签发的证书
+func (s *Store) AddCertificate(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Status == "" {
+		r.Status = StatusValid
+	}
+	return s.update(func() error {
+		for _, v := range s.data.Certificates {
+			if v.Serial == r.Serial {
+				return errors.Wrapf(ErrSerialUsed, "serial %s", r.Serial)
+			}
+		}
+		s.data.Certificates = append(s.data.Certificates, r)
+		return nil
+	})
+}
+
+// Get 根据序列号获取证书记录
+func (s *Store) Get(serial *big.Int) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serialHex := SerialHex(serial)
+	for _, v := range s.data.Certificates {
+		if v.Serial == serialHex {
+			r := v
+			return &r, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrNotFound, "serial %s", serialHex)
+}
+
+// Search 根据查询条件查找证书记录, 按签发时间排序
+func (s *Store) Search(q Query) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	text := strings.ToLower(q.Text)
+
+	var list []Record
+	for _, v := range s.data.Certificates {
+		if q.Issuer != nil && v.Issuer != *q.Issuer {
+			continue
+		}
+		if q.Profile != "" && v.Profile != q.Profile {
+			continue
+		}
+		if q.Status != "" && v.State(now) != q.Status {
+			continue
+		}
+		if text != "" && !v.match(text) {
+			continue
+		}
+		list = append(list, v)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].NotBefore.Before(list[j].NotBefore)
+	})
+	return list
+}
+
+func (r *Record) match(text string) bool {
+	fields := append([]string{r.Serial, r.Subject, r.Requester, r.Fingerprint}, r.SANs...)
+	for _, v := range fields {
+		if strings.Contains(strings.ToLower(v), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked 返回签发机构已吊销的证书, 按吊销时间排序
+func (s *Store) Revoked(issuer string) []Revocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Revocation
+	for _, v := range s.data.Revoked {
+		if v.Issuer == issuer {
+			list = append(list, v)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].RevokedAt.Before(list[j].RevokedAt)
+	})
+	return list
+}
+
+// NextCRLNumber 返回签发机构下一个 CRL 的序号并保存, 保证单调递增
+func (s *Store) NextCRLNumber(issuer string) (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var number int64
+	err := s.update(func() error {
+		s.data.CRLNumber[issuer]++
+		number = s.data.CRLNumber[issuer]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(number), nil
+}
+
+// Reset 清除签发机构的吊销记录, 用于重新初始化 CA
+func (s *Store) Reset(issuer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.update(func() error {
+		var revoked []Revocation
+		for _, v := range s.data.Revoked {
+			if v.Issuer != issuer {
+				revoked = append(revoked, v)
+			}
+		}
+		s.data.Revoked = revoked
+		delete(s.data.CRLNumber, issuer)
+		return nil
+	})
+}
+
+// save 先写入临时文件再重命名, 避免写入过程中断导致状态文件损坏
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path() + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path())
+}
+
+// SerialHex 将序列号转化为十六进制字符串
+func SerialHex(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// ParseSerial 解析十六进制的序列号
+func ParseSerial(s string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		return nil, errors.Errorf("invalid serial number %s", s)
+	}
+	return serial, nil
+}
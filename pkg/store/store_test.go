@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 每个 goroutine 单独打开状态文件, 模拟同时运行的命令行以及 server
+func TestConcurrentUpdate(t *testing.T) {
+	dir := t.TempDir()
+	const n = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := Open(dir)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err = s.NewSerial(); err != nil {
+				errs <- err
+				return
+			}
+			if _, err = s.NextCRLNumber(""); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.data.Serials) != n {
+		t.Errorf("serials = %d, want %d", len(s.data.Serials), n)
+	}
+	number, err := s.NextCRLNumber("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number.Int64() != n+1 {
+		t.Errorf("crl number = %d, want %d", number, n+1)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	errAbort := errors.New("abort")
+	tests := []struct {
+		name    string
+		err     error
+		records int
+	}{
+		{name: "commit", records: 1},
+		{name: "rollback", err: errAbort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.Update(func(tx *Store) error {
+				serial, err := tx.NewSerial()
+				if err != nil {
+					return err
+				}
+				if err = tx.AddCertificate(Record{Serial: SerialHex(serial), NotAfter: time.Now().Add(time.Hour)}); err != nil {
+					return err
+				}
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.err)
+			}
+
+			for _, v := range []*Store{s, mustOpen(t, dir)} {
+				if len(v.data.Certificates) != tt.records || len(v.data.Serials) != tt.records {
+					t.Errorf("certificates = %d, serials = %d, want %d", len(v.data.Certificates), len(v.data.Serials), tt.records)
+				}
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := mustOpen(t, t.TempDir())
+	serial := big.NewInt(0x1234)
+	if err := s.AddCertificate(Record{Serial: SerialHex(serial)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke("", serial, 1, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke("", serial, 1, time.Now()); !errors.Is(err, ErrAlreadyRevoked) {
+		t.Fatalf("Revoke() error = %v, want ErrAlreadyRevoked", err)
+	}
+	r, err := mustOpen(t, s.dir).Get(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != StatusRevoked {
+		t.Errorf("status = %s, want %s", r.Status, StatusRevoked)
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	s := mustOpen(t, t.TempDir())
+	serial := big.NewInt(0x1234)
+	if err := s.AddCertificate(Record{Serial: SerialHex(serial)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Supersede(serial); err != nil {
+		t.Fatal(err)
+	}
+	r, err := mustOpen(t, s.dir).Get(serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != StatusSuperseded {
+		t.Errorf("status = %s, want %s", r.Status, StatusSuperseded)
+	}
+	// 被替换的证书的序列号不能再次使用
+	if err = s.UseSerial(serial); !errors.Is(err, ErrSerialUsed) {
+		t.Fatalf("UseSerial() error = %v, want ErrSerialUsed", err)
+	}
+}
+
+func mustOpen(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package subject
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+	证书主题 (DN) 的解析.
+
+	支持两种格式:
+	1. OpenSSL 格式, 以 / 开头: /C=CN/ST=浙江省/O=Org/OU=peer/CN=node1
+	2. 逗号分隔: CN=node1,OU=peer,O=Org,C=CN
+
+	值中的分隔符可以使用 \ 转义. 属性名称支持 C, ST, L, street, postalCode, O, OU, CN, serialNumber,
+	不区分大小写, 其他属性使用点分格式的 OID, 如 2.5.4.13=description.
+*/
+
+// Set 将属性 key 的值 value 添加到主题中, key 为属性名称或 OID
+func Set(name *pkix.Name, key string, value string) error {
+	key = strings.TrimSpace(key)
+	switch strings.ToLower(key) {
+	case "c":
+		name.Country = append(name.Country, value)
+	case "st":
+		name.Province = append(name.Province, value)
+	case "l":
+		name.Locality = append(name.Locality, value)
+	case "street":
+		name.StreetAddress = append(name.StreetAddress, value)
+	case "postalcode":
+		name.PostalCode = append(name.PostalCode, value)
+	case "o":
+		name.Organization = append(name.Organization, value)
+	case "ou":
+		name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+	case "cn":
+		name.CommonName = value
+	case "serialnumber":
+		name.SerialNumber = value
+	default:
+		oid, err := ParseOID(key)
+		if err != nil {
+			return errors.Errorf("not support subject attribute %s", key)
+		}
+		name.ExtraNames = append(name.ExtraNames, pkix.AttributeTypeAndValue{Type: oid, Value: value})
+	}
+	return nil
+}
+
+// ParseAttr 解析 OID=value 或者 属性名称=value 形式的属性并添加到主题中
+func ParseAttr(name *pkix.Name, attr string) error {
+	key, value, ok := cut(attr, '=')
+	if !ok || strings.TrimSpace(key) == "" {
+		return errors.Errorf("invalid subject attribute %s, format is key=value", attr)
+	}
+	return Set(name, key, unescape(value))
+}
+
+// ParseDN 解析完整的主题
+func ParseDN(dn string) (pkix.Name, error) {
+	var name pkix.Name
+	s := strings.TrimSpace(dn)
+	sep := byte(',')
+	if strings.HasPrefix(s, "/") {
+		s, sep = s[1:], '/'
+	}
+	for _, v := range split(s, sep) {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		if err := ParseAttr(&name, v); err != nil {
+			return name, errors.Wrapf(err, "subject %s", dn)
+		}
+	}
+	return name, nil
+}
+
+// ParseOID 解析点分格式的 OID
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf("invalid oid %s", s)
+	}
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, v := range parts {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return nil, errors.Errorf("invalid oid %s", s)
+		}
+		oid = append(oid, i)
+	}
+	return oid, nil
+}
+
+// split 按 sep 分割, 跳过被 \ 转义的分隔符
+func split(s string, sep byte) []string {
+	var (
+		list  []string
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			list = append(list, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(list, s[start:])
+}
+
+// cut 在第一个未转义的 sep 处分割
+func cut(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,330 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	证书链校验. 从终端证书开始, 在根证书和中间证书中逐级查找签发者, 直到信任的根证书, 并检查每一级的:
+	1. 签名: 由上一级签发, 根证书作为信任锚不检查
+	2. 有效期
+	3. CA: 基本约束, 密钥用法包含 certSign, 路径长度, 以及其下所有证书是否满足名称约束
+	4. 用途: 终端证书的扩展密钥用法以及与之对应的密钥用法
+	5. 主机名: 终端证书的 DNS 或 IP SAN
+	6. 吊销状态: 使用由签发者签名的 CRL
+
+	与 x509 的 Verify 不同, 校验失败时仍然返回已经构建的证书链以及每一级的检查结果, 便于定位问题.
+*/
+
+const (
+	CheckSignature  = "signature"
+	CheckValidity   = "validity"
+	CheckCA         = "ca"
+	CheckNames      = "name constraints"
+	CheckPurpose    = "purpose"
+	CheckHostname   = "hostname"
+	CheckRevocation = "revocation"
+)
+
+// maxDepth 证书链的最大长度
+const maxDepth = 10
+
+var oidExtensionReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// Options 校验选项
+type Options struct {
+	Roots         []*x509.Certificate
+	Intermediates []*x509.Certificate
+	// KeyUsages 满足其中之一即可, 为空或包含 ExtKeyUsageAny 时不检查用途
+	KeyUsages []x509.ExtKeyUsage
+	// Host 为空时不检查主机名
+	Host string
+	// CheckCRL 检查吊销状态, 找不到签发者的 CRL 时校验失败
+	CheckCRL bool
+	CRLs     []*pkix.CertificateList
+	// CurrentTime 为空时使用当前时间
+	CurrentTime time.Time
+}
+
+// Check 一项检查的结果, Err 为空时通过
+type Check struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// Link 证书链中的一级
+type Link struct {
+	Cert *x509.Certificate
+	// Anchor 为信任的根证书
+	Anchor bool
+	Checks []Check
+}
+
+// Result 校验结果, Chain 的第一个为终端证书
+type Result struct {
+	Chain []*Link
+	// Err 无法构建到根证书的证书链
+	Err error
+}
+
+// OK 证书链完整并且所有检查都通过
+func (r *Result) OK() bool {
+	return len(r.Errors()) == 0
+}
+
+// Errors 返回所有失败的原因
+func (r *Result) Errors() []error {
+	var errs []error
+	for i, link := range r.Chain {
+		for _, c := range link.Checks {
+			if c.Err != nil {
+				errs = append(errs, errors.Wrapf(c.Err, "[%d] %s %s", i, link.Cert.Subject.CommonName, c.Name))
+			}
+		}
+	}
+	if r.Err != nil {
+		errs = append(errs, r.Err)
+	}
+	return errs
+}
+
+// Verify 构建并校验 leaf 到根证书的证书链
+func Verify(leaf *x509.Certificate, opts Options) *Result {
+	now := opts.CurrentTime
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	r := &Result{}
+	for cert := leaf; cert != nil; {
+		link := &Link{Cert: cert, Anchor: contains(opts.Roots, cert)}
+		r.Chain = append(r.Chain, link)
+		if link.Anchor {
+			break
+		}
+		if len(r.Chain) == maxDepth {
+			r.Err = errors.Errorf("chain is longer than %d", maxDepth)
+			break
+		}
+
+		issuer := findIssuer(cert, r.Chain, opts)
+		if issuer == nil {
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				r.Err = errors.Errorf("self-signed cert %s is not trusted", cert.Subject)
+			} else {
+				r.Err = errors.Errorf("issuer %s not found", cert.Issuer)
+			}
+		}
+		cert = issuer
+	}
+
+	for i, link := range r.Chain {
+		var issuer *x509.Certificate
+		if i+1 < len(r.Chain) {
+			issuer = r.Chain[i+1].Cert
+		}
+		cert := link.Cert
+
+		if link.Anchor {
+			link.Checks = append(link.Checks, Check{Name: CheckSignature, Detail: "trusted root"})
+		} else if issuer != nil {
+			link.Checks = append(link.Checks, checkSignature(cert, issuer))
+		}
+		link.Checks = append(link.Checks, checkValidity(cert, now))
+		if i > 0 {
+			link.Checks = append(link.Checks, checkCA(cert, i-1))
+			link.Checks = append(link.Checks, checkNameConstraints(cert, r.Chain[:i]))
+		}
+		if i == 0 && len(opts.KeyUsages) > 0 {
+			link.Checks = append(link.Checks, checkPurpose(cert, opts.KeyUsages))
+		}
+		if i == 0 && opts.Host != "" {
+			c := Check{Name: CheckHostname, Detail: opts.Host, Err: cert.VerifyHostname(opts.Host)}
+			link.Checks = append(link.Checks, c)
+		}
+		if opts.CheckCRL && !link.Anchor && issuer != nil {
+			link.Checks = append(link.Checks, checkRevocation(cert, issuer, opts.CRLs, now))
+		}
+	}
+	return r
+}
+
+// findIssuer 按签发者名称查找, 优先选择签名校验通过的证书, 跳过已经在证书链中的证书
+func findIssuer(cert *x509.Certificate, chain []*Link, opts Options) *x509.Certificate {
+	var candidate *x509.Certificate
+	for _, v := range append(append([]*x509.Certificate{}, opts.Roots...), opts.Intermediates...) {
+		if !bytes.Equal(v.RawSubject, cert.RawIssuer) || inChain(chain, v) {
+			continue
+		}
+		if v.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+			return v
+		}
+		if candidate == nil {
+			candidate = v
+		}
+	}
+	return candidate
+}
+
+func checkSignature(cert, issuer *x509.Certificate) Check {
+	c := Check{Name: CheckSignature, Detail: "signed by " + issuer.Subject.String()}
+	if err := issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		c.Err = errors.Wrap(err, "bad signature")
+	}
+	return c
+}
+
+func checkValidity(cert *x509.Certificate, now time.Time) Check {
+	c := Check{
+		Name:   CheckValidity,
+		Detail: fmt.Sprintf("%s ~ %s", cert.NotBefore.Local().Format(time.RFC3339), cert.NotAfter.Local().Format(time.RFC3339)),
+	}
+	switch {
+	case now.Before(cert.NotBefore):
+		c.Err = errors.Errorf("not valid before %s", cert.NotBefore.Local().Format(time.RFC3339))
+	case now.After(cert.NotAfter):
+		c.Err = errors.Errorf("expired at %s", cert.NotAfter.Local().Format(time.RFC3339))
+	}
+	return c
+}
+
+// checkCA 检查签发者的约束, pathLen 为其下方中间 CA 的数量
+func checkCA(cert *x509.Certificate, pathLen int) Check {
+	c := Check{Name: CheckCA, Detail: "path length unlimited"}
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		c.Detail = fmt.Sprintf("path length %d", cert.MaxPathLen)
+	}
+	switch {
+	case !cert.BasicConstraintsValid || !cert.IsCA:
+		c.Err = errors.New("not a ca")
+	case cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0:
+		c.Err = errors.Errorf("key usage %s does not allow certSign", strings.Join(profile.KeyUsageNames(cert.KeyUsage), ","))
+	case (cert.MaxPathLen > 0 || cert.MaxPathLenZero) && pathLen > cert.MaxPathLen:
+		c.Err = errors.Errorf("path length %d exceeds %d", pathLen, cert.MaxPathLen)
+	}
+	return c
+}
+
+// checkNameConstraints 检查 CA 证书的名称约束, below 为证书链中其下的所有证书
+func checkNameConstraints(cert *x509.Certificate, below []*Link) Check {
+	c := Check{Name: CheckNames, Detail: "none"}
+	nc, err := ca.ParseNameConstraints(cert)
+	if err != nil {
+		c.Err = err
+		return c
+	}
+	if nc == nil {
+		return c
+	}
+	c.Detail = nc.String()
+	for _, v := range below {
+		if err = ca.CheckNameConstraints(v.Cert, cert); err != nil {
+			c.Err = errors.Wrap(err, v.Cert.Subject.CommonName)
+			return c
+		}
+	}
+	return c
+}
+
+// purposeKeyUsages 扩展密钥用法允许的密钥用法, 满足其中之一即可
+var purposeKeyUsages = map[x509.ExtKeyUsage]x509.KeyUsage{
+	x509.ExtKeyUsageServerAuth:      x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement,
+	x509.ExtKeyUsageClientAuth:      x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageKeyAgreement,
+	x509.ExtKeyUsageCodeSigning:     x509.KeyUsageDigitalSignature,
+	x509.ExtKeyUsageEmailProtection: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+	x509.ExtKeyUsageTimeStamping:    x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+	x509.ExtKeyUsageOCSPSigning:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+}
+
+func checkPurpose(cert *x509.Certificate, usages []x509.ExtKeyUsage) Check {
+	c := Check{Name: CheckPurpose, Detail: strings.Join(profile.ExtKeyUsageNames(usages, nil), ",")}
+	for _, u := range usages {
+		if u == x509.ExtKeyUsageAny {
+			c.Detail = "any"
+			return c
+		}
+	}
+
+	// 没有扩展密钥用法时不限制用途
+	eku := len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0
+	var allowed x509.KeyUsage
+	for _, u := range usages {
+		for _, v := range cert.ExtKeyUsage {
+			if v == u || v == x509.ExtKeyUsageAny {
+				eku = true
+			}
+		}
+		allowed |= purposeKeyUsages[u]
+	}
+	switch {
+	case !eku:
+		c.Err = errors.Errorf("ext key usage %s does not allow %s",
+			strings.Join(profile.ExtKeyUsageNames(cert.ExtKeyUsage, cert.UnknownExtKeyUsage), ","), c.Detail)
+	case cert.KeyUsage != 0 && allowed != 0 && cert.KeyUsage&allowed == 0:
+		c.Err = errors.Errorf("key usage %s does not allow %s", strings.Join(profile.KeyUsageNames(cert.KeyUsage), ","), c.Detail)
+	}
+	return c
+}
+
+// checkRevocation 在 CRL 中查找证书, 只使用由签发者签名的 CRL
+func checkRevocation(cert, issuer *x509.Certificate, crls []*pkix.CertificateList, now time.Time) Check {
+	c := Check{Name: CheckRevocation}
+	for _, crl := range crls {
+		if issuer.CheckCRLSignature(crl) != nil {
+			continue
+		}
+		tbs := crl.TBSCertList
+		if !tbs.NextUpdate.IsZero() && now.After(tbs.NextUpdate) {
+			c.Err = errors.Errorf("crl of %s expired at %s", issuer.Subject.CommonName, tbs.NextUpdate.Local().Format(time.RFC3339))
+			return c
+		}
+		for _, v := range tbs.RevokedCertificates {
+			if v.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+				continue
+			}
+			var reason asn1.Enumerated
+			for _, ext := range v.Extensions {
+				if ext.Id.Equal(oidExtensionReasonCode) {
+					_, _ = asn1.Unmarshal(ext.Value, &reason)
+				}
+			}
+			c.Err = errors.Errorf("serial %s revoked at %s, reason %s",
+				store.SerialHex(cert.SerialNumber), v.RevocationTime.Local().Format(time.RFC3339), ca.ReasonName(int(reason)))
+			return c
+		}
+		c.Detail = "not revoked, crl updated at " + tbs.ThisUpdate.Local().Format(time.RFC3339)
+		return c
+	}
+	c.Err = errors.Errorf("no crl signed by %s", issuer.Subject.CommonName)
+	return c
+}
+
+func contains(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, v := range certs {
+		if bytes.Equal(v.Raw, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+func inChain(chain []*Link, cert *x509.Certificate) bool {
+	for _, v := range chain {
+		if bytes.Equal(v.Cert.Raw, cert.Raw) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package verify
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func caTemplate(cn string, pathLen int, permittedDNS ...string) *x509.Certificate {
+	template := testcert.CATemplate(cn, pathLen)
+	template.PermittedDNSDomains = permittedDNS
+	template.PermittedDNSDomainsCritical = len(permittedDNS) > 0
+	return template
+}
+
+func leafTemplate(cn string, dns ...string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		DNSNames:    dns,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	root := testcert.New(t, caTemplate("root", -1), nil)
+	ops := testcert.New(t, caTemplate("ops", -1, "example.com"), root)
+	sub := testcert.New(t, caTemplate("sub", -1), ops)
+	rootZero := testcert.New(t, caTemplate("root zero", 0), nil)
+	opsZero := testcert.New(t, caTemplate("ops zero", -1), rootZero)
+	other := testcert.New(t, caTemplate("other", -1), nil)
+
+	expired := leafTemplate("old.example.com", "old.example.com")
+	expired.NotBefore = time.Now().Add(-2 * time.Hour)
+	expired.NotAfter = time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name   string
+		leaf   *x509.Certificate
+		issuer *testcert.Cert
+		roots  []*x509.Certificate
+		host   string
+		ok     bool
+	}{
+		{name: "root issued", leaf: leafTemplate("node1", "node1"), issuer: root, ok: true},
+		{name: "permitted", leaf: leafTemplate("www.example.com", "www.example.com"), issuer: ops, host: "www.example.com", ok: true},
+		{name: "san not permitted", leaf: leafTemplate("www.example.com", "evil.org"), issuer: ops},
+		{name: "cn not permitted", leaf: leafTemplate("evil.org"), issuer: ops},
+		{name: "below sub ca not permitted", leaf: leafTemplate("evil.org", "evil.org"), issuer: sub},
+		{name: "path length exceeded", leaf: leafTemplate("node1", "node1"), issuer: opsZero, roots: []*x509.Certificate{rootZero.Cert}},
+		{name: "expired", leaf: expired, issuer: root},
+		{name: "host mismatch", leaf: leafTemplate("node1", "node1"), issuer: root, host: "node2"},
+		{name: "untrusted root", leaf: leafTemplate("node1", "node1"), issuer: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := testcert.New(t, tt.leaf, tt.issuer)
+			roots := tt.roots
+			if roots == nil {
+				roots = []*x509.Certificate{root.Cert}
+			}
+			r := Verify(leaf.Cert, Options{
+				Roots:         roots,
+				Intermediates: []*x509.Certificate{ops.Cert, sub.Cert, opsZero.Cert, other.Cert},
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+				Host:          tt.host,
+			})
+			if r.OK() != tt.ok {
+				t.Fatalf("Verify() ok = %v, want %v, errors %v", r.OK(), tt.ok, r.Errors())
+			}
+		})
+	}
+}
@@ -3,72 +3,90 @@ package api
 import (
 	"archive/tar"
 	"archive/zip"
-	"bytes"
 	"compress/gzip"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/pem"
-	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/emmansun/gmsm/pkcs7"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
-	"github.com/tjfoc/gmsm/x509"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/server/auth"
+	"github.com/pkg/errors"
 )
 
-func Router(rg *gin.RouterGroup) {
-	rg.POST("/upload", handleUpload)
-	rg.GET("/download/:filename", handleDownload)
+// Router 注册所有接口, 除了下载 CA 证书以及 CRL, 其他接口都需要认证
+func Router(rg *gin.RouterGroup, a *auth.Authenticator, c *ca.CA) {
+	certAuthority = c
+
+	rg.GET("/ca", handleDownloadCA)
+	rg.GET("/crl", handleDownloadCRL)
+
+	private := rg.Group("", authenticate(a))
+	private.POST("/upload", handleUpload)
+	private.GET("/download/:filename", handleDownload)
+
+	private.POST("/certificates", handleIssue)
+	private.GET("/certificates", handleListCertificates)
+	private.GET("/certificates/:serial", handleGetCertificate)
+	private.POST("/certificates/:serial/revoke", handleRevoke)
 }
 
 func handleUpload(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
+		badRequest(c, "file is required: "+err.Error())
 		return
 	}
-	f, err := file.Open()
-	if err != nil {
+	issuerName := c.PostForm("issuer")
+	if !ca.ValidIssuer(issuerName) {
+		badRequest(c, "invalid issuer "+issuerName)
 		return
 	}
-	fileBytes, err := io.ReadAll(f)
-	if err != nil {
+	if err = os.MkdirAll("data", 0o755); err != nil {
+		abortWithError(c, err)
 		return
 	}
-	tarfileFp := filepath.Join("data", file.Filename)
-	err = os.WriteFile(tarfileFp, fileBytes, 0o755)
-	if err != nil {
+	tarfileFp := filepath.Join("data", uuid.New().String()+".tar.gz")
+	if err = c.SaveUploadedFile(file, tarfileFp); err != nil {
+		abortWithError(c, err)
 		return
 	}
 	// 解压
 	tid := uuid.New().String()
 	err = UnpackTarGz(tarfileFp, filepath.Join("data", tid))
 	if err != nil {
+		badRequest(c, "invalid tar.gz file: "+err.Error())
 		return
 	}
 	s, err := GetDirAllFilePathWithSuffix(filepath.Join("data", tid), ".csr")
 	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if len(s) == 0 {
+		badRequest(c, "no csr file found in "+file.Filename)
 		return
 	}
 
 	// 生成证书
 	oid := uuid.New().String()
-	_ = os.MkdirAll(filepath.Join("data", oid), 0o755)
+	if err = os.MkdirAll(filepath.Join("data", oid), 0o755); err != nil {
+		abortWithError(c, err)
+		return
+	}
 	for _, v := range s {
-		err = generateCert(v, filepath.Join("data", oid))
+		err = generateCert(c, issuerName, v, filepath.Join("data", oid), c.PostForm("profile"))
 		if err != nil {
+			abortWithError(c, errors.Wrap(err, filepath.Base(v)))
 			return
 		}
 	}
 	err = CompressFolder(filepath.Join("data", oid), filepath.Join("data", fmt.Sprintf("%s.zip", oid)))
 	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
@@ -81,148 +99,47 @@ func handleUpload(c *gin.Context) {
 
 func handleDownload(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "application/octet-stream")
-	c.File(filepath.Join("data", c.Params.ByName("filename")))
+	c.File(filepath.Join("data", filepath.Base(c.Params.ByName("filename"))))
 }
 
-func generateCert(csrfp string, output string) error {
-	configDir := filepath.Dir(viper.ConfigFileUsed())
-
+func generateCert(c *gin.Context, issuerName string, csrfp string, output string, profileName string) error {
 	// 读取CSR文件
 	csrPEM, err := os.ReadFile(csrfp)
 	if err != nil {
 		return err
 	}
-
-	// 解码CSR文件
-	csrBlock, _ := pem.Decode(csrPEM)
-	if csrBlock == nil || csrBlock.Type != "CERTIFICATE REQUEST" {
-		return errors.New("type is not CERTIFICATE REQUEST")
-	}
-	csr, err := x509.ParseCertificateRequest(csrBlock.Bytes)
+	csr, err := parseCsr(csrPEM)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ca.ErrRejected, err)
 	}
 
-	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: csr.RawSubjectPublicKeyInfo})
-
-	pub, err := x509.ReadPublicKeyFromPem(pubPEM)
-	if err != nil {
+	if profileName, err = checkIssuePermission(c, profileName, csr); err != nil {
 		return err
 	}
 
-	// 申请序列号
-	// 随机生成一个
-	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<63-1))
+	cert, err := certAuthority.IssueFromCSR(c.Request.Context(), csr, profileName, ca.IssueOptions{Issuer: issuerName, Requester: requester(c)})
 	if err != nil {
 		return err
 	}
 
-	// 读取机构 ca 文件
-	caPEM, err := os.ReadFile(filepath.Join(configDir, "ca.cert"))
-	if err != nil {
-		return err
-	}
-
-	// 解码 ca 文件
-	caBlock, _ := pem.Decode(caPEM)
-	if err != nil {
-		return err
-	}
-
-	ca, err := x509.ParseCertificate(caBlock.Bytes)
-	if err != nil {
-		return err
+	ou := ""
+	if len(csr.Subject.OrganizationalUnit) > 0 {
+		ou = csr.Subject.OrganizationalUnit[0]
 	}
-
-	// 读取机构 ca 私钥
-	caKey, err := os.ReadFile(filepath.Join(configDir, "ca.key"))
+	// CN 以及 OU 来自请求方, 不能包含路径
+	generatedCert, err := safeJoin(output, fmt.Sprintf("%s-%s-%s.p7b", fileNamePart(csr.Subject.CommonName), fileNamePart(ou), uuid.New().String()[:6]))
 	if err != nil {
 		return err
 	}
-
-	privateKey, err := x509.ReadPrivateKeyFromPem(caKey, nil)
+	p7b, err := cert.Encode(ca.FormatPKCS7)
 	if err != nil {
 		return err
 	}
 
-	// 创建证书模板
-	// 获取签发证书的时间
-	var year, month, day int
-	i := viper.GetIntSlice("expiration")
-	if len(i) != 3 {
-		year = 100
-	} else {
-		year = i[0]
-		month = i[1]
-		day = i[2]
-	}
-	template := &x509.Certificate{
-		SerialNumber:          serialNumber,
-		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(year, month, day),
-		SubjectKeyId:          []byte{1, 2, 3, 4, 6},
-		KeyUsage:              x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection},
-		PublicKeyAlgorithm:    csr.PublicKeyAlgorithm,
-		SignatureAlgorithm:    csr.SignatureAlgorithm,
-		DNSNames:              csr.DNSNames,
-		CRLDistributionPoints: viper.GetStringSlice("CRLDistributionPoints"),
-		OCSPServer:            viper.GetStringSlice("OCSPServer"),
-	}
-
-	// 使用SM2密钥对签名证书
-	derBytes, err := x509.CreateCertificate(template, ca, pub, privateKey)
-	if err != nil {
-		return err
-	}
-
-	// 将证书转换为PEM格式
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-
-	generatedCert := filepath.Join(output, fmt.Sprintf("%s-%s-%s.p7b", csr.Subject.CommonName, csr.Subject.OrganizationalUnit[0], uuid.New().String()[:6]))
-	p7b, err := saveCertToPkcs7(certPEM, caPEM)
-	if err != nil {
-		return err
-	}
-
-	err = os.WriteFile(generatedCert, p7b, 0o755)
+	err = os.WriteFile(generatedCert, p7b, 0o644)
 	return err
 }
 
-func saveCertToPkcs7(cert []byte, ca []byte) ([]byte, error) {
-	b := savaCertToPem(cert, ca)
-
-	buffer := &bytes.Buffer{}
-	for {
-		block, rest := pem.Decode(b)
-		if block == nil {
-			break
-		}
-		buffer.Write(block.Bytes)
-		b = rest
-	}
-
-	p7b, err := pkcs7.DegenerateCertificate(buffer.Bytes())
-	if err != nil {
-		return nil, err
-	}
-
-	s := base64.StdEncoding.EncodeToString(p7b)
-
-	return []byte(s), nil
-}
-
-func savaCertToPem(cert []byte, ca []byte) []byte {
-	buffer := &bytes.Buffer{}
-
-	buffer.Write(ca)
-	buffer.Write([]byte("\n"))
-	buffer.Write(cert)
-
-	return buffer.Bytes()
-}
-
 // GetDirAllFilePathWithSuffix gets all the file paths in the specified directory recursively with suffix.
 func GetDirAllFilePathWithSuffix(dirname string, suffix string) ([]string, error) {
 	filePaths, err := GetDirAllFilePath(dirname)
@@ -266,6 +183,26 @@ func GetDirAllFilePath(dirname string) ([]string, error) {
 	return paths, nil
 }
 
+// fileNamePart 将主题中的字段转换为文件名的一部分, 路径分隔符替换为 _
+func fileNamePart(s string) string {
+	s = strings.NewReplacer("/", "_", `\`, "_").Replace(s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// safeJoin 拼接 dest 与 name, 结果不在 dest 目录下时返回错误
+func safeJoin(dest string, name string) (string, error) {
+	path := filepath.Join(dest, name)
+	rel, err := filepath.Rel(dest, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+		return "", errors.Errorf("illegal file path %s", name)
+	}
+	return path, nil
+}
+
+// UnpackTarGz 解压到 dest 目录, 只解压目录以及普通文件, 路径不在 dest 下的文件 (如 ../) 会被拒绝
 func UnpackTarGz(filename string, dest string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -290,7 +227,10 @@ func UnpackTarGz(filename string, dest string) error {
 			return err
 		}
 
-		path := filepath.Join(dest, hdr.Name)
+		path, err := safeJoin(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
 
 		switch hdr.Typeflag {
 		case tar.TypeDir:
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dest := t.TempDir()
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "node1.csr"},
+		{name: "node1/node1.csr"},
+		{name: "a/../node1.csr"},
+		{name: "../evil", wantErr: true},
+		{name: "a/../../evil", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: fileNamePart("../../etc") + "-ou-123456.p7b"},
+	}
+	for _, tt := range tests {
+		path, err := safeJoin(dest, tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("safeJoin(%s) = %s, %v, wantErr %v", tt.name, path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUnpackTarGzTraversal(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "upload.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	data := []byte("evil")
+	if err = tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gzw.Close()
+	f.Close()
+
+	dest := filepath.Join(dir, "data")
+	if err = UnpackTarGz(archive, dest); err == nil {
+		t.Fatal("UnpackTarGz() error = nil, want illegal file path")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+		t.Errorf("file outside dest is written, stat error = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/server/auth"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+const identityKey = "identity"
+
+// authenticate 认证请求, 并将调用者保存到 gin.Context 中
+func authenticate(a *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := a.Authenticate(c.Request)
+		if err != nil {
+			if realm := a.BasicRealm(); realm != "" {
+				c.Header("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			}
+			abortWithStatus(c, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+		c.Set(identityKey, id)
+		c.Next()
+	}
+}
+
+func identity(c *gin.Context) *auth.Identity {
+	return c.MustGet(identityKey).(*auth.Identity)
+}
+
+// requester 记录到证书清单中的申请人, 匿名请求使用客户端地址
+func requester(c *gin.Context) string {
+	id := identity(c)
+	if id.Method == auth.MethodAnonymous {
+		return c.ClientIP()
+	}
+	return id.String()
+}
+
+// checkIssuePermission 检查调用者是否允许签发 csr, 返回解析默认值之后的模板名称
+func checkIssuePermission(c *gin.Context, profileName string, csr *x509.CertificateRequest) (string, error) {
+	p, err := profile.Get(profileName)
+	if err != nil {
+		return "", err
+	}
+	return p.Name, identity(c).CheckRequest(p.Name, csr)
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+/*
+	JSON 接口, 与命令行共用 pkg/ca 的签发以及 CRL 生成逻辑:
+
+	POST /api/certificates                  {"csr": "<PEM>", "profile": "server", "issuer": ""} 签发证书
+	GET  /api/certificates                  ?q=&status=&issuer=&profile= 查询证书清单
+	GET  /api/certificates/:serial          查看证书
+	POST /api/certificates/:serial/revoke   {"reason": "keyCompromise"} 吊销证书并重新生成 CRL
+	GET  /api/ca                            ?issuer=&chain=true 下载签发机构证书 (PEM)
+	GET  /api/crl                           ?issuer= 下载签发机构的 CRL
+
+	查询以及查看证书只包含调用者允许申请范围内 (模板, 组织以及 SAN) 的证书, 与吊销的检查相同.
+
+	出错时返回 {"error": {"code": "...", "message": "..."}} 以及对应的 HTTP 状态码.
+*/
+
+// certAuthority 由 Router 设置, 所有接口共用, 并发签发或吊销时由 CA 串行化, 避免互相覆盖状态文件
+var certAuthority *ca.CA
+
+type issueRequest struct {
+	CSR     string `json:"csr"`
+	Profile string `json:"profile"`
+	Issuer  string `json:"issuer"`
+}
+
+type issueResponse struct {
+	Serial      string `json:"serial"`
+	Issuer      string `json:"issuer"`
+	Profile     string `json:"profile"`
+	Certificate string `json:"certificate"`
+	// Chain 签发机构的证书链, 顺序为 中间 CA -> 根 CA
+	Chain string `json:"chain"`
+}
+
+type revokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+func configDir() string {
+	return certAuthority.ConfigDir()
+}
+
+func handleIssue(c *gin.Context) {
+	var req issueRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid request body: "+err.Error())
+		return
+	}
+	csr, err := parseCsr([]byte(req.CSR))
+	if err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+
+	if !ca.ValidIssuer(req.Issuer) {
+		badRequest(c, "invalid issuer "+req.Issuer)
+		return
+	}
+
+	profileName, err := checkIssuePermission(c, req.Profile, csr)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	cert, err := certAuthority.IssueFromCSR(c.Request.Context(), csr, profileName, ca.IssueOptions{Issuer: req.Issuer, Requester: requester(c)})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, issueResponse{
+		Serial:      store.SerialHex(cert.Cert.SerialNumber),
+		Issuer:      cert.Issuer,
+		Profile:     cert.Profile,
+		Certificate: string(cert.PEM()),
+		Chain:       string(cert.Chain),
+	})
+}
+
+func handleListCertificates(c *gin.Context) {
+	s, err := store.Open(configDir())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	q := store.Query{Text: c.Query("q"), Profile: c.Query("profile"), Status: c.Query("status")}
+	if issuer, ok := c.GetQuery("issuer"); ok {
+		q.Issuer = &issuer
+	}
+
+	now := time.Now()
+	list := make([]store.Record, 0)
+	for _, v := range s.Search(q) {
+		// 只返回调用者有权限查看的证书
+		if checkRead(c, &v) != nil {
+			continue
+		}
+		v.Status = v.State(now)
+		list = append(list, v)
+	}
+	c.JSON(http.StatusOK, gin.H{"certificates": list})
+}
+
+func handleGetCertificate(c *gin.Context) {
+	s, r, ok := findRecord(c)
+	if !ok {
+		return
+	}
+	if err := checkRead(c, r); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	resp := gin.H{"certificate": r}
+	if rv := s.Revocation(r.Issuer, mustParseSerial(r.Serial)); rv != nil {
+		resp["revocation"] = rv
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func handleRevoke(c *gin.Context) {
+	var req revokeRequest
+	// 请求体可以为空, 此时原因为 unspecified
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			badRequest(c, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	reason, err := ca.ParseReason(req.Reason)
+	if err != nil {
+		badRequest(c, err.Error())
+		return
+	}
+
+	_, r, ok := findRecord(c)
+	if !ok {
+		return
+	}
+	// 只能吊销在自己申请范围内的证书
+	cert, err := authority.ParseCert([]byte(r.Certificate))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	if err = identity(c).CheckRevoke(r.Profile, cert); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	rv, err := certAuthority.Revoke(c.Request.Context(), mustParseSerial(r.Serial), ca.RevokeOptions{Issuer: r.Issuer, Reason: reason})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	r.Status = store.StatusRevoked
+	c.JSON(http.StatusOK, gin.H{"certificate": r, "revocation": rv})
+}
+
+func handleDownloadCA(c *gin.Context) {
+	issuer, ok := issuerQuery(c)
+	if !ok {
+		return
+	}
+	cert, err := authority.LoadCert(configDir(), issuer)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if c.Query("chain") == "true" && issuer != "" {
+		// 中间 CA 的证书链已经包含了自身的证书
+		if b, err = os.ReadFile(filepath.Join(authority.Dir(configDir(), issuer), authority.ChainFile)); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/x-pem-file", b)
+}
+
+func handleDownloadCRL(c *gin.Context) {
+	issuer, ok := issuerQuery(c)
+	if !ok {
+		return
+	}
+	// 优先使用配置的格式, 生成 CRL 时会删除另一种格式的文件
+	files := []string{ca.CRLFileDER, ca.CRLFilePEM}
+	if viper.GetString("crl.format") == ca.FormatPEM {
+		files = []string{ca.CRLFilePEM, ca.CRLFileDER}
+	}
+	dir := authority.Dir(configDir(), issuer)
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		contentType := "application/pkix-crl"
+		if name == ca.CRLFilePEM {
+			contentType = "application/x-pem-file"
+		}
+		c.Data(http.StatusOK, contentType, b)
+		return
+	}
+	abortWithStatus(c, http.StatusNotFound, "not_found", "crl of "+authority.DisplayName(issuer)+" not found")
+}
+
+// issuerQuery 读取查询参数中的签发机构名称, 为空时为根 CA
+func issuerQuery(c *gin.Context) (string, bool) {
+	issuer := c.Query("issuer")
+	if !ca.ValidIssuer(issuer) {
+		badRequest(c, "invalid issuer "+issuer)
+		return "", false
+	}
+	return issuer, true
+}
+
+// findRecord 根据路径中的序列号查找证书记录, 找不到时返回错误响应
+func findRecord(c *gin.Context) (*store.Store, *store.Record, bool) {
+	serial, err := store.ParseSerial(strings.TrimPrefix(strings.ToLower(c.Param("serial")), "0x"))
+	if err != nil {
+		badRequest(c, err.Error())
+		return nil, nil, false
+	}
+	s, err := store.Open(configDir())
+	if err != nil {
+		abortWithError(c, err)
+		return nil, nil, false
+	}
+	r, err := s.Get(serial)
+	if err != nil {
+		abortWithError(c, err)
+		return nil, nil, false
+	}
+	r.Status = r.State(time.Now())
+	return s, r, true
+}
+
+// checkRead 检查调用者是否允许查看证书记录, 范围与吊销相同, 参考 auth.Permission.CheckRead
+func checkRead(c *gin.Context, r *store.Record) error {
+	cert, err := authority.ParseCert([]byte(r.Certificate))
+	if err != nil {
+		return err
+	}
+	return identity(c).CheckRead(r.Profile, cert)
+}
+
+// mustParseSerial 解析证书清单中记录的序列号, 记录中的序列号总是合法的
+func mustParseSerial(s string) *big.Int {
+	serial, _ := store.ParseSerial(s)
+	return serial
+}
+
+// parseCsr 解码 PEM 格式的 csr
+func parseCsr(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("type is not CERTIFICATE REQUEST")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
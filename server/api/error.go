@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/profile"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/jaronnie/jcert-gm/server/auth"
+	"github.com/pkg/errors"
+)
+
+// errorBody 出错时的响应
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Violations csr 不满足模板或签发策略的所有项
+	Violations []string `json:"violations,omitempty"`
+}
+
+func abortWithStatus(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, errorBody{Error: errorDetail{Code: code, Message: message}})
+}
+
+func badRequest(c *gin.Context, message string) {
+	abortWithStatus(c, http.StatusBadRequest, "bad_request", message)
+}
+
+// abortWithError 根据错误类型返回对应的状态码
+func abortWithError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrForbidden):
+		abortWithStatus(c, http.StatusForbidden, "forbidden", err.Error())
+	case errors.Is(err, ca.ErrInvalidIssuer):
+		abortWithStatus(c, http.StatusBadRequest, "bad_request", err.Error())
+	case errors.Is(err, profile.ErrNotFound):
+		abortWithStatus(c, http.StatusBadRequest, "unknown_profile", err.Error())
+	case errors.Is(err, ca.ErrRejected):
+		detail := errorDetail{Code: "csr_rejected", Message: err.Error()}
+		var report *policy.Report
+		if errors.As(err, &report) {
+			detail.Message = "csr rejected by profile " + report.Profile + " and policy"
+			detail.Violations = report.Violations
+		}
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errorBody{Error: detail})
+	case errors.Is(err, store.ErrNotFound), os.IsNotExist(errors.Cause(err)):
+		abortWithStatus(c, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, store.ErrAlreadyRevoked):
+		abortWithStatus(c, http.StatusConflict, "already_revoked", err.Error())
+	default:
+		abortWithStatus(c, http.StatusInternalServerError, "internal_error", err.Error())
+	}
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/certinfo"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/x509"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+	server 的认证, 支持以下方式, 按顺序尝试:
+
+	1. API token: 请求头 Authorization: Bearer <token> 或者 Token: <token>
+	2. HTTP basic: 密码以 bcrypt 保存, 使用 jcert-gm server passwd 生成
+	3. 双向 TLS: 客户端证书由本机构签发, 在有效期内, 未吊销且包含 clientAuth, 按证书指纹或者序列号匹配.
+	   CN 可以由任意能够申请证书的调用者指定, 因此只能作为附加条件
+
+	每个 token, 用户以及客户端证书都可以限制允许申请的模板, 组织以及 SAN, 查询和吊销证书也限制在相同的范围内:
+
+	[server.auth]
+	anonymous = false                       # 允许未认证的请求, 拥有全部权限
+
+	[[server.auth.tokens]]
+	name = "ci"
+	token = "change-me"
+	profiles = ["server", "client"]         # 为空时不限制
+	organizations = ["org1"]                # csr 中的 O 必须都在列表中
+	sans = ["*.org1.example.com", "10.0.0.0/8", "*@org1.example.com"]
+	revoke = false                          # 是否允许吊销证书
+
+	[[server.auth.users]]
+	name = "alice"
+	password = "$2a$10$..."
+
+	[[server.auth.clients]]
+	name = "admin"
+	fingerprint = "5f3c..."                 # 证书 der 编码的 sm3 或 sha256 摘要, 十六进制, 与 serial 至少配置一项
+	serial = "1a2b..."                      # 证书序列号, 十六进制
+	issuer = "ops"                          # 签发机构, 为空时不限制
+	cn = "admin"                            # 为空时不检查
+	revoke = true
+
+	未配置任何认证方式且未开启 anonymous 时, 除了下载 CA 证书以及 CRL, 所有接口都会拒绝访问.
+*/
+
+var (
+	// ErrUnauthorized 未认证或认证失败
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden 没有权限
+	ErrForbidden = errors.New("forbidden")
+)
+
+const (
+	MethodAnonymous = "anonymous"
+	MethodToken     = "token"
+	MethodBasic     = "basic"
+	MethodCert      = "cert"
+)
+
+// Identity 认证后的调用者
+type Identity struct {
+	Name   string
+	Method string
+	Permission
+}
+
+// String 记录到证书清单中的申请人
+func (i *Identity) String() string {
+	return i.Method + ":" + i.Name
+}
+
+type token struct {
+	Name       string `mapstructure:"name"`
+	Token      string `mapstructure:"token"`
+	Permission `mapstructure:",squash"`
+}
+
+type user struct {
+	Name       string `mapstructure:"name"`
+	Password   string `mapstructure:"password"`
+	Permission `mapstructure:",squash"`
+}
+
+type client struct {
+	Name        string  `mapstructure:"name"`
+	Fingerprint string  `mapstructure:"fingerprint"`
+	Serial      string  `mapstructure:"serial"`
+	Issuer      *string `mapstructure:"issuer"`
+	CN          string  `mapstructure:"cn"`
+	Permission  `mapstructure:",squash"`
+}
+
+// match 证书需要匹配所有配置了的条件
+func (c client) match(cert *x509.Certificate, issuer string) bool {
+	if c.Fingerprint != "" {
+		fingerprint := strings.ToLower(strings.ReplaceAll(c.Fingerprint, ":", ""))
+		f := certinfo.NewFingerprints(cert.Raw)
+		if subtle.ConstantTimeCompare([]byte(fingerprint), []byte(f.SM3)) != 1 &&
+			subtle.ConstantTimeCompare([]byte(fingerprint), []byte(f.SHA256)) != 1 {
+			return false
+		}
+	}
+	if c.Serial != "" {
+		serial, err := store.ParseSerial(strings.TrimPrefix(strings.ToLower(c.Serial), "0x"))
+		if err != nil || serial.Cmp(cert.SerialNumber) != 0 {
+			return false
+		}
+	}
+	if c.Issuer != nil && *c.Issuer != issuer {
+		return false
+	}
+	return c.CN == "" || c.CN == cert.Subject.CommonName
+}
+
+func (c client) name() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.CN != "":
+		return c.CN
+	case c.Serial != "":
+		return c.Serial
+	}
+	return c.Fingerprint
+}
+
+type config struct {
+	Anonymous bool     `mapstructure:"anonymous"`
+	Tokens    []token  `mapstructure:"tokens"`
+	Users     []user   `mapstructure:"users"`
+	Clients   []client `mapstructure:"clients"`
+}
+
+// Authenticator 根据配置认证请求
+type Authenticator struct {
+	configDir string
+	config
+}
+
+// New 读取配置项 server.auth
+func New(configDir string) (*Authenticator, error) {
+	a := &Authenticator{configDir: configDir}
+	if err := viper.UnmarshalKey("server.auth", &a.config); err != nil {
+		return nil, errors.Wrap(err, "server.auth")
+	}
+
+	names := make(map[string]bool)
+	for _, v := range a.Tokens {
+		if v.Name == "" || v.Token == "" {
+			return nil, errors.New("server.auth.tokens: name and token must be set")
+		}
+		if names[MethodToken+v.Name] {
+			return nil, errors.Errorf("server.auth.tokens: duplicate name %s", v.Name)
+		}
+		names[MethodToken+v.Name] = true
+	}
+	for _, v := range a.Users {
+		if v.Name == "" {
+			return nil, errors.New("server.auth.users: name must be set")
+		}
+		if _, err := bcrypt.Cost([]byte(v.Password)); err != nil {
+			return nil, errors.Wrapf(err, "server.auth.users: password of %s must be a bcrypt hash", v.Name)
+		}
+		if names[MethodBasic+v.Name] {
+			return nil, errors.Errorf("server.auth.users: duplicate name %s", v.Name)
+		}
+		names[MethodBasic+v.Name] = true
+	}
+	for _, v := range a.Clients {
+		if v.Fingerprint == "" && v.Serial == "" {
+			return nil, errors.Errorf("server.auth.clients: fingerprint or serial of %s must be set", v.name())
+		}
+		if v.Serial != "" {
+			if _, err := store.ParseSerial(strings.TrimPrefix(strings.ToLower(v.Serial), "0x")); err != nil {
+				return nil, errors.Wrapf(err, "server.auth.clients: serial of %s", v.name())
+			}
+		}
+	}
+	for _, p := range a.permissions() {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *Authenticator) permissions() []Permission {
+	var list []Permission
+	for _, v := range a.Tokens {
+		list = append(list, v.Permission)
+	}
+	for _, v := range a.Users {
+		list = append(list, v.Permission)
+	}
+	for _, v := range a.Clients {
+		list = append(list, v.Permission)
+	}
+	return list
+}
+
+// BasicRealm 配置了用户时返回 basic 认证的 realm, 用于 WWW-Authenticate 响应头
+func (a *Authenticator) BasicRealm() string {
+	if len(a.Users) == 0 {
+		return ""
+	}
+	return "jcert-gm"
+}
+
+// Authenticate 认证请求, 请求中携带了错误的凭证时不会再尝试其他方式
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if t := requestToken(r); t != "" {
+		for _, v := range a.Tokens {
+			if subtle.ConstantTimeCompare([]byte(t), []byte(v.Token)) == 1 {
+				return &Identity{Name: v.Name, Method: MethodToken, Permission: v.Permission}, nil
+			}
+		}
+		return nil, errors.Wrap(ErrUnauthorized, "invalid token")
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		for _, v := range a.Users {
+			if v.Name != name {
+				continue
+			}
+			if bcrypt.CompareHashAndPassword([]byte(v.Password), []byte(password)) != nil {
+				break
+			}
+			return &Identity{Name: v.Name, Method: MethodBasic, Permission: v.Permission}, nil
+		}
+		return nil, errors.Wrap(ErrUnauthorized, "invalid username or password")
+	}
+
+	if certs := PeerCertificates(r); len(certs) > 0 && len(a.Clients) > 0 {
+		cert, issuer, err := a.verifyClientCert(certs[0])
+		if err != nil {
+			return nil, errors.Wrapf(ErrUnauthorized, "client certificate: %v", err)
+		}
+		for _, v := range a.Clients {
+			if v.match(cert, issuer) {
+				return &Identity{Name: v.name(), Method: MethodCert, Permission: v.Permission}, nil
+			}
+		}
+		return nil, errors.Wrapf(ErrUnauthorized, "client certificate %s is not allowed", store.SerialHex(cert.SerialNumber))
+	}
+
+	if a.Anonymous {
+		return &Identity{Name: MethodAnonymous, Method: MethodAnonymous, Permission: Permission{Revoke: true}}, nil
+	}
+	return nil, errors.Wrap(ErrUnauthorized, "credentials required")
+}
+
+func requestToken(r *http.Request) string {
+	if v := r.Header.Get("Authorization"); len(v) > 7 && strings.EqualFold(v[:7], "Bearer ") {
+		return strings.TrimSpace(v[7:])
+	}
+	return strings.TrimSpace(r.Header.Get("Token"))
+}
+
+// verifyClientCert 客户端证书必须由本机构的签发机构签发, 在有效期内, 未吊销且允许用于客户端认证, 返回证书以及签发机构名称
+func (a *Authenticator) verifyClientCert(der []byte) (*x509.Certificate, string, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, "", errors.New("expired or not yet valid")
+	}
+	if !hasClientAuth(cert) {
+		return nil, "", errors.New("no clientAuth extended key usage")
+	}
+
+	names, err := authority.List(a.configDir)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, name := range names {
+		issuer, err := authority.LoadCert(a.configDir, name)
+		if err != nil {
+			return nil, "", err
+		}
+		if cert.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+
+		s, err := store.Open(a.configDir)
+		if err != nil {
+			return nil, "", err
+		}
+		if s.Revocation(name, cert.SerialNumber) != nil {
+			return nil, "", errors.New("revoked")
+		}
+		return cert, name, nil
+	}
+	return nil, "", errors.New("not issued by this ca")
+}
+
+func hasClientAuth(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 {
+		return true
+	}
+	for _, v := range cert.ExtKeyUsage {
+		if v == x509.ExtKeyUsageClientAuth || v == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+type peerCertificatesKey struct{}
+
+// WithPeerCertificates 保存获取连接中 der 格式客户端证书的函数, 用于 crypto/tls 之外的 TLS 实现 (如 TLCP).
+// 建立连接时握手尚未完成, 因此在处理请求时才获取证书.
+func WithPeerCertificates(ctx context.Context, certs func() [][]byte) context.Context {
+	return context.WithValue(ctx, peerCertificatesKey{}, certs)
+}
+
+// PeerCertificates 返回请求所在连接的客户端证书, 第一个为客户端自身的证书
+func PeerCertificates(r *http.Request) [][]byte {
+	if certs, ok := r.Context().Value(peerCertificatesKey{}).(func() [][]byte); ok {
+		return certs()
+	}
+	if r.TLS == nil {
+		return nil
+	}
+	var certs [][]byte
+	for _, v := range r.TLS.PeerCertificates {
+		certs = append(certs, v.Raw)
+	}
+	return certs
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/x509/pkix"
+	"net"
+
+	"github.com/jaronnie/jcert-gm/pkg/policy"
+	"github.com/jaronnie/jcert-gm/pkg/san"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// Permission 调用者允许申请的证书, 为空的列表不限制
+type Permission struct {
+	// Profiles 允许使用的模板
+	Profiles []string `mapstructure:"profiles"`
+	// Organizations csr 中的 O 必须都在列表中
+	Organizations []string `mapstructure:"organizations"`
+	// SANs csr 中的每个 SAN 都必须匹配其中一项, 支持通配符 (path.Match) 以及 CIDR
+	SANs []string `mapstructure:"sans"`
+	// Revoke 是否允许吊销证书
+	Revoke bool `mapstructure:"revoke"`
+}
+
+func (p Permission) validate() error {
+	return errors.Wrap(policy.ValidatePatterns(p.SANs), "server.auth")
+}
+
+// CheckRequest 检查是否允许使用模板 profileName 签发 csr, profileName 必须是解析默认值之后的模板名称
+func (p Permission) CheckRequest(profileName string, csr *x509.CertificateRequest) error {
+	if len(p.Profiles) > 0 && !contains(p.Profiles, profileName) {
+		return errors.Wrapf(ErrForbidden, "profile %s is not allowed", profileName)
+	}
+	uris, _ := san.URIs(csr.Extensions)
+	return p.checkNames(csr.Subject, san.Names{
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           uris,
+	})
+}
+
+// CheckRevoke 检查是否允许吊销使用模板 profileName 签发的证书, 证书的组织以及 SAN 需要在允许申请的范围内.
+// CA 证书 (根 CA 以及中间 CA) 只有在 profiles 中明确列出其模板时才允许吊销.
+func (p Permission) CheckRevoke(profileName string, cert *x509.Certificate) error {
+	if !p.Revoke {
+		return errors.Wrap(ErrForbidden, "revoke is not allowed")
+	}
+	if cert.IsCA && !contains(p.Profiles, profileName) {
+		return errors.Wrapf(ErrForbidden, "revoke ca certificate of profile %s is not allowed", profileName)
+	}
+	return p.CheckRead(profileName, cert)
+}
+
+// CheckRead 检查是否允许查看使用模板 profileName 签发的证书, 与吊销相同, 证书需要在允许申请的范围内,
+// 避免调用者通过证书清单查看其他组织的证书以及申请人
+func (p Permission) CheckRead(profileName string, cert *x509.Certificate) error {
+	if len(p.Profiles) > 0 && !contains(p.Profiles, profileName) {
+		return errors.Wrapf(ErrForbidden, "profile %s is not allowed", profileName)
+	}
+	uris, _ := san.URIs(cert.Extensions)
+	return p.checkNames(cert.Subject, san.Names{
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           uris,
+	})
+}
+
+// checkNames 检查主题中的 O 以及 CN 和所有 SAN, CN 与 SAN 使用相同的规则,
+// 因为没有 DNS SAN 时主机名校验会使用 CN, 并且双向 TLS 的客户端可能以 CN 作为标识
+func (p Permission) checkNames(subject pkix.Name, names san.Names) error {
+	if len(p.Organizations) > 0 {
+		if len(subject.Organization) == 0 {
+			return errors.Wrap(ErrForbidden, "organization is required")
+		}
+		for _, v := range subject.Organization {
+			if !contains(p.Organizations, v) {
+				return errors.Wrapf(ErrForbidden, "organization %s is not allowed", v)
+			}
+		}
+	}
+
+	if len(p.SANs) > 0 {
+		if cn := subject.CommonName; cn != "" && !p.matchSAN(cn, net.ParseIP(cn)) {
+			return errors.Wrapf(ErrForbidden, "common name %s is not allowed", cn)
+		}
+		for _, v := range names.DNSNames {
+			if !p.matchSAN(v, nil) {
+				return errors.Wrapf(ErrForbidden, "dns %s is not allowed", v)
+			}
+		}
+		for _, v := range names.EmailAddresses {
+			if !p.matchSAN(v, nil) {
+				return errors.Wrapf(ErrForbidden, "email %s is not allowed", v)
+			}
+		}
+		for _, v := range names.IPAddresses {
+			if !p.matchSAN(v.String(), v) {
+				return errors.Wrapf(ErrForbidden, "ip %s is not allowed", v)
+			}
+		}
+		for _, v := range names.URIs {
+			if !policy.MatchURI(p.SANs, v.String()) {
+				return errors.Wrapf(ErrForbidden, "uri %s is not allowed", v)
+			}
+		}
+	}
+	return nil
+}
+
+func (p Permission) matchSAN(san string, ip net.IP) bool {
+	return policy.MatchSAN(p.SANs, san, ip)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/certinfo"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+func newCSR(t *testing.T, subject pkix.Name, dns []string, ips []net.IP) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ca.NewKey(ca.KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := ca.NewCSR(key, ca.CSROptions{Subject: subject, DNSNames: dns, IPAddresses: ips})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestPermissionCheckRequest(t *testing.T) {
+	p := Permission{
+		Profiles:      []string{"client"},
+		Organizations: []string{"org1"},
+		SANs:          []string{"*.org1.example.com", "10.0.0.0/8"},
+	}
+	org1 := []string{"org1"}
+
+	tests := []struct {
+		name    string
+		profile string
+		subject pkix.Name
+		dns     []string
+		ips     []net.IP
+		ok      bool
+	}{
+		{name: "allowed", profile: "client", subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: org1}, dns: []string{"node1.org1.example.com"}, ok: true},
+		{name: "ip allowed", profile: "client", subject: pkix.Name{CommonName: "10.0.0.1", Organization: org1}, ips: []net.IP{net.ParseIP("10.0.0.1")}, ok: true},
+		{name: "profile", profile: "server", subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: org1}},
+		{name: "organization required", profile: "client", subject: pkix.Name{CommonName: "node1.org1.example.com"}},
+		{name: "organization", profile: "client", subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: []string{"org2"}}},
+		{name: "common name", profile: "client", subject: pkix.Name{CommonName: "admin", Organization: org1}},
+		{name: "dns", profile: "client", subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: org1}, dns: []string{"evil.org"}},
+		{name: "ip", profile: "client", subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: org1}, ips: []net.IP{net.ParseIP("192.168.0.1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.CheckRequest(tt.profile, newCSR(t, tt.subject, tt.dns, tt.ips))
+			if (err == nil) != tt.ok {
+				t.Fatalf("CheckRequest() error = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrForbidden) {
+				t.Fatalf("CheckRequest() error = %v, want ErrForbidden", err)
+			}
+		})
+	}
+}
+
+func TestPermissionCheckRevoke(t *testing.T) {
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: []string{"org1"}}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "node1.org2.example.com", Organization: []string{"org2"}}}
+	intermediate := &x509.Certificate{Subject: pkix.Name{CommonName: "Ops CA"}, IsCA: true}
+
+	scoped := Permission{Organizations: []string{"org1"}, Revoke: true}
+	tests := []struct {
+		name       string
+		permission Permission
+		profile    string
+		cert       *x509.Certificate
+		ok         bool
+	}{
+		{name: "not allowed", permission: Permission{}, profile: "server", cert: leaf},
+		{name: "unrestricted", permission: Permission{Revoke: true}, profile: "server", cert: leaf, ok: true},
+		{name: "own organization", permission: scoped, profile: "server", cert: leaf, ok: true},
+		{name: "other organization", permission: scoped, profile: "server", cert: other},
+		{name: "profile", permission: Permission{Profiles: []string{"client"}, Revoke: true}, profile: "server", cert: leaf},
+		{name: "ca", permission: Permission{Revoke: true}, profile: "intermediate", cert: intermediate},
+		{name: "ca listed", permission: Permission{Profiles: []string{"intermediate"}, Revoke: true}, profile: "intermediate", cert: intermediate, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.permission.CheckRevoke(tt.profile, tt.cert)
+			if (err == nil) != tt.ok {
+				t.Fatalf("CheckRevoke() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPermissionCheckRead(t *testing.T) {
+	leaf := &x509.Certificate{Subject: pkix.Name{CommonName: "node1.org1.example.com", Organization: []string{"org1"}}}
+	other := &x509.Certificate{Subject: pkix.Name{CommonName: "node1.org2.example.com", Organization: []string{"org2"}}}
+
+	tests := []struct {
+		name       string
+		permission Permission
+		profile    string
+		cert       *x509.Certificate
+		ok         bool
+	}{
+		// 查看不需要吊销权限
+		{name: "unrestricted", permission: Permission{}, profile: "server", cert: leaf, ok: true},
+		{name: "own organization", permission: Permission{Organizations: []string{"org1"}}, profile: "server", cert: leaf, ok: true},
+		{name: "other organization", permission: Permission{Organizations: []string{"org1"}}, profile: "server", cert: other},
+		{name: "san", permission: Permission{SANs: []string{"*.org2.example.com"}}, profile: "server", cert: leaf},
+		{name: "profile", permission: Permission{Profiles: []string{"client"}}, profile: "server", cert: leaf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.permission.CheckRead(tt.profile, tt.cert)
+			if (err == nil) != tt.ok {
+				t.Fatalf("CheckRead() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClientMatch(t *testing.T) {
+	raw := []byte("client certificate")
+	cert := &x509.Certificate{Raw: raw, SerialNumber: big.NewInt(0x1a2b), Subject: pkix.Name{CommonName: "admin"}}
+	f := certinfo.NewFingerprints(raw)
+	ops := "ops"
+
+	tests := []struct {
+		name   string
+		client client
+		issuer string
+		ok     bool
+	}{
+		{name: "sm3 fingerprint", client: client{Fingerprint: strings.ToUpper(f.SM3)}, ok: true},
+		{name: "sha256 fingerprint", client: client{Fingerprint: f.SHA256}, ok: true},
+		{name: "other fingerprint", client: client{Fingerprint: strings.Repeat("0", 64)}},
+		{name: "serial", client: client{Serial: "0x1a2b"}, ok: true},
+		{name: "other serial", client: client{Serial: "1a2c"}},
+		{name: "issuer", client: client{Serial: "1a2b", Issuer: &ops}, issuer: "ops", ok: true},
+		{name: "other issuer", client: client{Serial: "1a2b", Issuer: &ops}, issuer: ""},
+		{name: "cn", client: client{Serial: "1a2b", CN: "admin"}, ok: true},
+		{name: "other cn", client: client{Serial: "1a2b", CN: "root"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok := tt.client.match(cert, tt.issuer); ok != tt.ok {
+				t.Fatalf("match() = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
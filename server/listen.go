@@ -0,0 +1,439 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaronnie/jcert-gm/pkg/authority"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/keyfile"
+	"github.com/jaronnie/jcert-gm/server/auth"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/gmtls"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+
+	stdx509 "crypto/x509"
+)
+
+/*
+	server 的监听地址以及 TLS/TLCP 证书:
+
+	[server]
+	addr = ":9999"                       # HTTP, 为空时不监听
+	hosts = ["localhost", "127.0.0.1"]   # 自动签发证书的 SAN, 默认为 localhost, 127.0.0.1 以及主机名
+	issuer = ""                          # 签发 server 证书的中间 CA, 默认为根 CA
+	clientAuth = "none"                  # none, request, require, 客户端证书必须由本机构签发
+
+	[server.tls]
+	addr = ":9443"                       # 标准 TLS, 证书使用 ECDSA P-256 密钥, 由本机构的 SM2 CA 签发
+	cert = ""                            # 未指定时自动签发
+	key = ""
+
+	[server.tlcp]
+	addr = ":9444"                       # TLCP (GM/T 0024), 使用 SM2 签名证书和加密证书, 由 tjfoc/gmsm 的 gmtls 实现
+	signCert = ""                        # 未指定时自动签发
+	signKey = ""
+	encCert = ""
+	encKey = ""
+
+	自动签发的证书保存在配置目录的 server 下, 不存在, 即将过期, 不是由当前签发机构签发或者 SAN 与 hosts 不一致时重新签发.
+	标准 TLS 无法解析 SM2 客户端证书, 客户端证书认证通常使用 TLCP.
+*/
+
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+
+	// renewBefore 证书在过期前多久重新签发
+	renewBefore = 7 * 24 * time.Hour
+)
+
+// listenAndServe 根据配置同时监听 HTTP, TLS 以及 TLCP, 任意一个出错时返回
+func listenAndServe(c *ca.CA, handler http.Handler) error {
+	var servers []func() error
+
+	if addr := viper.GetString("server.addr"); addr != "" {
+		servers = append(servers, func() error {
+			fmt.Printf("listen http on %s\n", addr)
+			return http.ListenAndServe(addr, handler)
+		})
+	}
+
+	if addr := viper.GetString("server.tls.addr"); addr != "" {
+		config, err := tlsConfig(c)
+		if err != nil {
+			return errors.Wrap(err, "tls")
+		}
+		srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: config}
+		servers = append(servers, func() error {
+			fmt.Printf("listen tls on %s\n", addr)
+			return srv.ListenAndServeTLS("", "")
+		})
+	}
+
+	if addr := viper.GetString("server.tlcp.addr"); addr != "" {
+		config, err := tlcpConfig(c)
+		if err != nil {
+			return errors.Wrap(err, "tlcp")
+		}
+		srv := &http.Server{Addr: addr, Handler: handler, ConnContext: tlcpConnContext}
+		servers = append(servers, func() error {
+			ln, err := gmtls.Listen("tcp", addr, config)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("listen tlcp on %s\n", addr)
+			return srv.Serve(ln)
+		})
+	}
+
+	if len(servers) == 0 {
+		return errors.New("no listen address, set at least one of server.addr, server.tls.addr and server.tlcp.addr")
+	}
+
+	errCh := make(chan error, len(servers))
+	for _, v := range servers {
+		go func(serve func() error) {
+			errCh <- serve()
+		}(v)
+	}
+	return <-errCh
+}
+
+// tlcpConnContext TLCP 连接不会设置 http.Request.TLS, 通过 context 向认证传递客户端证书
+func tlcpConnContext(ctx context.Context, c net.Conn) context.Context {
+	conn, ok := c.(*gmtls.Conn)
+	if !ok {
+		return ctx
+	}
+	return auth.WithPeerCertificates(ctx, func() [][]byte {
+		var certs [][]byte
+		for _, v := range conn.ConnectionState().PeerCertificates {
+			certs = append(certs, v.Raw)
+		}
+		return certs
+	})
+}
+
+func clientAuth() (string, error) {
+	switch v := viper.GetString("server.clientAuth"); v {
+	case "", ClientAuthNone:
+		return ClientAuthNone, nil
+	case ClientAuthRequest, ClientAuthRequire:
+		return v, nil
+	default:
+		return "", errors.Errorf("not support client auth %s, support none, request and require", v)
+	}
+}
+
+func tlsConfig(c *ca.CA) (*tls.Config, error) {
+	mode, err := clientAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	var cert tls.Certificate
+	if certFile := viper.GetString("server.tls.cert"); certFile != "" {
+		if cert, err = tls.LoadX509KeyPair(certFile, viper.GetString("server.tls.key")); err != nil {
+			return nil, err
+		}
+	} else {
+		certPEM, key, err := serverCert(c, "tls", "server", false)
+		if err != nil {
+			return nil, err
+		}
+		cert = tls.Certificate{Certificate: leafCert(certPEM), PrivateKey: key}
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if mode == ClientAuthNone {
+		return config, nil
+	}
+
+	// 客户端证书由 SM2 CA 签发, crypto/tls 无法校验, 使用 tjfoc/gmsm 校验证书链
+	config.ClientAuth = tls.RequestClientCert
+	if mode == ClientAuthRequire {
+		config.ClientAuth = tls.RequireAnyClientCert
+	}
+	pool, err := authorityPool(c.ConfigDir())
+	if err != nil {
+		return nil, err
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*stdx509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+		return verifyClientCert(pool, rawCerts)
+	}
+	return config, nil
+}
+
+func tlcpConfig(c *ca.CA) (*gmtls.Config, error) {
+	mode, err := clientAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	sign, err := tlcpCert(c, "tlcp.sign", "tlcp-sign", "server.tlcp.signCert", "server.tlcp.signKey")
+	if err != nil {
+		return nil, err
+	}
+	enc, err := tlcpCert(c, "tlcp.enc", "tlcp-enc", "server.tlcp.encCert", "server.tlcp.encKey")
+	if err != nil {
+		return nil, err
+	}
+
+	// 第一个为签名证书, 第二个为加密证书
+	config := &gmtls.Config{
+		GMSupport:    gmtls.NewGMSupport(),
+		Certificates: []gmtls.Certificate{sign, enc},
+	}
+	switch mode {
+	case ClientAuthRequest:
+		config.ClientAuth = gmtls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		config.ClientAuth = gmtls.RequireAndVerifyClientCert
+	}
+	if mode != ClientAuthNone {
+		if config.ClientCAs, err = authorityPool(c.ConfigDir()); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func tlcpCert(c *ca.CA, name string, profileName string, certKey string, keyKey string) (gmtls.Certificate, error) {
+	var (
+		certPEM []byte
+		key     *sm2.PrivateKey
+		err     error
+	)
+	if certFile := viper.GetString(certKey); certFile != "" {
+		if certPEM, err = os.ReadFile(certFile); err != nil {
+			return gmtls.Certificate{}, err
+		}
+		if key, err = keyfile.ReadFile(viper.GetString(keyKey), keyfile.LeafPassphrase()); err != nil {
+			return gmtls.Certificate{}, err
+		}
+	} else {
+		var signer interface{}
+		if certPEM, signer, err = serverCert(c, name, profileName, true); err != nil {
+			return gmtls.Certificate{}, err
+		}
+		key = signer.(*sm2.PrivateKey)
+	}
+	return gmtls.Certificate{Certificate: leafCert(certPEM), PrivateKey: key}, nil
+}
+
+// serverCert 读取配置目录 server 下自动签发的证书和私钥, 需要时重新签发. gm 为 true 时使用 SM2 密钥, 否则使用 ECDSA P-256 密钥
+func serverCert(c *ca.CA, name string, profileName string, gm bool) ([]byte, interface{}, error) {
+	configDir := c.ConfigDir()
+	dir := filepath.Join(configDir, "server")
+	certPath, keyPath := filepath.Join(dir, name+".cert"), filepath.Join(dir, name+".key")
+
+	issuerName := viper.GetString("server.issuer")
+	hosts, err := serverHosts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if certPEM, err := os.ReadFile(certPath); err == nil {
+		if validServerCert(configDir, issuerName, certPEM, hosts) {
+			key, err := readServerKey(keyPath, gm)
+			if err == nil {
+				return certPEM, key, nil
+			}
+			if !os.IsNotExist(errors.Cause(err)) {
+				return nil, nil, err
+			}
+		}
+	}
+
+	fmt.Printf("issue server certificate %s\n", certPath)
+	opts := ca.CSROptions{Subject: pkix.Name{CommonName: hosts[0]}}
+	for _, v := range hosts {
+		if ip := net.ParseIP(v); ip != nil {
+			opts.IPAddresses = append(opts.IPAddresses, ip)
+		} else {
+			opts.DNSNames = append(opts.DNSNames, v)
+		}
+	}
+
+	algorithm := ca.KeyECDSAP256
+	if gm {
+		algorithm = ca.KeySM2
+	}
+	key, err := ca.NewKey(ca.KeyOptions{Algorithm: algorithm})
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyPEM []byte
+	switch k := key.(type) {
+	case *sm2.PrivateKey:
+		if keyPEM, err = keyfile.Encode(k, keyfile.LeafPassphrase()); err != nil {
+			return nil, nil, err
+		}
+	default:
+		b, err := stdx509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+	}
+
+	csr, err := ca.NewCSR(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := c.IssueFromCSR(context.Background(), csr, profileName, ca.IssueOptions{Issuer: issuerName, Requester: "server"})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 证书文件包含证书链, 顺序为 证书 -> 中间 CA -> 根 CA
+	certPEM, err := cert.Encode(ca.FormatPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	if err = keyfile.WriteFile(keyPath, keyPEM); err != nil {
+		return nil, nil, err
+	}
+	if err = os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, key, nil
+}
+
+func readServerKey(path string, gm bool) (interface{}, error) {
+	if gm {
+		return keyfile.ReadFile(path, keyfile.LeafPassphrase())
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.Errorf("read private key %s: type is not PRIVATE KEY", path)
+	}
+	return stdx509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+// validServerCert 证书未过期, 由当前签发机构签发且 SAN 与 hosts 一致时不需要重新签发
+func validServerCert(configDir string, issuerName string, certPEM []byte, hosts []string) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	issuer, err := authority.LoadCert(configDir, issuerName)
+	if err != nil || cert.CheckSignatureFrom(issuer) != nil {
+		return false
+	}
+
+	sans := append([]string{}, cert.DNSNames...)
+	for _, v := range cert.IPAddresses {
+		sans = append(sans, v.String())
+	}
+	return strings.Join(sortedCopy(sans), ",") == strings.Join(sortedCopy(hosts), ",")
+}
+
+func serverHosts() ([]string, error) {
+	hosts := viper.GetStringSlice("server.hosts")
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1"}
+		if name, err := os.Hostname(); err == nil && name != "" && name != "localhost" {
+			hosts = append(hosts, name)
+		}
+	}
+	for i, v := range hosts {
+		// 与证书中的 ip 格式保持一致
+		if ip := net.ParseIP(v); ip != nil {
+			hosts[i] = ip.String()
+		}
+	}
+	return hosts, nil
+}
+
+func sortedCopy(s []string) []string {
+	s = append([]string{}, s...)
+	sort.Strings(s)
+	return s
+}
+
+// authorityPool 本机构所有签发机构的证书
+func authorityPool(configDir string) (*x509.CertPool, error) {
+	names, err := authority.List(configDir)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, name := range names {
+		cert, err := authority.LoadCert(configDir, name)
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// verifyClientCert 校验客户端证书由本机构签发, 吊销状态在认证时检查
+func verifyClientCert(pool *x509.CertPool, rawCerts [][]byte) error {
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, v := range rawCerts {
+		cert, err := x509.ParseCertificate(v)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, v := range certs[1:] {
+		intermediates.AddCert(v)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+// leafCert 只返回 PEM 中的第一个证书.
+// TLCP 握手中第二个证书是加密证书, 不能发送证书链; 标准 TLS 客户端无法解析 SM2 CA 证书, 同样只发送服务端证书
+func leafCert(b []byte) [][]byte {
+	for {
+		block, rest := pem.Decode(b)
+		if block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			return [][]byte{block.Bytes}
+		}
+		b = rest
+	}
+}
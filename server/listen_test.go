@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/jaronnie/jcert-gm/internal/testcert"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/store"
+	"github.com/spf13/viper"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+)
+
+// newTestCA 在临时目录中创建根 CA, 私钥不加密
+func newTestCA(t *testing.T) *ca.CA {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	dir := t.TempDir()
+	template := testcert.CATemplate("test root", -1)
+	template.SerialNumber = big.NewInt(1)
+	testcert.WriteAuthority(t, dir, "", testcert.New(t, template, nil))
+
+	s, err := store.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.UseSerial(big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ca.NewCA(ca.Options{ConfigDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func parseLeaf(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(leafCert(certPEM)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestServerCert(t *testing.T) {
+	c := newTestCA(t)
+
+	// 按顺序执行, 每一步使用上一步保存的证书
+	tests := []struct {
+		name    string
+		cert    string
+		profile string
+		gm      bool
+		hosts   []string
+		reissue bool
+	}{
+		{name: "issue tls", cert: "tls", profile: "server", hosts: []string{"localhost", "127.0.0.1"}, reissue: true},
+		{name: "reuse tls", cert: "tls", profile: "server", hosts: []string{"127.0.0.1", "localhost"}},
+		{name: "reissue when hosts change", cert: "tls", profile: "server", hosts: []string{"localhost", "::1"}, reissue: true},
+		{name: "issue tlcp sign", cert: "tlcp.sign", profile: "tlcp-sign", gm: true, hosts: []string{"localhost"}, reissue: true},
+		{name: "reuse tlcp sign", cert: "tlcp.sign", profile: "tlcp-sign", gm: true, hosts: []string{"localhost"}},
+	}
+	var last *x509.Certificate
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("server.hosts", tt.hosts)
+			certPEM, key, err := serverCert(c, tt.cert, tt.profile, tt.gm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert := parseLeaf(t, certPEM)
+
+			if reissued := last == nil || cert.SerialNumber.Cmp(last.SerialNumber) != 0; reissued != tt.reissue {
+				t.Errorf("reissued = %v, want %v", reissued, tt.reissue)
+			}
+			last = cert
+
+			switch k := key.(type) {
+			case *sm2.PrivateKey:
+				if !tt.gm || k.X.Cmp(cert.PublicKey.(*ecdsa.PublicKey).X) != 0 {
+					t.Error("sm2 private key does not match cert")
+				}
+			case *ecdsa.PrivateKey:
+				if tt.gm || k.X.Cmp(cert.PublicKey.(*ecdsa.PublicKey).X) != 0 {
+					t.Error("ecdsa private key does not match cert")
+				}
+			default:
+				t.Fatalf("private key = %T", key)
+			}
+
+			hosts, _ := serverHosts()
+			if !validServerCert(c.ConfigDir(), "", certPEM, hosts) {
+				t.Error("validServerCert() = false, want true")
+			}
+		})
+	}
+}
+
+func TestValidServerCert(t *testing.T) {
+	c := newTestCA(t)
+	viper.Set("server.hosts", []string{"localhost"})
+	certPEM, _, err := serverCert(c, "tls", "server", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		issuer  string
+		certPEM []byte
+		hosts   []string
+		want    bool
+	}{
+		{name: "valid", certPEM: certPEM, hosts: []string{"localhost"}, want: true},
+		{name: "hosts", certPEM: certPEM, hosts: []string{"localhost", "127.0.0.1"}},
+		{name: "unknown issuer", issuer: "org1", certPEM: certPEM, hosts: []string{"localhost"}},
+		{name: "not pem", certPEM: []byte("garbage"), hosts: []string{"localhost"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validServerCert(c.ConfigDir(), tt.issuer, tt.certPEM, tt.hosts); got != tt.want {
+				t.Errorf("validServerCert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerHosts(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("server.hosts", []string{"example.com", "::ffff:10.0.0.1", "0:0:0:0:0:0:0:1"})
+	hosts, err := serverHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com", "10.0.0.1", "::1"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("serverHosts() = %v, want %v", hosts, want)
+	}
+
+	viper.Set("server.hosts", nil)
+	if hosts, _ = serverHosts(); len(hosts) < 2 || hosts[0] != "localhost" || hosts[1] != "127.0.0.1" {
+		t.Errorf("serverHosts() = %v, want localhost and 127.0.0.1 first", hosts)
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	for v, want := range map[string]string{"": ClientAuthNone, "none": ClientAuthNone, "request": ClientAuthRequest, "require": ClientAuthRequire, "verify": ""} {
+		viper.Set("server.clientAuth", v)
+		got, err := clientAuth()
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("clientAuth(%q) = %s, %v, want %s", v, got, err, want)
+		}
+	}
+}
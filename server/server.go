@@ -2,23 +2,36 @@ package server
 
 import (
 	"net/http"
+	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jaronnie/jcert-gm/pkg/ca"
+	"github.com/jaronnie/jcert-gm/pkg/ocsp"
 	"github.com/jaronnie/jcert-gm/public"
 	"github.com/jaronnie/jcert-gm/server/api"
+	"github.com/jaronnie/jcert-gm/server/auth"
 	"github.com/jaronnie/jcert-gm/server/static"
+	"github.com/spf13/viper"
 )
 
-// 解决跨域问题
+// 解决跨域问题, 允许的域名通过配置项 server.allowOrigins 指定, 未配置时允许所有域名
 func Cors() gin.HandlerFunc {
+	origins := viper.GetStringSlice("server.allowOrigins")
 	return func(c *gin.Context) {
 		method := c.Request.Method
 		// 必须，指定允许的域名
-		c.Header("Access-Control-Allow-Origin", "*")
+		if len(origins) == 0 {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); allowOrigin(origins, origin) {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+		}
 		// 可选，指定允许的请求方式
 		c.Header("Access-Control-Allow-Methods", "GET,POST,DELETE,OPTIONS,PUT,PATCH")
 		// 可选，指定自定义 header 参数，多个用 , 隔开
-		c.Header("Access-Control-Allow-Headers", "Token")
+		c.Header("Access-Control-Allow-Headers", "Token,Authorization,Content-Type")
 		// 可选，指定是否允许携带 cookie
 		c.Header("Access-Control-Allow-Credentials", "true")
 		// 可选，指定时间内减少发送「预检」请求
@@ -31,7 +44,28 @@ func Cors() gin.HandlerFunc {
 	}
 }
 
+func allowOrigin(origins []string, origin string) bool {
+	for _, v := range origins {
+		if v == origin {
+			return true
+		}
+	}
+	return false
+}
+
 func RunServer() error {
+	configDir := filepath.Dir(viper.ConfigFileUsed())
+
+	// 认证配置有误时拒绝启动, 避免以无认证的方式对外提供服务
+	authenticator, err := auth.New(configDir)
+	if err != nil {
+		return err
+	}
+	c, err := ca.NewCA(ca.Options{ConfigDir: configDir})
+	if err != nil {
+		return err
+	}
+
 	e := gin.Default()
 	e.Use(Cors())
 	// redirect 到 /ui
@@ -43,7 +77,24 @@ func RunServer() error {
 	static.Static(gen, public.Public)
 
 	apiv1 := e.Group("/api")
-	api.Router(apiv1)
+	api.Router(apiv1, authenticator, c)
+
+	// OCSP 服务, 配置与 jcert-gm ocsp 相同
+	responder, err := ocsp.NewResponder(ocsp.Config{
+		ConfigDir:  configDir,
+		CertFile:   viper.GetString("ocsp.cert"),
+		KeyFile:    viper.GetString("ocsp.key"),
+		NextUpdate: viper.GetDuration("ocsp.nextUpdate"),
+		// 与签发证书共用已经打开的私钥
+		Authority: c.Authority,
+	})
+	if err != nil {
+		return err
+	}
+	ocspHandler := gin.WrapH(http.StripPrefix("/ocsp", responder))
+	e.POST("/ocsp", ocspHandler)
+	e.GET("/ocsp/*request", ocspHandler)
+	e.POST("/ocsp/*request", ocspHandler)
 
-	return e.Run(":9999")
+	return listenAndServe(c, e)
 }